@@ -0,0 +1,25 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.21
+// +build go1.21
+
+package mysql
+
+import "context"
+
+// contextWatcher needs no state, as context.AfterFunc attaches the callbacks
+// to the contexts.
+type contextWatcher struct{}
+
+// afterFunc arranges to call f after ctx is done. The callback is registered
+// with the context itself, so no goroutine waits for the cancellation.
+// Calling stop reports whether f was prevented from running.
+func (mc *mysqlConn) afterFunc(ctx context.Context, f func()) (stop func() bool) {
+	return context.AfterFunc(ctx, f)
+}
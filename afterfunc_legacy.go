@@ -0,0 +1,90 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !go1.21
+// +build !go1.21
+
+package mysql
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// contextWatcher is fed the contexts of the commands of a connection by
+// afterFunc. One goroutine per connection waits for their cancellation; it is
+// started with the first cancellable command and ends with the connection.
+type contextWatcher struct {
+	requests chan<- watchRequest
+}
+
+// watchRequest is a command whose context is watched.
+type watchRequest struct {
+	ctx   context.Context
+	f     func()
+	state *int32 // see afterFunc
+	stop  chan struct{}
+}
+
+// afterFunc arranges to call f after ctx is done. Calling stop reports
+// whether f was prevented from running.
+func (mc *mysqlConn) afterFunc(ctx context.Context, f func()) (stop func() bool) {
+	const (
+		waiting int32 = iota
+		stopped
+	)
+	if mc.watcher.requests == nil {
+		mc.startWatcher()
+	}
+	req := watchRequest{ctx: ctx, f: f, state: new(int32), stop: make(chan struct{})}
+	mc.watcher.requests <- req
+
+	return func() bool {
+		if atomic.CompareAndSwapInt32(req.state, waiting, stopped) {
+			close(req.stop)
+			return true
+		}
+		return false
+	}
+}
+
+// startWatcher starts the goroutine which waits for the contexts passed to
+// afterFunc.
+func (mc *mysqlConn) startWatcher() {
+	const (
+		waiting int32 = iota
+		_
+		called
+	)
+	requests := make(chan watchRequest, 1)
+	mc.watcher.requests = requests
+	closech := mc.closech
+
+	atomic.AddInt64(&driverStats.goroutines, 1)
+	go func() {
+		defer atomic.AddInt64(&driverStats.goroutines, -1)
+		for {
+			var req watchRequest
+			select {
+			case req = <-requests:
+			case <-closech:
+				return
+			}
+
+			select {
+			case <-req.ctx.Done():
+				if atomic.CompareAndSwapInt32(req.state, waiting, called) {
+					req.f()
+				}
+			case <-req.stop:
+			case <-closech:
+				return
+			}
+		}
+	}()
+}
@@ -9,13 +9,10 @@
 package mysql
 
 import (
-	"crypto/rand"
+	"context"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
-	"crypto/x509"
-	"encoding/pem"
-	"fmt"
 	"sync"
 )
 
@@ -33,27 +30,26 @@ var (
 // Note: The provided rsa.PublicKey instance is exclusively owned by the driver
 // after registering it and may not be modified.
 //
-//  data, err := ioutil.ReadFile("mykey.pem")
-//  if err != nil {
-//  	log.Fatal(err)
-//  }
+//	data, err := ioutil.ReadFile("mykey.pem")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
 //
-//  block, _ := pem.Decode(data)
-//  if block == nil || block.Type != "PUBLIC KEY" {
-//  	log.Fatal("failed to decode PEM block containing public key")
-//  }
+//	block, _ := pem.Decode(data)
+//	if block == nil || block.Type != "PUBLIC KEY" {
+//		log.Fatal("failed to decode PEM block containing public key")
+//	}
 //
-//  pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-//  if err != nil {
-//  	log.Fatal(err)
-//  }
-//
-//  if rsaPubKey, ok := pub.(*rsa.PublicKey); ok {
-//  	mysql.RegisterServerPubKey("mykey", rsaPubKey)
-//  } else {
-//  	log.Fatal("not a RSA public key")
-//  }
+//	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
 //
+//	if rsaPubKey, ok := pub.(*rsa.PublicKey); ok {
+//		mysql.RegisterServerPubKey("mykey", rsaPubKey)
+//	} else {
+//		log.Fatal("not a RSA public key")
+//	}
 func RegisterServerPubKey(name string, pubKey *rsa.PublicKey) {
 	serverPubKeyLock.Lock()
 	if serverPubKeyRegistry == nil {
@@ -82,79 +78,64 @@ func getServerPubKey(name string) (pubKey *rsa.PublicKey) {
 	return
 }
 
-// Hash password using pre 4.1 (old password) method
-// https://github.com/atcurtis/mariadb/blob/master/mysys/my_rnd.c
-type myRnd struct {
-	seed1, seed2 uint32
-}
-
-const myRndMaxVal = 0x3FFFFFFF
-
-// Pseudo random number generator
-func newMyRnd(seed1, seed2 uint32) *myRnd {
-	return &myRnd{
-		seed1: seed1 % myRndMaxVal,
-		seed2: seed2 % myRndMaxVal,
-	}
-}
+// AuthPluginFunc is a client side auth plugin, see RegisterAuthPlugin. It is
+// called with the auth data of the handshake or of the auth switch request,
+// and again with the data of every further request of the server (an
+// AuthMoreData packet) until the server accepts or rejects the
+// authentication. The returned response is sent to the server.
+//
+// data is only valid during the call. ctx is the context of the connect.
+type AuthPluginFunc func(ctx context.Context, cfg *Config, data []byte) ([]byte, error)
 
-// Tested to be equivalent to MariaDB's floating point variant
-// http://play.golang.org/p/QHvhd4qved
-// http://play.golang.org/p/RG0q4ElWDx
-func (r *myRnd) NextByte() byte {
-	r.seed1 = (r.seed1*3 + r.seed2) % myRndMaxVal
-	r.seed2 = (r.seed1 + r.seed2 + 33) % myRndMaxVal
+// auth plugins registry
+var (
+	authPluginLock     sync.RWMutex
+	authPluginRegistry map[string]AuthPluginFunc
+)
 
-	return byte(uint64(r.seed1) * 31 / myRndMaxVal)
+// builtinAuthPlugins are the plugins implemented by the driver, which can not
+// be replaced by registered plugins.
+var builtinAuthPlugins = map[string]bool{
+	"authentication_fido_client":     true,
+	"authentication_kerberos_client": true,
+	"caching_sha2_password":          true,
+	"mysql_clear_password":           true,
+	"mysql_native_password":          true,
+	"mysql_old_password":             true,
+	"sha256_password":                true,
 }
 
-// Generate binary hash from byte string using insecure pre 4.1 method
-func pwHash(password []byte) (result [2]uint32) {
-	var add uint32 = 7
-	var tmp uint32
-
-	result[0] = 1345345333
-	result[1] = 0x12345671
-
-	for _, c := range password {
-		// skip spaces and tabs in password
-		if c == ' ' || c == '\t' {
-			continue
-		}
-
-		tmp = uint32(c)
-		result[0] ^= (((result[0] & 63) + add) * tmp) + (result[0] << 8)
-		result[1] += (result[1] << 8) ^ result[0]
-		add += tmp
+// RegisterAuthPlugin registers a client side auth plugin for the accounts
+// whose server side plugin is name, e.g. for hardware tokens or a site
+// specific single sign-on. The driver only uses it for the plugins it does
+// not implement itself.
+func RegisterAuthPlugin(name string, fn AuthPluginFunc) {
+	authPluginLock.Lock()
+	if authPluginRegistry == nil {
+		authPluginRegistry = make(map[string]AuthPluginFunc)
 	}
 
-	// Remove sign bit (1<<31)-1)
-	result[0] &= 0x7FFFFFFF
-	result[1] &= 0x7FFFFFFF
-
-	return
+	authPluginRegistry[name] = fn
+	authPluginLock.Unlock()
 }
 
-// Hash password using insecure pre 4.1 method
-func scrambleOldPassword(scramble []byte, password string) []byte {
-	scramble = scramble[:8]
-
-	hashPw := pwHash([]byte(password))
-	hashSc := pwHash(scramble)
-
-	r := newMyRnd(hashPw[0]^hashSc[0], hashPw[1]^hashSc[1])
-
-	var out [8]byte
-	for i := range out {
-		out[i] = r.NextByte() + 64
+// DeregisterAuthPlugin removes the auth plugin registered with the given name.
+func DeregisterAuthPlugin(name string) {
+	authPluginLock.Lock()
+	if authPluginRegistry != nil {
+		delete(authPluginRegistry, name)
 	}
+	authPluginLock.Unlock()
+}
 
-	mask := r.NextByte()
-	for i := range out {
-		out[i] ^= mask
+func getAuthPlugin(name string) (fn AuthPluginFunc) {
+	if builtinAuthPlugins[name] {
+		return nil
 	}
-
-	return out[:]
+	authPluginLock.RLock()
+	fn = authPluginRegistry[name]
+	authPluginLock.RUnlock()
+	return
 }
 
 // Hash password using 4.1+ method (SHA1)
@@ -215,17 +196,6 @@ func scrambleSHA256Password(scramble []byte, password string) []byte {
 	return message1
 }
 
-func encryptPassword(password string, seed []byte, pub *rsa.PublicKey) ([]byte, error) {
-	plain := make([]byte, len(password)+1)
-	copy(plain, password)
-	for i := range plain {
-		j := i % len(seed)
-		plain[i] ^= seed[j]
-	}
-	sha1 := sha1.New()
-	return rsa.EncryptOAEP(sha1, rand.Reader, pub, plain, nil)
-}
-
 func (mc *mysqlConn) sendEncryptedPassword(seed []byte, pub *rsa.PublicKey) error {
 	enc, err := encryptPassword(mc.cfg.Passwd, seed, pub)
 	if err != nil {
@@ -234,8 +204,48 @@ func (mc *mysqlConn) sendEncryptedPassword(seed []byte, pub *rsa.PublicKey) erro
 	return mc.writeAuthSwitchPacket(enc)
 }
 
+// AuthInfo describes the authentication of a connection, see Conn.AuthInfo.
+type AuthInfo struct {
+	// Plugin is the auth plugin the server accepted, after an auth switch
+	// requested by the server.
+	Plugin string
+
+	// TLS reports whether the connection was encrypted with TLS before the
+	// password, or the response derived from it, was sent.
+	TLS bool
+
+	// Cleartext reports whether the password was sent in clear text, as
+	// done by mysql_clear_password and by sha256_password and
+	// caching_sha2_password over TLS or a unix socket.
+	Cleartext bool
+
+	// Capabilities are the CLIENT_* capability flags negotiated with the
+	// server, i.e. requested by the driver and supported by the server.
+	Capabilities uint32
+
+	// MariaDBCapabilities are the MARIADB_CLIENT_* extended capability flags
+	// negotiated with a MariaDB server, 0 for other servers.
+	MariaDBCapabilities uint32
+}
+
+// secureTransport reports whether a password can be sent in clear text,
+// because the connection is encrypted with TLS or is a unix socket.
+func (mc *mysqlConn) secureTransport() bool {
+	return mc.cfg.tls != nil || mc.cfg.Net == "unix"
+}
+
 func (mc *mysqlConn) auth(authData []byte, plugin string) ([]byte, error) {
+	if mc.cfg.FIPSMode && !fipsAuthPlugins[plugin] {
+		return nil, ErrFIPSAuthPlugin
+	}
+
 	switch plugin {
+	case "authentication_fido_client":
+		return mc.fidoAuth(authData)
+
+	case "authentication_kerberos_client":
+		return mc.kerberosAuth(authData)
+
 	case "caching_sha2_password":
 		authResp := scrambleSHA256Password(authData, mc.cfg.Passwd)
 		return authResp, nil
@@ -250,15 +260,21 @@ func (mc *mysqlConn) auth(authData []byte, plugin string) ([]byte, error) {
 		// Note: there are edge cases where this should work but doesn't;
 		// this is currently "wontfix":
 		// https://github.com/go-sql-driver/mysql/issues/184
-		authResp := append(scrambleOldPassword(authData[:8], mc.cfg.Passwd), 0)
-		return authResp, nil
+		if len(authData) < 8 {
+			return nil, ErrMalformPkt
+		}
+		return authOldPassword(authData[:8], mc.cfg.Passwd)
 
 	case "mysql_clear_password":
 		if !mc.cfg.AllowCleartextPasswords {
 			return nil, ErrCleartextPassword
 		}
+		if !mc.secureTransport() {
+			return nil, ErrInsecureCleartext
+		}
 		// http://dev.mysql.com/doc/refman/5.7/en/cleartext-authentication-plugin.html
 		// http://dev.mysql.com/doc/refman/5.7/en/pam-authentication-plugin.html
+		mc.cleartextAuth = true
 		return append([]byte(mc.cfg.Passwd), 0), nil
 
 	case "mysql_native_password":
@@ -267,6 +283,9 @@ func (mc *mysqlConn) auth(authData []byte, plugin string) ([]byte, error) {
 		}
 		// https://dev.mysql.com/doc/internals/en/secure-password-authentication.html
 		// Native password authentication only need and will need 20-byte challenge.
+		if len(authData) < 20 {
+			return nil, ErrMalformPkt
+		}
 		authResp := scramblePassword(authData[:20], mc.cfg.Passwd)
 		return authResp, nil
 
@@ -274,8 +293,9 @@ func (mc *mysqlConn) auth(authData []byte, plugin string) ([]byte, error) {
 		if len(mc.cfg.Passwd) == 0 {
 			return []byte{0}, nil
 		}
-		if mc.cfg.tls != nil || mc.cfg.Net == "unix" {
+		if mc.secureTransport() {
 			// write cleartext auth packet
+			mc.cleartextAuth = true
 			return append([]byte(mc.cfg.Passwd), 0), nil
 		}
 
@@ -290,7 +310,10 @@ func (mc *mysqlConn) auth(authData []byte, plugin string) ([]byte, error) {
 		return enc, err
 
 	default:
-		errLog.Print("unknown auth plugin:", plugin)
+		if fn := getAuthPlugin(plugin); fn != nil {
+			return fn(mc.commandContext(), mc.cfg, authData)
+		}
+		mc.log("unknown auth plugin:", plugin)
 		return nil, ErrUnknownPlugin
 	}
 }
@@ -334,6 +357,7 @@ func (mc *mysqlConn) handleAuthResult(oldAuthData []byte, plugin string) error {
 			return ErrMalformPkt
 		}
 	}
+	mc.authPlugin = plugin
 
 	switch plugin {
 
@@ -350,8 +374,9 @@ func (mc *mysqlConn) handleAuthResult(oldAuthData []byte, plugin string) error {
 				}
 
 			case cachingSha2PasswordPerformFullAuthentication:
-				if mc.cfg.tls != nil || mc.cfg.Net == "unix" {
+				if mc.secureTransport() {
 					// write cleartext auth packet
+					mc.cleartextAuth = true
 					err = mc.writeAuthSwitchPacket(append([]byte(mc.cfg.Passwd), 0))
 					if err != nil {
 						return err
@@ -372,15 +397,9 @@ func (mc *mysqlConn) handleAuthResult(oldAuthData []byte, plugin string) error {
 							return err
 						}
 
-						block, rest := pem.Decode(data[1:])
-						if block == nil {
-							return fmt.Errorf("No Pem data found, data: %s", rest)
-						}
-						pkix, err := x509.ParsePKIXPublicKey(block.Bytes)
-						if err != nil {
+						if pubKey, err = parsePublicKey(data[1:]); err != nil {
 							return err
 						}
-						pubKey = pkix.(*rsa.PublicKey)
 					}
 
 					// send encrypted password
@@ -403,23 +422,50 @@ func (mc *mysqlConn) handleAuthResult(oldAuthData []byte, plugin string) error {
 		case 0:
 			return nil // auth successful
 		default:
-			block, _ := pem.Decode(authData)
-			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+			pub, err := parsePublicKey(authData)
 			if err != nil {
 				return err
 			}
 
 			// send encrypted password
-			err = mc.sendEncryptedPassword(oldAuthData, pub.(*rsa.PublicKey))
+			err = mc.sendEncryptedPassword(oldAuthData, pub)
 			if err != nil {
 				return err
 			}
 			return mc.readResultOK()
 		}
 
+	case "authentication_kerberos_client":
+		return mc.authRoundTrips(authData, mc.kerberosContinue)
+
 	default:
+		if fn := getAuthPlugin(plugin); fn != nil {
+			return mc.authRoundTrips(authData, fn)
+		}
 		return nil // auth successful
 	}
 
 	return err
 }
+
+// authRoundTrips answers the requests of the server with fn until the
+// server sends the result of the authentication.
+func (mc *mysqlConn) authRoundTrips(authData []byte, fn AuthPluginFunc) error {
+	for authData != nil {
+		authResp, err := fn(mc.commandContext(), mc.cfg, authData)
+		if err != nil {
+			return err
+		}
+		if err = mc.writeAuthSwitchPacket(authResp); err != nil {
+			return err
+		}
+		var newPlugin string
+		if authData, newPlugin, err = mc.readAuthResult(); err != nil {
+			return err
+		}
+		if newPlugin != "" {
+			return ErrMalformPkt
+		}
+	}
+	return nil // auth successful
+}
@@ -0,0 +1,38 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build mysql_noauthplugins || mysql_tiny
+// +build mysql_noauthplugins mysql_tiny
+
+package mysql
+
+import (
+	"crypto/rsa"
+	"errors"
+)
+
+// This build of the driver supports neither the mysql_old_password plugin nor
+// sending passwords encrypted with the RSA key of the server. The
+// sha256_password and caching_sha2_password plugins still work over TLS and
+// unix sockets, which send the password in cleartext.
+var (
+	errOldPasswordDisabled = errors.New("mysql_old_password is not supported by this build of the driver (mysql_noauthplugins)")
+	errRSADisabled         = errors.New("RSA password encryption is not supported by this build of the driver (mysql_noauthplugins); use TLS or a unix socket")
+)
+
+func authOldPassword(scramble []byte, password string) ([]byte, error) {
+	return nil, errOldPasswordDisabled
+}
+
+func encryptPassword(password string, seed []byte, pub *rsa.PublicKey) ([]byte, error) {
+	return nil, errRSADisabled
+}
+
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	return nil, errRSADisabled
+}
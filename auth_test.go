@@ -10,6 +10,7 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
@@ -39,25 +40,6 @@ func init() {
 	testPubKeyRSA = pub.(*rsa.PublicKey)
 }
 
-func TestScrambleOldPass(t *testing.T) {
-	scramble := []byte{9, 8, 7, 6, 5, 4, 3, 2}
-	vectors := []struct {
-		pass string
-		out  string
-	}{
-		{" pass", "47575c5a435b4251"},
-		{"pass ", "47575c5a435b4251"},
-		{"123\t456", "575c47505b5b5559"},
-		{"C0mpl!ca ted#PASS123", "5d5d554849584a45"},
-	}
-	for _, tuple := range vectors {
-		ours := scrambleOldPassword(scramble, tuple.pass)
-		if tuple.out != fmt.Sprintf("%x", ours) {
-			t.Errorf("Failed old password %q", tuple.pass)
-		}
-	}
-}
-
 func TestScrambleSHA256Pass(t *testing.T) {
 	scramble := []byte{10, 47, 74, 111, 75, 73, 34, 48, 88, 76, 114, 74, 37, 13, 3, 80, 82, 2, 23, 21}
 	vectors := []struct {
@@ -342,11 +324,29 @@ func TestAuthFastCleartextPasswordNotAllowed(t *testing.T) {
 	}
 }
 
+func TestAuthFastCleartextPasswordInsecure(t *testing.T) {
+	_, mc := newRWMockConn(1)
+	mc.cfg.User = "root"
+	mc.cfg.Passwd = "secret"
+	mc.cfg.AllowCleartextPasswords = true
+
+	authData := []byte{70, 114, 92, 94, 1, 38, 11, 116, 63, 114, 23, 101, 126,
+		103, 26, 95, 81, 17, 24, 21}
+	plugin := "mysql_clear_password"
+
+	// the password is not sent over plain TCP
+	_, err := mc.auth(authData, plugin)
+	if err != ErrInsecureCleartext {
+		t.Errorf("expected ErrInsecureCleartext, got %v", err)
+	}
+}
+
 func TestAuthFastCleartextPassword(t *testing.T) {
 	conn, mc := newRWMockConn(1)
 	mc.cfg.User = "root"
 	mc.cfg.Passwd = "secret"
 	mc.cfg.AllowCleartextPasswords = true
+	mc.cfg.Net = "unix"
 
 	authData := []byte{70, 114, 92, 94, 1, 38, 11, 116, 63, 114, 23, 101, 126,
 		103, 26, 95, 81, 17, 24, 21}
@@ -390,6 +390,7 @@ func TestAuthFastCleartextPasswordEmpty(t *testing.T) {
 	mc.cfg.User = "root"
 	mc.cfg.Passwd = ""
 	mc.cfg.AllowCleartextPasswords = true
+	mc.cfg.Net = "unix"
 
 	authData := []byte{70, 114, 92, 94, 1, 38, 11, 116, 63, 114, 23, 101, 126,
 		103, 26, 95, 81, 17, 24, 21}
@@ -445,6 +446,23 @@ func TestAuthFastNativePasswordNotAllowed(t *testing.T) {
 	}
 }
 
+func TestAuthFastFIPSModeNativePassword(t *testing.T) {
+	_, mc := newRWMockConn(1)
+	mc.cfg.User = "root"
+	mc.cfg.Passwd = "secret"
+	mc.cfg.FIPSMode = true
+
+	authData := []byte{70, 114, 92, 94, 1, 38, 11, 116, 63, 114, 23, 101, 126,
+		103, 26, 95, 81, 17, 24, 21}
+	plugin := "mysql_native_password"
+
+	// Send Client Authentication Packet
+	_, err := mc.auth(authData, plugin)
+	if err != ErrFIPSAuthPlugin {
+		t.Errorf("expected ErrFIPSAuthPlugin, got %v", err)
+	}
+}
+
 func TestAuthFastNativePassword(t *testing.T) {
 	conn, mc := newRWMockConn(1)
 	mc.cfg.User = "root"
@@ -921,6 +939,7 @@ func TestAuthSwitchCleartextPasswordNotAllowed(t *testing.T) {
 func TestAuthSwitchCleartextPassword(t *testing.T) {
 	conn, mc := newRWMockConn(2)
 	mc.cfg.AllowCleartextPasswords = true
+	mc.cfg.Net = "unix"
 	mc.cfg.Passwd = "secret"
 
 	// auth switch request
@@ -948,6 +967,7 @@ func TestAuthSwitchCleartextPassword(t *testing.T) {
 func TestAuthSwitchCleartextPasswordEmpty(t *testing.T) {
 	conn, mc := newRWMockConn(2)
 	mc.cfg.AllowCleartextPasswords = true
+	mc.cfg.Net = "unix"
 	mc.cfg.Passwd = ""
 
 	// auth switch request
@@ -1049,6 +1069,29 @@ func TestAuthSwitchNativePasswordEmpty(t *testing.T) {
 	}
 }
 
+func TestAuthSwitchFIPSModeNativePassword(t *testing.T) {
+	conn, mc := newRWMockConn(2)
+	mc.cfg.Passwd = "secret"
+	mc.cfg.FIPSMode = true
+
+	// auth switch request
+	conn.data = []byte{44, 0, 0, 2, 254, 109, 121, 115, 113, 108, 95, 110, 97,
+		116, 105, 118, 101, 95, 112, 97, 115, 115, 119, 111, 114, 100, 0, 96,
+		71, 63, 8, 1, 58, 75, 12, 69, 95, 66, 60, 117, 31, 48, 31, 89, 39, 55,
+		31, 0}
+	conn.maxReads = 1
+	authData := []byte{96, 71, 63, 8, 1, 58, 75, 12, 69, 95, 66, 60, 117, 31,
+		48, 31, 89, 39, 55, 31}
+	plugin := "caching_sha2_password"
+	err := mc.handleAuthResult(authData, plugin)
+	if err != ErrFIPSAuthPlugin {
+		t.Errorf("expected ErrFIPSAuthPlugin, got %v", err)
+	}
+	if len(conn.written) != 0 {
+		t.Errorf("nothing should be sent to the server, got %v", conn.written)
+	}
+}
+
 func TestAuthSwitchOldPasswordNotAllowed(t *testing.T) {
 	conn, mc := newRWMockConn(2)
 
@@ -1328,3 +1371,75 @@ func TestAuthSwitchSHA256PasswordSecure(t *testing.T) {
 		t.Errorf("got unexpected data: %v", conn.written)
 	}
 }
+
+func TestAuthSwitchMalformed(t *testing.T) {
+	authSwitch := func(plugin string, data []byte) []byte {
+		payload := append(append([]byte{254}, plugin...), 0)
+		payload = append(payload, data...)
+		return append([]byte{byte(len(payload)), 0, 0, 2}, payload...)
+	}
+	authData := []byte{123, 87, 15, 84, 20, 58, 37, 121, 91, 117, 51, 24, 19,
+		47, 43, 9, 41, 112, 67, 110}
+
+	// scramble too short for mysql_native_password
+	conn, mc := newRWMockConn(2)
+	mc.cfg.Passwd = "secret"
+	conn.data = authSwitch("mysql_native_password", []byte{1, 2, 3, 4, 0})
+	conn.maxReads = 1
+	if err := mc.handleAuthResult(authData, "caching_sha2_password"); err != ErrMalformPkt {
+		t.Errorf("expected ErrMalformPkt, got %v", err)
+	}
+
+	// sha256_password public key which is not PEM encoded
+	conn, mc = newRWMockConn(2)
+	mc.cfg.Passwd = "secret"
+	conn.data = authSwitch("sha256_password", authData)
+	conn.queuedReplies = [][]byte{
+		// auth more data without a public key
+		{5, 0, 0, 4, 1, 'j', 'u', 'n', 'k'},
+	}
+	conn.maxReads = 2
+	if err := mc.handleAuthResult(authData, "mysql_native_password"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestAuthSwitchRegisteredPlugin(t *testing.T) {
+	RegisterAuthPlugin("test_token", func(ctx context.Context, cfg *Config, data []byte) ([]byte, error) {
+		return append([]byte(cfg.User+":"), data...), nil
+	})
+	defer DeregisterAuthPlugin("test_token")
+
+	conn, mc := newRWMockConn(2)
+	mc.cfg.User = "root"
+	conn.data = append([]byte{15, 0, 0, 2, 254}, "test_token\x00abc"...)
+	conn.queuedReplies = [][]byte{
+		// auth more data
+		{2, 0, 0, 4, 1, 'x'},
+		// OK
+		{7, 0, 0, 6, 0, 0, 0, 2, 0, 0, 0},
+	}
+	conn.maxReads = 3
+
+	authData := []byte{123, 87, 15, 84, 20, 58, 37, 121, 91, 117, 51, 24, 19,
+		47, 43, 9, 41, 112, 67, 110}
+	if err := mc.handleAuthResult(authData, "mysql_native_password"); err != nil {
+		t.Fatal(err)
+	}
+	expectedReply := append(append([]byte{8, 0, 0, 3}, "root:abc"...), append([]byte{6, 0, 0, 5}, "root:x"...)...)
+	if !bytes.Equal(conn.written, expectedReply) {
+		t.Errorf("got unexpected data: %q", conn.written)
+	}
+	if mc.authPlugin != "test_token" {
+		t.Errorf("unexpected auth plugin %q", mc.authPlugin)
+	}
+
+	// built-in plugins can not be replaced
+	RegisterAuthPlugin("mysql_native_password", func(ctx context.Context, cfg *Config, data []byte) ([]byte, error) {
+		return nil, nil
+	})
+	defer DeregisterAuthPlugin("mysql_native_password")
+	if fn := getAuthPlugin("mysql_native_password"); fn != nil {
+		t.Error("expected the built-in mysql_native_password to be used")
+	}
+}
@@ -0,0 +1,192 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"strconv"
+)
+
+// defaultBatchSize is the number of rows of a Batch if QueryBatches is called
+// with a size <= 0.
+const defaultBatchSize = 1024
+
+// BatchKind is the type of the values of a BatchColumn.
+type BatchKind int
+
+const (
+	BatchBytes   BatchKind = iota // values in Data, delimited by Offsets
+	BatchInt64                    // values in Int64s
+	BatchUint64                   // values in Uint64s
+	BatchFloat64                  // values in Float64s
+)
+
+// BatchColumn holds the values of a column of a Batch. Only the slices of
+// its Kind are set. The layout follows Apache Arrow, so the slices can be
+// wrapped in Arrow arrays without copying them.
+type BatchColumn struct {
+	Name             string
+	DatabaseTypeName string
+	Kind             BatchKind
+
+	// Valid has a bit for each row, which is set if the value is not NULL.
+	// The bit of row i is bit i%8 of Valid[i/8].
+	Valid []byte
+
+	// Values of integer and floating point columns. NULL values are 0.
+	Int64s   []int64
+	Uint64s  []uint64
+	Float64s []float64
+
+	// Values of the other columns in their text form. The value of row i
+	// is Data[Offsets[i]:Offsets[i+1]], which is empty for NULL values.
+	Offsets []int32
+	Data    []byte
+}
+
+// IsNull reports whether the value of row i is NULL.
+func (c *BatchColumn) IsNull(i int) bool {
+	return c.Valid[i/8]&(1<<uint(i%8)) == 0
+}
+
+// Bytes returns the value of row i of a column of kind BatchBytes.
+func (c *BatchColumn) Bytes(i int) []byte {
+	return c.Data[c.Offsets[i]:c.Offsets[i+1]]
+}
+
+// Batch is a column-oriented batch of rows passed to the callback of
+// Conn.QueryBatches. The batch and its slices are reused for the next batch
+// and are only valid until the callback returns. Copy them to keep them
+// longer.
+type Batch struct {
+	Len     int // number of rows
+	Columns []BatchColumn
+}
+
+// newBatch returns a batch for the columns of a result set.
+func newBatch(columns []mysqlField, size int) *Batch {
+	b := &Batch{Columns: make([]BatchColumn, len(columns))}
+	for i := range columns {
+		mf := &columns[i]
+		col := &b.Columns[i]
+		col.Name = mf.name
+		col.DatabaseTypeName = mf.typeDatabaseName()
+		col.Valid = make([]byte, 0, (size+7)/8)
+
+		switch mf.fieldType {
+		case fieldTypeTiny, fieldTypeShort, fieldTypeInt24, fieldTypeLong,
+			fieldTypeLongLong, fieldTypeYear:
+			if mf.flags&flagUnsigned != 0 {
+				col.Kind = BatchUint64
+				col.Uint64s = make([]uint64, 0, size)
+			} else {
+				col.Kind = BatchInt64
+				col.Int64s = make([]int64, 0, size)
+			}
+		case fieldTypeFloat, fieldTypeDouble:
+			col.Kind = BatchFloat64
+			col.Float64s = make([]float64, 0, size)
+		default:
+			if mf.asFloat {
+				col.Kind = BatchFloat64
+				col.Float64s = make([]float64, 0, size)
+				break
+			}
+			col.Kind = BatchBytes
+			col.Offsets = make([]int32, 1, size+1)
+		}
+	}
+	return b
+}
+
+// reset empties the batch, keeping the memory of its slices.
+func (b *Batch) reset() {
+	b.Len = 0
+	for i := range b.Columns {
+		col := &b.Columns[i]
+		col.Valid = col.Valid[:0]
+		col.Int64s = col.Int64s[:0]
+		col.Uint64s = col.Uint64s[:0]
+		col.Float64s = col.Float64s[:0]
+		if col.Offsets != nil {
+			col.Offsets = col.Offsets[:1]
+		}
+		col.Data = col.Data[:0]
+	}
+}
+
+// appendRow decodes the values of row into the columns of the batch.
+func (b *Batch) appendRow(row RowView) error {
+	i := b.Len
+	for c := range b.Columns {
+		col := &b.Columns[c]
+		if i%8 == 0 {
+			col.Valid = append(col.Valid, 0)
+		}
+		v := row.values[c]
+		if v != nil {
+			col.Valid[i/8] |= 1 << uint(i%8)
+		}
+
+		var err error
+		switch col.Kind {
+		case BatchInt64:
+			var n int64
+			if v != nil {
+				n, err = strconv.ParseInt(string(v), 10, 64)
+			}
+			col.Int64s = append(col.Int64s, n)
+		case BatchUint64:
+			var n uint64
+			if v != nil {
+				n, err = strconv.ParseUint(string(v), 10, 64)
+			}
+			col.Uint64s = append(col.Uint64s, n)
+		case BatchFloat64:
+			var f float64
+			if v != nil {
+				f, err = strconv.ParseFloat(string(v), 64)
+			}
+			col.Float64s = append(col.Float64s, f)
+		default:
+			col.Data = append(col.Data, v...)
+			col.Offsets = append(col.Offsets, int32(len(col.Data)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	b.Len++
+	return nil
+}
+
+func (mc *mysqlConn) QueryBatches(ctx context.Context, query string, args []interface{}, size int, fn func(batch *Batch) error) error {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	var batch *Batch
+	err := mc.QueryEach(ctx, query, args, func(row RowView) error {
+		if batch == nil {
+			batch = newBatch(row.columns, size)
+		}
+		if err := batch.appendRow(row); err != nil {
+			return err
+		}
+		if batch.Len < size {
+			return nil
+		}
+		err := fn(batch)
+		batch.reset()
+		return err
+	})
+	if err != nil || batch == nil || batch.Len == 0 {
+		return err
+	}
+	return fn(batch)
+}
@@ -11,12 +11,54 @@ package mysql
 import (
 	"io"
 	"net"
+	"sync"
 	"time"
 )
 
 const defaultBufSize = 4096
 const maxCachedBufSize = 256 * 1024
 
+// bufPools shares buffers larger than defaultBufSize between connections.
+// The buffers are bucketed by power-of-two sizes from 2*defaultBufSize up to
+// maxCachedBufSize, so mostly idle connections don't pin large buffers.
+var bufPools [6]sync.Pool
+
+// bufPoolClass returns the index of the smallest pool whose buffers can hold
+// size bytes.
+func bufPoolClass(size int) int {
+	class := 0
+	for defaultBufSize<<uint(class+1) < size {
+		class++
+	}
+	return class
+}
+
+// getPooledBuf returns a buffer of at least size bytes.
+// size must not be larger than maxCachedBufSize.
+func getPooledBuf(size int) []byte {
+	if size <= defaultBufSize {
+		return make([]byte, defaultBufSize)
+	}
+	class := bufPoolClass(size)
+	if buf, ok := bufPools[class].Get().(*[]byte); ok {
+		return *buf
+	}
+	return make([]byte, defaultBufSize<<uint(class+1))
+}
+
+// putPooledBuf returns a buffer obtained by getPooledBuf to its pool.
+func putPooledBuf(buf []byte) {
+	if len(buf) <= defaultBufSize || len(buf) > maxCachedBufSize {
+		return
+	}
+	class := bufPoolClass(len(buf))
+	if defaultBufSize<<uint(class+1) != len(buf) {
+		// not allocated by getPooledBuf
+		return
+	}
+	bufPools[class].Put(&buf)
+}
+
 // A buffer which is used for both reading and writing.
 // This is possible since communication on each connection is synchronous.
 // In other words, we can't write and read simultaneously on the same connection.
@@ -61,13 +103,15 @@ func (b *buffer) fill(need int) error {
 
 	// grow buffer if necessary to fit the whole packet.
 	if need > len(dest) {
-		// Round up to the next multiple of the default size
-		dest = make([]byte, ((need/defaultBufSize)+1)*defaultBufSize)
-
-		// if the allocated buffer is not too large, move it to backing storage
-		// to prevent extra allocations on applications that perform large reads
-		if len(dest) <= maxCachedBufSize {
+		if need <= maxCachedBufSize {
+			// if the buffer is not too large, take it from the shared pool
+			// and move it to backing storage to prevent extra allocations on
+			// applications that perform large reads
+			dest = getPooledBuf(need)
 			b.dbuf[b.flipcnt&1] = dest
+		} else {
+			// Round up to the next multiple of the default size
+			dest = make([]byte, ((need/defaultBufSize)+1)*defaultBufSize)
 		}
 	}
 
@@ -127,6 +171,41 @@ func (b *buffer) readNext(need int) ([]byte, error) {
 	return b.buf[offset:b.idx], nil
 }
 
+// readFull reads exactly len(dst) bytes into dst.
+// Buffered data is copied first, the remainder is read from the connection
+// directly into dst without passing through the buffer.
+func (b *buffer) readFull(dst []byte) error {
+	n := copy(dst, b.buf[b.idx:b.idx+b.length])
+	b.idx += n
+	b.length -= n
+
+	for n < len(dst) {
+		if b.timeout > 0 {
+			if err := b.nc.SetReadDeadline(time.Now().Add(b.timeout)); err != nil {
+				return err
+			}
+		}
+
+		nn, err := b.nc.Read(dst[n:])
+		n += nn
+
+		switch err {
+		case nil:
+			continue
+
+		case io.EOF:
+			if n >= len(dst) {
+				return nil
+			}
+			return io.ErrUnexpectedEOF
+
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
 // takeBuffer returns a buffer with the requested size.
 // If possible, a slice from the existing buffer is returned.
 // Otherwise a bigger buffer is made.
@@ -141,6 +220,12 @@ func (b *buffer) takeBuffer(length int) ([]byte, error) {
 		return b.buf[:length], nil
 	}
 
+	if length <= maxCachedBufSize {
+		b.buf = getPooledBuf(length)
+		b.dbuf[b.flipcnt&1] = b.buf
+		return b.buf[:length], nil
+	}
+
 	if length < maxPacketSize {
 		b.buf = make([]byte, length)
 		return b.buf, nil
@@ -180,3 +265,29 @@ func (b *buffer) store(buf []byte) error {
 	}
 	return nil
 }
+
+// release returns the buffers taken from the shared pool, keeping only a
+// buffer of the default size. It must only be called while no data returned
+// from the buffer is in use, e.g. when the connection is put back into the
+// connection pool.
+func (b *buffer) release() {
+	if b.length > 0 {
+		return
+	}
+	released := false
+	for i, buf := range b.dbuf {
+		if len(buf) > defaultBufSize {
+			putPooledBuf(buf)
+			b.dbuf[i] = nil
+			released = true
+		}
+	}
+	if !released {
+		return
+	}
+	if b.dbuf[b.flipcnt&1] == nil {
+		b.dbuf[b.flipcnt&1] = make([]byte, defaultBufSize)
+	}
+	b.buf = b.dbuf[b.flipcnt&1]
+	b.idx = 0
+}
@@ -0,0 +1,286 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Captures record the byte stream of a session, so that protocol bug reports
+// can be turned into deterministic regression tests.
+//
+// Run the tests against a real server with MYSQL_TEST_CAPTURE set to a
+// directory to record a capture file for every connection. Load a capture
+// with readCapture and serve it with newReplayConn.
+//
+// A capture file is a sequence of records:
+//
+//	direction [1 byte] captureClient or captureServer
+//	length    [uint32, big endian]
+//	data      [length bytes]
+const (
+	captureClient byte = 'C' // written by the client
+	captureServer byte = 'S' // read from the server
+)
+
+type captureRecord struct {
+	direction byte
+	data      []byte
+}
+
+// recordConn records the data read from and written to the wrapped
+// connection.
+type recordConn struct {
+	net.Conn
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func newRecordConn(nc net.Conn, w io.WriteCloser) net.Conn {
+	return &recordConn{Conn: nc, w: w}
+}
+
+func (rc *recordConn) record(direction byte, data []byte) {
+	var head [5]byte
+	head[0] = direction
+	binary.BigEndian.PutUint32(head[1:], uint32(len(data)))
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.w.Write(head[:])
+	rc.w.Write(data)
+}
+
+func (rc *recordConn) Read(b []byte) (int, error) {
+	n, err := rc.Conn.Read(b)
+	if n > 0 {
+		rc.record(captureServer, b[:n])
+	}
+	return n, err
+}
+
+func (rc *recordConn) Write(b []byte) (int, error) {
+	n, err := rc.Conn.Write(b)
+	if n > 0 {
+		rc.record(captureClient, b[:n])
+	}
+	return n, err
+}
+
+func (rc *recordConn) Close() error {
+	err := rc.Conn.Close()
+	rc.mu.Lock()
+	rc.w.Close()
+	rc.mu.Unlock()
+	return err
+}
+
+// registerCaptureDial records every connection of the network to a new file
+// in dir.
+func registerCaptureDial(network, dir string) {
+	var n int32
+	RegisterDialContext(network, func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		nc, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%d-%04d.capture", os.Getpid(), atomic.AddInt32(&n, 1))
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		return newRecordConn(nc, f), nil
+	})
+}
+
+func readCapture(r io.Reader) ([]captureRecord, error) {
+	var records []captureRecord
+	var head [5]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, err
+		}
+		if head[0] != captureClient && head[0] != captureServer {
+			return nil, fmt.Errorf("invalid capture direction %q", head[0])
+		}
+		data := make([]byte, binary.BigEndian.Uint32(head[1:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		records = append(records, captureRecord{direction: head[0], data: data})
+	}
+}
+
+// replayConn serves the server side of a capture.
+// The data written by the client is discarded, as it contains values which
+// change between sessions, like the scrambled password.
+type replayConn struct {
+	mu      sync.Mutex
+	records []captureRecord
+	closed  bool
+}
+
+func newReplayConn(records []captureRecord) net.Conn {
+	return &replayConn{records: records}
+}
+
+// Read returns the data of the next server record. A single read never spans
+// two records, so that the client does not see data ahead of time.
+func (rc *replayConn) Read(b []byte) (int, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for len(rc.records) > 0 && rc.records[0].direction != captureServer {
+		rc.records = rc.records[1:]
+	}
+	if len(rc.records) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, rc.records[0].data)
+	rc.records[0].data = rc.records[0].data[n:]
+	if len(rc.records[0].data) == 0 {
+		rc.records = rc.records[1:]
+	}
+	return n, nil
+}
+
+func (rc *replayConn) Write(b []byte) (int, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return len(b), nil
+}
+
+func (rc *replayConn) Close() error {
+	rc.mu.Lock()
+	rc.closed = true
+	rc.mu.Unlock()
+	return nil
+}
+
+func (rc *replayConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (rc *replayConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (rc *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (rc *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (rc *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+var (
+	// serverHandshake is the handshake of a server without password
+	// authentication, which is answered with serverAuthOK.
+	serverHandshake = []byte{72, 0, 0, 0, 10, 53, 46, 53, 46, 56, 0, 165, 0, 0, 0,
+		60, 70, 63, 58, 68, 104, 34, 97, 0, 223, 247, 33, 2, 0, 15, 128, 21, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 98, 120, 114, 47, 85, 75, 109, 99, 51, 77,
+		50, 64, 0, 109, 121, 115, 113, 108, 95, 110, 97, 116, 105, 118, 101, 95,
+		112, 97, 115, 115, 119, 111, 114, 100}
+	serverAuthOK = []byte{7, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0}
+)
+
+// serveReplies plays a server which answers each packet read from conn with
+// the next reply. The first reply is sent right away.
+func serveReplies(conn net.Conn, replies [][]byte) {
+	defer conn.Close()
+	var head [4]byte
+	for i, reply := range replies {
+		if i > 0 {
+			if _, err := io.ReadFull(conn, head[:]); err != nil {
+				return
+			}
+			pktLen := int(uint32(head[0]) | uint32(head[1])<<8 | uint32(head[2])<<16)
+			if _, err := io.CopyN(ioutil.Discard, conn, int64(pktLen)); err != nil {
+				return
+			}
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+	io.Copy(ioutil.Discard, conn)
+}
+
+func TestCaptureReplay(t *testing.T) {
+	replies := [][]byte{serverHandshake, serverAuthOK, textResultSet("foo", "bar")}
+
+	query := func(dsn string) []string {
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		rows, err := db.Query("SELECT v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var values []string
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	// record a session
+	var capture bytes.Buffer
+	RegisterDialContext("capturerecord", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveReplies(server, replies)
+		return newRecordConn(client, nopWriteCloser{&capture}), nil
+	})
+	recorded := query("root@capturerecord(localhost:3306)/?maxAllowedPacket=4194304")
+
+	records, err := readCapture(bytes.NewReader(capture.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replay it
+	RegisterDialContext("capturereplay", func(ctx context.Context, addr string) (net.Conn, error) {
+		return newReplayConn(records), nil
+	})
+	replayed := query("root@capturereplay(localhost:3306)/?maxAllowedPacket=4194304")
+
+	if fmt.Sprint(recorded) != "[foo bar]" || fmt.Sprint(replayed) != fmt.Sprint(recorded) {
+		t.Errorf("recorded %v, replayed %v", recorded, replayed)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
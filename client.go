@@ -0,0 +1,282 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+)
+
+// clientConn is implemented by the connections returned by the connector.
+type clientConn interface {
+	Conn
+	driver.Conn
+	driver.Pinger
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.ConnPrepareContext
+}
+
+// Client is a connection to a MySQL server which is used without
+// database/sql, for tools such as migrators, replication clients and proxies
+// which need control over the connection and the streaming of result sets.
+// There is no pool and no retry of failed commands. A Client must not be
+// used concurrently.
+type Client struct {
+	conn clientConn
+}
+
+// Connect opens a connection to the server of cfg.
+func Connect(ctx context.Context, cfg *Config) (*Client, error) {
+	c, err := NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn.(clientConn)}, nil
+}
+
+// Conn returns the connection with the methods of the driver which are not
+// part of database/sql, such as QueryEach.
+func (c *Client) Conn() Conn {
+	return c.conn
+}
+
+// Close sends COM_QUIT and closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Ping checks that the connection is alive.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx)
+}
+
+// Query executes a query and returns its result sets. The connection can't
+// be used for other commands until the rows are closed. The query is
+// prepared if it has arguments which are not interpolated, see
+// interpolateParams.
+func (c *Client) Query(ctx context.Context, query string, args ...interface{}) (*ClientRows, error) {
+	nvs, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.conn.QueryContext(ctx, query, nvs)
+	if err != driver.ErrSkip {
+		if err != nil {
+			return nil, err
+		}
+		return newClientRows(rows), nil
+	}
+
+	stmt, err := c.Prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	r, err := stmt.Query(ctx, args...)
+	if err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	r.stmt = stmt.stmt
+	return r, nil
+}
+
+// Exec executes a statement which returns no rows. The statement is prepared
+// if it has arguments which are not interpolated, see interpolateParams.
+func (c *Client) Exec(ctx context.Context, query string, args ...interface{}) (Result, error) {
+	nvs, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.conn.ExecContext(ctx, query, nvs)
+	if err != driver.ErrSkip {
+		if err != nil {
+			return nil, err
+		}
+		return res.(Result), nil
+	}
+
+	stmt, err := c.Prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return stmt.Exec(ctx, args...)
+}
+
+// Prepare creates a prepared statement on the server.
+func (c *Client) Prepare(ctx context.Context, query string) (*ClientStmt, error) {
+	stmt, err := c.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStmt{stmt: stmt}, nil
+}
+
+// ClientStmt is a prepared statement of a Client.
+type ClientStmt struct {
+	stmt driver.Stmt
+}
+
+// NumInput returns the number of placeholders of the statement.
+func (s *ClientStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+// Query executes the statement and returns its result sets.
+func (s *ClientStmt) Query(ctx context.Context, args ...interface{}) (*ClientRows, error) {
+	nvs, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.stmt.(driver.StmtQueryContext).QueryContext(ctx, nvs)
+	if err != nil {
+		return nil, err
+	}
+	return newClientRows(rows), nil
+}
+
+// Exec executes the statement, which returns no rows.
+func (s *ClientStmt) Exec(ctx context.Context, args ...interface{}) (Result, error) {
+	nvs, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.stmt.(driver.StmtExecContext).ExecContext(ctx, nvs)
+	if err != nil {
+		return nil, err
+	}
+	return res.(Result), nil
+}
+
+// Close deallocates the statement on the server.
+func (s *ClientStmt) Close() error {
+	return s.stmt.Close()
+}
+
+// ClientRows iterates over the result sets of a query of a Client.
+//
+//	rows, err := client.Query(ctx, "SELECT id, name FROM users")
+//	if err != nil {
+//	    return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//	    values := rows.Values()
+//	    ...
+//	}
+//	return rows.Err()
+type ClientRows struct {
+	rows   driver.Rows
+	stmt   driver.Stmt // prepared for the rows, closed with them
+	values []driver.Value
+	err    error
+	closed bool
+}
+
+func newClientRows(rows driver.Rows) *ClientRows {
+	return &ClientRows{
+		rows:   rows,
+		values: make([]driver.Value, len(rows.Columns())),
+	}
+}
+
+// Columns returns the names of the columns of the current result set.
+func (r *ClientRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+// Next reads the next row of the current result set. It returns false when
+// the result set is exhausted or an error occurred, see Err. The rows are
+// closed after the last result set.
+func (r *ClientRows) Next() bool {
+	if r.closed {
+		return false
+	}
+	err := r.rows.Next(r.values)
+	if err == nil {
+		return true
+	}
+	if err != io.EOF {
+		r.err = err
+		r.Close()
+	} else if !r.rows.(driver.RowsNextResultSet).HasNextResultSet() {
+		r.Close()
+	}
+	return false
+}
+
+// Values returns the values of the row read by Next. The slice and the
+// []byte values are only valid until the next call to Next; copy them to
+// keep them longer.
+func (r *ClientRows) Values() []driver.Value {
+	return r.values
+}
+
+// NextResultSet advances to the next result set. It returns false if there
+// is none or an error occurred, see Err.
+func (r *ClientRows) NextResultSet() bool {
+	if r.closed {
+		return false
+	}
+	err := r.rows.(driver.RowsNextResultSet).NextResultSet()
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		r.Close()
+		return false
+	}
+	r.values = make([]driver.Value, len(r.rows.Columns()))
+	return true
+}
+
+// Status returns the status of the final OK packet, see Rows.Status.
+func (r *ClientRows) Status() ResultStatus {
+	return r.rows.(Rows).Status()
+}
+
+// Err returns the error which ended the iteration, if any.
+func (r *ClientRows) Err() error {
+	return r.err
+}
+
+// Close discards the unread rows and releases the connection for other
+// commands. It is safe to call it more than once.
+func (r *ClientRows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.rows.Close()
+	if r.stmt != nil {
+		if serr := r.stmt.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// namedValues converts the arguments of a Client like database/sql does.
+func namedValues(args []interface{}) ([]driver.NamedValue, error) {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		v, err := converter{}.ConvertValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nvs, nil
+}
@@ -0,0 +1,59 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.23
+// +build go1.23
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"iter"
+)
+
+// QueryIter executes a query and returns an iterator over the rows of its
+// first result set. The rows are closed when the loop ends, also on break,
+// and an error ends the loop after it is yielded with nil values.
+//
+//	for values, err := range client.QueryIter(ctx, "SELECT id, name FROM users") {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    ...
+//	}
+func (c *Client) QueryIter(ctx context.Context, query string, args ...interface{}) iter.Seq2[[]driver.Value, error] {
+	return func(yield func([]driver.Value, error) bool) {
+		rows, err := c.Query(ctx, query, args...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		rows.All()(yield)
+	}
+}
+
+// All returns an iterator over the remaining rows of the current result set,
+// like Client.QueryIter. The values are only valid until the next iteration.
+func (r *ClientRows) All() iter.Seq2[[]driver.Value, error] {
+	return func(yield func([]driver.Value, error) bool) {
+		defer r.Close()
+		for r.Next() {
+			if !yield(r.Values(), nil) {
+				return
+			}
+		}
+		if r.Err() != nil {
+			yield(nil, r.Err())
+			return
+		}
+		if err := r.Close(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
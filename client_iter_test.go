@@ -0,0 +1,80 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.23
+// +build go1.23
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestClientQueryIter(t *testing.T) {
+	// ER_NO_SUCH_TABLE
+	errPacket := append([]byte{17, 0, 0, 1, 0xff, 0x7a, 0x04, '#'}, "42S02no table"...)
+	RegisterDialContext("clientitertest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveReplies(server, [][]byte{
+			serverHandshake, serverAuthOK,
+			textResultSet("a", "b", "c"),
+			textResultSet("d", "e"),
+			errPacket,
+		})
+		return client, nil
+	})
+
+	cfg := NewConfig()
+	cfg.Net = "clientitertest"
+	cfg.Addr = "localhost"
+	client, err := Connect(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// break closes the rows and discards the remaining ones
+	var values []string
+	for row, err := range client.QueryIter(context.Background(), "SELECT v FROM t") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, string(row[0].([]byte)))
+		if len(values) == 2 {
+			break
+		}
+	}
+	if fmt.Sprint(values) != "[a b]" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	values = nil
+	for row, err := range client.QueryIter(context.Background(), "SELECT v FROM t") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, string(row[0].([]byte)))
+	}
+	if fmt.Sprint(values) != "[d e]" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	n := 0
+	for _, err := range client.QueryIter(context.Background(), "SELECT v FROM t") {
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected the error to be yielded once, got %d", n)
+	}
+}
@@ -0,0 +1,67 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	okPacket := []byte{7, 0, 0, 1, 0, 2, 0, 2, 0, 0, 0}
+	RegisterDialContext("clienttest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveReplies(server, [][]byte{serverHandshake, serverAuthOK, textResultSet("a", "b"), okPacket})
+		return client, nil
+	})
+
+	cfg := NewConfig()
+	cfg.Net = "clienttest"
+	cfg.Addr = "localhost"
+	cfg.InterpolateParams = true
+	client, err := Connect(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	rows, err := client.Query(context.Background(), "SELECT v FROM t WHERE id > ?", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns := rows.Columns(); fmt.Sprint(columns) != "[v]" {
+		t.Errorf("unexpected columns %v", columns)
+	}
+	var values []string
+	for rows.Next() {
+		values = append(values, string(rows.Values()[0].([]byte)))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(values) != "[a b]" {
+		t.Errorf("unexpected values %v", values)
+	}
+	if !rows.closed {
+		t.Error("expected the rows to be closed after the last result set")
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Exec(context.Background(), "UPDATE t SET v = ?", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 2 {
+		t.Errorf("expected 2 affected rows, got %d, %v", n, err)
+	}
+}
@@ -6,6 +6,9 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this file,
 // You can obtain one at http://mozilla.org/MPL/2.0/.
 
+//go:build !mysql_nocharmaps && !mysql_tiny
+// +build !mysql_nocharmaps,!mysql_tiny
+
 package mysql
 
 const defaultCollation = "utf8mb4_general_ci"
@@ -0,0 +1,36 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build mysql_nocharmaps || mysql_tiny
+// +build mysql_nocharmaps mysql_tiny
+
+package mysql
+
+const defaultCollation = "utf8mb4_general_ci"
+const binaryCollation = "binary"
+
+// The collations of the utf8mb4, utf8, latin1 and ascii character sets which
+// are used most, mapped to the internal ID. See collations.go for the full
+// list.
+var collations = map[string]byte{
+	"latin1_swedish_ci":  8,
+	"ascii_general_ci":   11,
+	"utf8_general_ci":    33,
+	"utf8mb4_general_ci": 45,
+	"utf8mb4_bin":        46,
+	"latin1_bin":         47,
+	"binary":             63,
+	"ascii_bin":          65,
+	"utf8_bin":           83,
+	"utf8_unicode_ci":    192,
+	"utf8mb4_unicode_ci": 224,
+	"utf8mb4_0900_ai_ci": 255,
+}
+
+// None of the collations above is unsafe to interpolate parameters.
+var unsafeCollations = map[string]bool{}
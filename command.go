@@ -0,0 +1,178 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+func (mc *mysqlConn) RawCommand(ctx context.Context, command byte, arg []byte, fn func(packet []byte) (more bool, err error)) error {
+	if mc.closed.IsSet() {
+		mc.log(ErrInvalidConn)
+		return driver.ErrBadConn
+	}
+
+	if err := mc.watchCancel(ctx); err != nil {
+		return err
+	}
+	defer mc.finish()
+
+	if err := mc.writeCommandPacketStr(command, string(arg)); err != nil {
+		return mc.markBadConn(err)
+	}
+	if fn == nil {
+		return nil
+	}
+
+	for {
+		data, err := mc.readPacket()
+		if err != nil {
+			return err
+		}
+		if data[0] == iERR {
+			return mc.handleErrorPacket(data)
+		}
+		more, err := fn(data)
+		if err != nil {
+			if more {
+				// the rest of the response can not be skipped
+				mc.cleanup()
+			}
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// simpleCommand sends a command, which is answered with an OK or EOF packet
+// on success.
+func (mc *mysqlConn) simpleCommand(ctx context.Context, command byte, arg []byte) error {
+	return mc.RawCommand(ctx, command, arg, func(packet []byte) (bool, error) {
+		switch packet[0] {
+		case iOK:
+			return false, mc.handleOkPacket(packet)
+		case iEOF:
+			return false, nil
+		default:
+			return false, mc.malformed(packet)
+		}
+	})
+}
+
+func (mc *mysqlConn) Debug(ctx context.Context) error {
+	return mc.simpleCommand(ctx, comDebug, nil)
+}
+
+func (mc *mysqlConn) Shutdown(ctx context.Context) error {
+	return mc.simpleCommand(ctx, comShutdown, nil)
+}
+
+func (mc *mysqlConn) ServerStats(ctx context.Context) (ServerStats, error) {
+	var stats ServerStats
+	err := mc.RawCommand(ctx, comStatistics, nil, func(packet []byte) (bool, error) {
+		stats = parseServerStats(string(packet))
+		return false, nil
+	})
+	return stats, err
+}
+
+func (mc *mysqlConn) SetMultiStatements(ctx context.Context, enabled bool) error {
+	option := optionMultiStatementsOff
+	if enabled {
+		option = optionMultiStatementsOn
+	}
+	if err := mc.simpleCommand(ctx, comSetOption, []byte{byte(option), byte(option >> 8)}); err != nil {
+		return err
+	}
+	if enabled {
+		mc.clientFlags |= clientMultiStatements
+	} else {
+		mc.clientFlags &^= clientMultiStatements
+	}
+	return nil
+}
+
+func (mc *mysqlConn) ChangeUser(ctx context.Context, user, passwd, dbName string) error {
+	if mc.closed.IsSet() {
+		mc.log(ErrInvalidConn)
+		return driver.ErrBadConn
+	}
+	if err := mc.watchCancel(ctx); err != nil {
+		return err
+	}
+	defer mc.finish()
+
+	if mc.dsnCfg == nil {
+		mc.dsnCfg = mc.cfg
+	}
+	cfg := mc.cfg.Clone()
+	cfg.User = user
+	cfg.Passwd = passwd
+	cfg.DBName = dbName
+	mc.cfg = cfg
+	if err := mc.changeUser(); err != nil {
+		// the server closes the connection if the authentication fails
+		mc.cleanup()
+		return err
+	}
+	return mc.handleParams()
+}
+
+// restoreUser switches back to the user and database of the DSN, which
+// ChangeUser changed for the previous user of the connection.
+func (mc *mysqlConn) restoreUser(ctx context.Context) error {
+	dsn := mc.dsnCfg
+	if mc.cfg.User == dsn.User && mc.cfg.Passwd == dsn.Passwd && mc.cfg.DBName == dsn.DBName {
+		mc.cfg = dsn
+		mc.dsnCfg = nil
+		return nil
+	}
+	if err := mc.watchCancel(ctx); err != nil {
+		return err
+	}
+	defer mc.finish()
+
+	mc.cfg = dsn
+	mc.dsnCfg = nil
+	if err := mc.changeUser(); err != nil {
+		return err
+	}
+	return mc.handleParams()
+}
+
+// changeUser authenticates as the user of mc.cfg with the auth plugin which
+// the server accepted before, which the server may switch.
+func (mc *mysqlConn) changeUser() error {
+	plugin := mc.authPlugin
+	if plugin == "" {
+		plugin = defaultAuthPlugin
+	}
+	authResp, err := mc.auth(mc.scramble, plugin)
+	if err != nil {
+		return err
+	}
+	if err := mc.writeChangeUserPacket(authResp, plugin); err != nil {
+		return err
+	}
+	mc.cleartextAuth = false
+	if err := mc.handleAuthResult(mc.scramble, plugin); err != nil {
+		return err
+	}
+
+	// the server reset the session
+	mc.sessionVars = nil
+	mc.openStmts = nil
+	mc.lastGTID = ""
+	mc.ansiQuotes = false
+	mc.autoIncIncrement = 0
+	return nil
+}
@@ -0,0 +1,280 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRawCommand(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	stats := "Uptime: 42  Threads: 1"
+	conn.queuedReplies = [][]byte{append([]byte{byte(len(stats)), 0, 0, 1}, stats...)}
+
+	var got []byte
+	err := mc.RawCommand(context.Background(), comStatistics, nil, func(packet []byte) (bool, error) {
+		got = append(got, packet...)
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != stats {
+		t.Errorf("expected %q, got %q", stats, got)
+	}
+	if !bytes.Equal(conn.written, []byte{1, 0, 0, 0, comStatistics}) {
+		t.Errorf("unexpected command packet %v", conn.written)
+	}
+
+	// ERR packets are returned as errors
+	conn.written = nil
+	conn.queuedReplies = [][]byte{{0x09, 0x00, 0x00, 0x01, 0xff, 0x27, 0x04, 'd', 'e', 'n', 'i', 'e', 'd'}}
+	err = mc.RawCommand(context.Background(), comDebug, nil, func(packet []byte) (bool, error) {
+		t.Errorf("unexpected call with %v", packet)
+		return false, nil
+	})
+	if merr, ok := err.(*MySQLError); !ok || merr.Number != 1063 {
+		t.Errorf("expected *MySQLError 1063, got %#v", err)
+	}
+
+	// an error of fn in the middle of the response closes the connection
+	conn.queuedReplies = [][]byte{{1, 0, 0, 1, 'a', 1, 0, 0, 2, 'b'}}
+	errStop := errors.New("stop")
+	err = mc.RawCommand(context.Background(), 0x1f, []byte{1, 2}, func(packet []byte) (bool, error) {
+		return true, errStop
+	})
+	if err != errStop {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("the connection was not closed")
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{
+		{5, 0, 0, 1, iEOF, 0, 0, 2, 0},
+		{7, 0, 0, 1, iOK, 0, 0, 2, 0, 0, 0},
+	}
+	if err := mc.Debug(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{1, 0, 0, 0, comDebug, 1, 0, 0, 0, comShutdown}
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("expected %v, got %v", expected, conn.written)
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{textResultSet("a", "b")}
+
+	err := mc.QueryEach(context.Background(), "SELECT v FROM t", nil, func(RowView) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := textResultSet("a", "b")
+	expected := ConnStats{
+		BytesRead:      uint64(len(reply)),
+		BytesWritten:   uint64(len(conn.written)),
+		PacketsRead:    6, // column count, column, EOF, 2 rows, EOF
+		PacketsWritten: 1,
+		Commands:       1,
+		Rows:           2,
+	}
+	if stats := mc.Stats(); stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestChangeUser(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.flags = clientProtocol41 | clientSecureConn | clientPluginAuth
+	mc.clientFlags = mc.flags
+	mc.scramble = []byte{9, 8, 7, 6, 5, 4, 3, 2, 1, 0, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+	mc.authPlugin = "mysql_native_password"
+	mc.collationID = 45
+	mc.sessionVars = map[string]string{"version": "8.0.26"}
+	mc.openStmts = map[uint32]*mysqlStmt{1: {mc: mc, id: 1}}
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, iOK, 0, 0, 2, 0, 0, 0}}
+
+	if err := mc.ChangeUser(context.Background(), "tenant", "secret", "db"); err != nil {
+		t.Fatal(err)
+	}
+	var expected []byte
+	expected = append(expected, comChangeUser)
+	expected = append(expected, "tenant\x00"...)
+	expected = append(expected, 20)
+	expected = append(expected, scramblePassword(mc.scramble, "secret")...)
+	expected = append(expected, "db\x00"...)
+	expected = append(expected, 45, 0)
+	expected = append(expected, "mysql_native_password\x00"...)
+	expected = append([]byte{byte(len(expected)), 0, 0, 0}, expected...)
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("expected %v, got %v", expected, conn.written)
+	}
+	if mc.cfg.User != "tenant" || mc.cfg.DBName != "db" {
+		t.Errorf("unexpected user %q and database %q", mc.cfg.User, mc.cfg.DBName)
+	}
+	if mc.sessionVars != nil || mc.openStmts != nil {
+		t.Error("expected the state of the session to be reset")
+	}
+
+	// the connection is closed if the authentication fails
+	conn.queuedReplies = [][]byte{{0x09, 0x00, 0x00, 0x01, 0xff, 0x15, 0x04, 'd', 'e', 'n', 'i', 'e', 'd'}}
+	err := mc.ChangeUser(context.Background(), "other", "wrong", "")
+	if merr, ok := err.(*MySQLError); !ok || merr.Number != 1045 {
+		t.Errorf("expected *MySQLError 1045, got %#v", err)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("the connection was not closed")
+	}
+}
+
+func TestChangeUserReset(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.cfg.User = "app"
+	mc.cfg.Passwd = "pass"
+	mc.cfg.DBName = "appdb"
+	mc.cfg.CheckConnLiveness = false
+	mc.flags = clientProtocol41 | clientSecureConn | clientPluginAuth
+	mc.clientFlags = mc.flags
+	mc.scramble = []byte{9, 8, 7, 6, 5, 4, 3, 2, 1, 0, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+	mc.authPlugin = "mysql_native_password"
+	dsnCfg := mc.cfg
+	conn.queuedReplies = [][]byte{
+		{7, 0, 0, 1, iOK, 0, 0, 2, 0, 0, 0},
+		{7, 0, 0, 1, iOK, 0, 0, 2, 0, 0, 0},
+	}
+	if err := mc.ChangeUser(context.Background(), "tenant", "secret", "db"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the user of the DSN is restored before the connection is reused
+	conn.written = nil
+	if err := mc.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mc.cfg != dsnCfg {
+		t.Errorf("expected the config of the DSN, got user %q and database %q", mc.cfg.User, mc.cfg.DBName)
+	}
+	if len(conn.written) < 5 || conn.written[4] != comChangeUser || !bytes.Contains(conn.written, []byte("app\x00")) {
+		t.Errorf("expected COM_CHANGE_USER for the DSN user, got %v", conn.written)
+	}
+	conn.written = nil
+	if err := mc.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.written) != 0 {
+		t.Errorf("unexpected command %v", conn.written)
+	}
+
+	// the connection is closed if the user can't be restored
+	conn.queuedReplies = [][]byte{
+		{7, 0, 0, 1, iOK, 0, 0, 2, 0, 0, 0},
+		{0x09, 0x00, 0x00, 0x01, 0xff, 0x15, 0x04, 'd', 'e', 'n', 'i', 'e', 'd'},
+	}
+	if err := mc.ChangeUser(context.Background(), "tenant", "secret", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.ResetSession(context.Background()); err != driver.ErrBadConn {
+		t.Errorf("expected driver.ErrBadConn, got %v", err)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("the connection was not closed")
+	}
+}
+
+func TestSetMultiStatements(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{
+		{5, 0, 0, 1, iEOF, 0, 0, 2, 0},
+		{5, 0, 0, 1, iEOF, 0, 0, 2, 0},
+	}
+	if err := mc.SetMultiStatements(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if mc.clientFlags&clientMultiStatements == 0 {
+		t.Error("expected multi statements to be enabled")
+	}
+	if err := mc.SetMultiStatements(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if mc.clientFlags&clientMultiStatements != 0 {
+		t.Error("expected multi statements to be disabled")
+	}
+	expected := []byte{3, 0, 0, 0, comSetOption, 0, 0, 3, 0, 0, 0, comSetOption, 1, 0}
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("expected %v, got %v", expected, conn.written)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	raw := "Uptime: 3725  Threads: 4  Questions: 1024  Slow queries: 2  Opens: 130  Flush tables: 3  Open tables: 62  Queries per second avg: 0.274"
+	conn.queuedReplies = [][]byte{append([]byte{byte(len(raw)), 0, 0, 1}, raw...)}
+
+	stats, err := mc.ServerStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ServerStats{
+		Uptime:           3725 * time.Second,
+		Threads:          4,
+		Questions:        1024,
+		SlowQueries:      2,
+		Opens:            130,
+		FlushTables:      3,
+		OpenTables:       62,
+		QueriesPerSecond: 0.274,
+		Raw:              raw,
+	}
+	if stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestSetMultiStatementsReset(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.cfg.CheckConnLiveness = false
+	conn.queuedReplies = [][]byte{
+		{5, 0, 0, 1, iEOF, 0, 0, 2, 0},
+		{5, 0, 0, 1, iEOF, 0, 0, 2, 0},
+	}
+	if err := mc.SetMultiStatements(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the setting of the DSN is restored before the connection is reused
+	if err := mc.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mc.clientFlags&clientMultiStatements != 0 {
+		t.Error("expected multi statements to be disabled again")
+	}
+	if !bytes.HasSuffix(conn.written, []byte{3, 0, 0, 0, comSetOption, 1, 0}) {
+		t.Errorf("expected COM_SET_OPTION to be sent, got %v", conn.written)
+	}
+	conn.written = nil
+	if err := mc.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.written) != 0 {
+		t.Errorf("unexpected command %v", conn.written)
+	}
+}
@@ -0,0 +1,274 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// defaultMinCompressLength is the size below which frames are sent
+// uncompressed if minCompressLength is not set, as the server does.
+const defaultMinCompressLength = 50
+
+// defaultZstdLevel is the compression level of zstd if zstdLevel is not set,
+// which is the default of the server.
+const defaultZstdLevel = 3
+
+// ZstdCodec compresses and decompresses the frames of the compressed protocol
+// with zstd, for compressionAlgorithm=zstd. The standard library has no zstd
+// implementation, so the driver does not ship one; applications register
+// one with RegisterZstd.
+type ZstdCodec interface {
+	// Compress appends src, compressed into a zstd frame at the given
+	// level, to dst and returns the result.
+	Compress(dst, src []byte, level int) ([]byte, error)
+
+	// Decompress appends the decompressed content of the zstd frame src to
+	// dst and returns the result.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var (
+	zstdLock  sync.RWMutex
+	zstdCodec ZstdCodec
+)
+
+// RegisterZstd registers the zstd implementation which is used by the
+// connections with compressionAlgorithm=zstd.
+func RegisterZstd(codec ZstdCodec) {
+	zstdLock.Lock()
+	defer zstdLock.Unlock()
+	zstdCodec = codec
+}
+
+func registeredZstd() ZstdCodec {
+	zstdLock.RLock()
+	defer zstdLock.RUnlock()
+	return zstdCodec
+}
+
+var errZstdNotRegistered = errors.New("compressionAlgorithm=zstd requires a codec registered with RegisterZstd")
+
+// compressedConn implements the compressed protocol on top of a connection.
+// The packets written and read by the driver are carried in compressed
+// frames, which have a header of their own:
+//
+//	compressed length [3 bytes]
+//	sequence id [1 byte]
+//	uncompressed length [3 bytes], 0 if the payload is not compressed
+//
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_compression.html
+type compressedConn struct {
+	net.Conn
+
+	// sequence id of the frames, which is reset with the sequence id of
+	// the packets at the start of a command, see resetSequence
+	sequence uint8
+
+	// payloads shorter than minLength are sent uncompressed, 0 for
+	// defaultMinCompressLength
+	minLength int
+
+	// zstd compresses the frames instead of zlib if it is set
+	zstd  ZstdCodec
+	level int
+	zout  []byte // zstd compressed payload of the frame being written
+
+	zlibLevel int // 0 for the default level
+	zw        *zlib.Writer
+	zbuf      bytes.Buffer // compressed payload of the frame being written
+
+	zr   io.ReadCloser
+	br   bytes.Reader
+	in   []byte // payload of the last frame read
+	out  []byte // uncompressed payload of the last frame read
+	rbuf []byte // unread part of the payload of the last frame read
+}
+
+// startCompression switches the connection to the compressed protocol,
+// after the authentication succeeded.
+func (mc *mysqlConn) startCompression() {
+	if mc.rawConn == nil {
+		mc.rawConn = mc.netConn
+	}
+	cc := &compressedConn{
+		Conn:      mc.netConn,
+		minLength: mc.cfg.MinCompressLength,
+		zlibLevel: mc.cfg.ZlibLevel,
+	}
+	if mc.clientFlags&clientZstdCompressionAlgorithm != 0 {
+		cc.zstd = registeredZstd()
+		cc.level = mc.cfg.zstdLevel()
+	}
+	mc.netConn = cc
+	mc.buf.nc = mc.netConn
+	mc.compress = true
+}
+
+// zstdLevel returns the zstd compression level of the connections.
+func (cfg *Config) zstdLevel() int {
+	if cfg.ZstdLevel == 0 {
+		return defaultZstdLevel
+	}
+	return cfg.ZstdLevel
+}
+
+// Write sends the packets of p in compressed frames.
+func (c *compressedConn) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		size := len(p) - n
+		if size > maxPacketSize {
+			size = maxPacketSize
+		}
+		if err := c.writeFrame(p[n : n+size]); err != nil {
+			return 0, err
+		}
+		n += size
+	}
+	return len(p), nil
+}
+
+func (c *compressedConn) writeFrame(payload []byte) error {
+	uncompressedLen := 0
+	minLength := c.minLength
+	if minLength == 0 {
+		minLength = defaultMinCompressLength
+	}
+	if len(payload) >= minLength {
+		compressed, err := c.compress(payload)
+		if err != nil {
+			return err
+		}
+		// send incompressible payloads as they are
+		if len(compressed) < len(payload) {
+			uncompressedLen = len(payload)
+			payload = compressed
+		}
+	}
+
+	header := [7]byte{
+		byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16),
+		c.sequence,
+		byte(uncompressedLen), byte(uncompressedLen >> 8), byte(uncompressedLen >> 16),
+	}
+	c.sequence++
+	bufs := net.Buffers{header[:], payload}
+	_, err := bufs.WriteTo(c.Conn)
+	return err
+}
+
+// compress returns the compressed payload, which is valid until the next
+// call.
+func (c *compressedConn) compress(payload []byte) ([]byte, error) {
+	if c.zstd != nil {
+		out, err := c.zstd.Compress(c.zout[:0], payload, c.level)
+		c.zout = out
+		return out, err
+	}
+
+	c.zbuf.Reset()
+	if c.zw == nil {
+		level := zlib.DefaultCompression
+		if c.zlibLevel != 0 {
+			level = c.zlibLevel
+		}
+		zw, err := zlib.NewWriterLevel(&c.zbuf, level)
+		if err != nil {
+			return nil, err
+		}
+		c.zw = zw
+	} else {
+		c.zw.Reset(&c.zbuf)
+	}
+	if _, err := c.zw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := c.zw.Close(); err != nil {
+		return nil, err
+	}
+	return c.zbuf.Bytes(), nil
+}
+
+// Read returns the packets carried by the frames read from the connection.
+func (c *compressedConn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *compressedConn) readFrame() error {
+	var header [7]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return err
+	}
+	compressedLen := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	c.sequence = header[3] + 1
+	uncompressedLen := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+
+	if cap(c.in) < compressedLen {
+		c.in = make([]byte, compressedLen)
+	}
+	c.in = c.in[:compressedLen]
+	if _, err := io.ReadFull(c.Conn, c.in); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if uncompressedLen == 0 {
+		c.rbuf = c.in
+		return nil
+	}
+
+	if c.zstd != nil {
+		out, err := c.zstd.Decompress(c.out[:0], c.in)
+		if err != nil {
+			return err
+		}
+		c.out = out
+		if len(out) != uncompressedLen {
+			return io.ErrUnexpectedEOF
+		}
+		c.rbuf = c.out
+		return nil
+	}
+
+	c.br.Reset(c.in)
+	if c.zr == nil {
+		zr, err := zlib.NewReader(&c.br)
+		if err != nil {
+			return err
+		}
+		c.zr = zr
+	} else if err := c.zr.(zlib.Resetter).Reset(&c.br, nil); err != nil {
+		return err
+	}
+	if cap(c.out) < uncompressedLen {
+		c.out = make([]byte, uncompressedLen)
+	}
+	c.out = c.out[:uncompressedLen]
+	if _, err := io.ReadFull(c.zr, c.out); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	c.rbuf = c.out
+	return nil
+}
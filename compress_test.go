@@ -0,0 +1,291 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestCompressedConn(t *testing.T) {
+	conn := new(mockConn)
+	cc := &compressedConn{Conn: conn}
+
+	small := []byte{1, 0, 0, 0, comPing}
+	large := append([]byte{0, 4, 0, 1}, bytes.Repeat([]byte("SELECT "), 150)...)[:4+1024]
+	cc.Write(small)
+	cc.Write(large)
+
+	frames := conn.written
+	for i, want := range [][]byte{small, large} {
+		compressedLen := int(frames[0]) | int(frames[1])<<8 | int(frames[2])<<16
+		uncompressedLen := int(frames[4]) | int(frames[5])<<8 | int(frames[6])<<16
+		if frames[3] != byte(i) {
+			t.Errorf("frame %d: expected the sequence id %d, got %d", i, i, frames[3])
+		}
+		if i == 0 && (uncompressedLen != 0 || compressedLen != len(want)) {
+			t.Errorf("expected a small packet to be sent uncompressed, got header %v", frames[:7])
+		}
+		if i == 1 && (uncompressedLen != len(want) || compressedLen >= len(want)) {
+			t.Errorf("expected a large packet to be compressed, got header %v", frames[:7])
+		}
+		frames = frames[7+compressedLen:]
+	}
+	if len(frames) != 0 {
+		t.Fatalf("%d bytes left after the frames", len(frames))
+	}
+
+	// the frames are read back as the packets
+	conn.data = conn.written
+	got := make([]byte, len(small)+len(large))
+	if _, err := io.ReadFull(&compressedConn{Conn: conn}, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append(small, large...)) {
+		t.Errorf("unexpected packets %q", got)
+	}
+}
+
+func TestCompressedConnTuning(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.cfg.MinCompressLength = 1024
+	mc.cfg.ZlibLevel = zlib.BestSpeed
+	mc.startCompression()
+
+	medium := append([]byte{0, 2, 0, 0}, bytes.Repeat([]byte("SELECT "), 100)...)[:4+512]
+	large := append([]byte{0, 4, 0, 1}, bytes.Repeat([]byte("SELECT "), 150)...)[:4+1024]
+	mc.netConn.Write(medium)
+	mc.netConn.Write(large)
+
+	frames := conn.written
+	if uncompressedLen := int(frames[4]) | int(frames[5])<<8 | int(frames[6])<<16; uncompressedLen != 0 {
+		t.Errorf("expected a packet below minCompressLength to be sent uncompressed, got header %v", frames[:7])
+	}
+	frames = frames[7+len(medium):]
+
+	var zbuf bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&zbuf, zlib.BestSpeed)
+	zw.Write(large)
+	zw.Close()
+	if !bytes.Equal(frames[7:], zbuf.Bytes()) {
+		t.Error("expected the large packet to be compressed with zlibLevel")
+	}
+}
+
+func TestCompressedConnSequenceWrap(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	cc := &compressedConn{Conn: conn}
+	mc.netConn = cc
+	mc.resetSequence()
+
+	// the server sent 3 packets in its first frame, e.g. a LOAD DATA LOCAL
+	// INFILE request, so the sequences of the packets and frames differ
+	mc.sequence = 4
+	cc.sequence = 1
+
+	// the sequence of the packets wraps while the file is sent
+	for i := 0; i < 300; i++ {
+		if err := mc.writePacket([]byte{0, 0, 0, 0, 'x'}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frames := conn.written
+	for i := 0; i < 300; i++ {
+		if want := byte(1 + i); frames[3] != want {
+			t.Fatalf("frame %d: expected the sequence id %d, got %d", i, want, frames[3])
+		}
+		frames = frames[7+int(frames[0]):]
+	}
+
+	// a new command resets both sequences
+	conn.written = nil
+	if err := mc.writeCommandPacket(comPing); err != nil {
+		t.Fatal(err)
+	}
+	if conn.written[3] != 0 || conn.written[7+3] != 0 {
+		t.Errorf("expected the sequences to be reset, got %v", conn.written)
+	}
+}
+
+func TestConnectCompress(t *testing.T) {
+	handshake := append([]byte(nil), serverHandshake...)
+	// header, protocol version, "5.5.8", connection id, auth data, filler
+	handshake[4+1+6+4+8+1] |= byte(clientCompress)
+
+	RegisterDialContext("compresstest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			var head [4]byte
+			server.Write(handshake)
+			// the handshake response
+			if _, err := io.ReadFull(server, head[:]); err != nil {
+				return
+			}
+			flags := make([]byte, int(head[0])|int(head[1])<<8|int(head[2])<<16)
+			if _, err := io.ReadFull(server, flags); err != nil {
+				return
+			}
+			if clientFlag(flags[0])&clientCompress == 0 {
+				t.Error("clientCompress is not requested")
+			}
+			server.Write(serverAuthOK)
+
+			// everything after the authentication is compressed; nothing is
+			// sent before the first command
+			serveReplies(&compressedConn{Conn: server}, [][]byte{nil, textResultSet(bytesOf('x', 300))})
+		}()
+		return client, nil
+	})
+
+	cfg := NewConfig()
+	cfg.Net = "compresstest"
+	cfg.Addr = "localhost"
+	cfg.Compress = true
+	conn, err := newConnector(cfg).Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	mc := conn.(*mysqlConn)
+	if !mc.compress {
+		t.Fatal("the compressed protocol is not used")
+	}
+	rows, err := mc.QueryContext(context.Background(), "SELECT v", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if v := string(dest[0].([]byte)); v != bytesOf('x', 300) {
+		t.Errorf("unexpected value %q", v)
+	}
+	rows.Close()
+}
+
+// fakeZstd stands in for a zstd implementation, with zlib and a marker of
+// the level.
+type fakeZstd struct{}
+
+func (fakeZstd) Compress(dst, src []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(byte(level))
+	zw := zlib.NewWriter(&buf)
+	zw.Write(src)
+	zw.Close()
+	return append(dst, buf.Bytes()...), nil
+}
+
+func (fakeZstd) Decompress(dst, src []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(src[1:]))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(zr); err != nil {
+		return nil, err
+	}
+	return append(dst, buf.Bytes()...), nil
+}
+
+func TestCompressedConnZstd(t *testing.T) {
+	conn := new(mockConn)
+	cc := &compressedConn{Conn: conn, zstd: fakeZstd{}, level: 7}
+	large := append([]byte{0, 4, 0, 0}, bytes.Repeat([]byte("SELECT "), 150)...)[:4+1024]
+	cc.Write(large)
+	if conn.written[7] != 7 {
+		t.Errorf("expected the frame to be compressed by the codec, got %v", conn.written[:8])
+	}
+
+	conn.data = conn.written
+	got := make([]byte, len(large))
+	if _, err := io.ReadFull(&compressedConn{Conn: conn, zstd: fakeZstd{}}, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, large) {
+		t.Errorf("unexpected packet %q", got)
+	}
+}
+
+func TestConnectZstd(t *testing.T) {
+	handshake := append([]byte(nil), serverHandshake...)
+	handshake[4+1+6+4+8+1] |= byte(clientCompress)
+	// the upper capability flags follow the charset and the status flags
+	handshake[4+1+6+4+8+1+2+1+2+1] |= byte(clientZstdCompressionAlgorithm >> 24)
+
+	RegisterDialContext("zstdtest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			var head [4]byte
+			server.Write(handshake)
+			if _, err := io.ReadFull(server, head[:]); err != nil {
+				return
+			}
+			resp := make([]byte, int(head[0])|int(head[1])<<8|int(head[2])<<16)
+			if _, err := io.ReadFull(server, resp); err != nil {
+				return
+			}
+			flags := clientFlag(binary.LittleEndian.Uint32(resp))
+			if flags&clientZstdCompressionAlgorithm == 0 || flags&clientCompress != 0 {
+				t.Errorf("expected only zstd to be requested, got flags %#x", flags)
+			}
+			if level := resp[len(resp)-1]; level != 5 {
+				t.Errorf("expected the zstd level 5, got %d", level)
+			}
+			server.Write(serverAuthOK)
+			serveReplies(&compressedConn{Conn: server, zstd: fakeZstd{}}, [][]byte{nil, textResultSet(bytesOf('x', 300))})
+		}()
+		return client, nil
+	})
+
+	cfg := NewConfig()
+	cfg.Net = "zstdtest"
+	cfg.Addr = "localhost"
+	cfg.Compress = true
+	cfg.CompressionAlgorithm = "zstd"
+	cfg.ZstdLevel = 5
+
+	RegisterZstd(nil)
+	if _, err := newConnector(cfg).Connect(context.Background()); err != errZstdNotRegistered {
+		t.Fatalf("expected errZstdNotRegistered, got %v", err)
+	}
+
+	RegisterZstd(fakeZstd{})
+	defer RegisterZstd(nil)
+	conn, err := newConnector(cfg).Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rows, err := conn.(*mysqlConn).QueryContext(context.Background(), "SELECT v", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if v := string(dest[0].([]byte)); v != bytesOf('x', 300) {
+		t.Errorf("unexpected value %q", v)
+	}
+	rows.Close()
+}
+
+func bytesOf(c byte, n int) string {
+	return string(bytes.Repeat([]byte{c}, n))
+}
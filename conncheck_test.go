@@ -11,6 +11,9 @@
 package mysql
 
 import (
+	"context"
+	"database/sql/driver"
+	"net"
 	"testing"
 	"time"
 )
@@ -36,3 +39,45 @@ func TestStaleConnectionChecks(t *testing.T) {
 		}
 	})
 }
+
+func TestResetSessionDetectsClosedConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mc := &mysqlConn{
+		netConn: client,
+		buf:     newBuffer(client),
+		cfg:     NewConfig(),
+		closech: make(chan struct{}),
+	}
+	if err := mc.ResetSession(context.Background()); err != nil {
+		t.Fatalf("expected an idle connection to be valid, got %v", err)
+	}
+
+	// the server closes the idle connection
+	server.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err = mc.ResetSession(context.Background())
+		if err != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err != driver.ErrBadConn {
+		t.Errorf("expected driver.ErrBadConn, got %v", err)
+	}
+}
@@ -10,39 +10,253 @@ package mysql
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// Conn provides the features of a connection which are not available through
+// database/sql. It is implemented by the connections of this driver and can be
+// accessed with sql.Conn.Raw (Go 1.16+):
+//
+//	err := conn.Raw(func(driverConn interface{}) error {
+//		return driverConn.(mysql.Conn).QueryEach(ctx, query, args, fn)
+//	})
+type Conn interface {
+	// QueryEach executes a query and calls fn for every row of its result
+	// set. See RowView for the lifetime of the row.
+	// Unlike Query, the values are not converted to driver.Value, which
+	// avoids allocations per row. The rows are read with the text protocol,
+	// args are interpolated into the query regardless of interpolateParams.
+	// If fn returns an error, the remaining rows are discarded and the error
+	// is returned.
+	QueryEach(ctx context.Context, query string, args []interface{}, fn func(row RowView) error) error
+
+	// QueryBatches executes a query like QueryEach, but decodes the rows
+	// into column-oriented batches of size rows, the last one possibly
+	// shorter, and calls fn for each batch. Integer and floating point
+	// columns are decoded to slices of their type, the other columns are
+	// kept in their text form. See Batch for the lifetime of the batch.
+	// fn is not called for a result set without rows.
+	QueryBatches(ctx context.Context, query string, args []interface{}, size int, fn func(batch *Batch) error) error
+
+	// EscapeString escapes s for use inside a quoted string literal.
+	// Quotes are doubled instead of escaped with backslashes if the server
+	// reported that NO_BACKSLASH_ESCAPES is in effect. ErrUnsafeCollation is
+	// returned if the collation of the connection is a multibyte encoding in
+	// which escaping is not safe.
+	EscapeString(s string) (string, error)
+
+	// EscapeBytes is like EscapeString, but for byte slices.
+	EscapeBytes(b []byte) ([]byte, error)
+
+	// RawCommand sends a command packet with the command byte (COM_*) and
+	// its arguments, for commands which the driver does not model.
+	// fn is called with every packet of the response, without the packet
+	// header, until it returns false; the packet is only valid during the
+	// call. An ERR packet ends the response and is returned as *MySQLError.
+	// If fn is nil, no response is read. If fn returns an error while more
+	// packets are expected, the connection is closed.
+	//
+	// arg is sent as given. If the server supports query attributes
+	// (CLIENT_QUERY_ATTRIBUTES, MySQL 8.0.23 and later), the arguments of
+	// COM_QUERY must start with the parameter count and the parameter set
+	// count, e.g. 0x00 0x01 for a query without attributes.
+	RawCommand(ctx context.Context, command byte, arg []byte, fn func(packet []byte) (more bool, err error)) error
+
+	// Debug makes the server write debug information to its error log
+	// (COM_DEBUG). It requires the SUPER privilege.
+	Debug(ctx context.Context) error
+
+	// Shutdown asks the server to shut down (COM_SHUTDOWN). It requires the
+	// SHUTDOWN privilege. MySQL 8.0 removed the command; use the SHUTDOWN
+	// statement there.
+	Shutdown(ctx context.Context) error
+
+	// ServerStats returns the uptime and the counters of the server
+	// (COM_STATISTICS), e.g. for health dashboards, without the privileges
+	// and the cost of SHOW GLOBAL STATUS.
+	ServerStats(ctx context.Context) (ServerStats, error)
+
+	// SetMultiStatements allows or forbids multiple statements in one query
+	// for the connection (COM_SET_OPTION), regardless of multiStatements,
+	// e.g. to run a trusted migration script and lock the connection down
+	// again afterwards. database/sql resets the connection to the setting
+	// of the DSN before it is reused.
+	SetMultiStatements(ctx context.Context, enabled bool) error
+
+	// ChangeUser authenticates the connection as user with passwd and
+	// selects dbName as the default database (COM_CHANGE_USER), e.g. to
+	// switch tenants without the cost of a new TCP and TLS connection.
+	// The server resets the session as for a new connection: prepared
+	// statements are closed, and transactions, user variables and
+	// temporary tables are dropped. The system variables of the DSN are set
+	// again, the roles are not. If the authentication fails, the connection
+	// is closed. database/sql switches the connection back to the user and
+	// database of the DSN before it is reused.
+	ChangeUser(ctx context.Context, user, passwd, dbName string) error
+
+	// LocalAddr returns the local address of the connection.
+	LocalAddr() net.Addr
+
+	// RemoteAddr returns the address of the server the connection reached,
+	// e.g. the backend behind a load balancer's DNS name.
+	RemoteAddr() net.Addr
+
+	// TLSConnectionState returns the state of the TLS connection, with the
+	// negotiated version and cipher suite. ok is false if the connection
+	// does not use TLS.
+	TLSConnectionState() (state tls.ConnectionState, ok bool)
+
+	// Stats returns the I/O counters of the connection since it was
+	// established, e.g. to attribute bandwidth to pools or tenants.
+	Stats() ConnStats
+
+	// StatementStats returns the execution counters of the prepared
+	// statements of the connection, by query, ordered by descending total
+	// latency, to find hotspots without instrumenting the server. At most
+	// 1000 queries are tracked; the least executed one is dropped for a
+	// new query, so queries with inlined literals don't grow the counters
+	// without limit.
+	StatementStats() []StmtStats
+
+	// Labels returns the Labels of the config of the connection, e.g. as
+	// dimensions of metrics. The map must not be modified.
+	Labels() map[string]string
+
+	// OpenStatements returns the prepared statements of the connection
+	// which were not closed yet, e.g. to find statements leaked by the
+	// application, which count against max_prepared_stmt_count. Statements
+	// which are still open are logged when the connection is closed.
+	OpenStatements() []OpenStatement
+
+	// ServerVariable returns the value of a system variable of the session,
+	// like SELECT @@name. The value is cached for the connection. Changes
+	// reported by the server's session tracking update the cache, which is
+	// emptied after SET and CALL statements and multiple statements of the
+	// user, as the server reports only the variables listed in
+	// session_track_system_variables.
+	ServerVariable(ctx context.Context, name string) (string, error)
+
+	// LastGTID returns the GTIDs of the last transaction committed on the
+	// connection, e.g. to wait for them on a replica with
+	// WAIT_FOR_EXECUTED_GTID_SET. The server reports them only if
+	// session_track_gtids is OWN_GTID or ALL_GTIDS; LastGTID returns ""
+	// otherwise. Transactions on replicas are not reported.
+	LastGTID() string
+
+	// AuthInfo returns how the connection was authenticated, e.g. to assert
+	// that the password was never sent in clear text without TLS.
+	AuthInfo() AuthInfo
+
+	// ServerVersion returns the version the server reported in the
+	// handshake, which is parsed as MariaDB's version if the server is
+	// MariaDB.
+	ServerVersion() ServerVersion
+
+	// Collation returns the collation negotiated in the handshake, i.e. the
+	// one of the config or, with serverCollation, the default collation of
+	// the server. It is empty if the driver doesn't know the collation the
+	// server reported. The charset parameter may have changed it since.
+	Collation() string
+}
+
+var _ Conn = &mysqlConn{}
+
 type mysqlConn struct {
 	buf              buffer
 	netConn          net.Conn
-	rawConn          net.Conn // underlying connection when netConn is TLS connection.
+	rawConn          net.Conn // underlying connection when netConn is TLS connection or compressed.
 	affectedRows     uint64
 	insertId         uint64
+	warnings         uint16
+	autoIncIncrement int64 // auto_increment_increment, 0 while unknown
+	allowInfile      bool  // the running statement may send any local file
+	emptyResults     bool  // the running query yields result sets without columns
+	maxReturning     int64 // rows of a result set kept by the running Exec, see WithReturning
+	queryAttrs       []queryAttribute
+	stats            ConnStats
+	stmtStats        map[string]*StmtStats // by query of the prepared statements
+	sessionVars      map[string]string     // cached by ServerVariable
+	lastGTID         string                // reported by the session tracking of GTIDs
+	openStmts        map[uint32]*mysqlStmt // prepared statements which are not closed, by id
 	cfg              *Config
+	dsnCfg           *Config // the config of the DSN after ChangeUser, nil before
+	connector        *connector
 	maxAllowedPacket int
 	maxWriteSize     int
+	maxReadPacket    int // 0 means no limit
 	writeTimeout     time.Duration
 	flags            clientFlag
+	clientFlags      clientFlag  // flags negotiated with the server
+	mariadbFlags     mariadbFlag // MariaDB extended flags negotiated with the server
 	status           statusFlag
 	sequence         uint8
+	command          byte // command in flight, for diagnostics
 	parseTime        bool
-	reset            bool // set when the Go SQL package calls ResetSession
+	compress         bool         // the compressed protocol is used
+	fields           []mysqlField // column metadata reused across result sets
+	connectionID     uint32
+	collation        string // collation negotiated in the handshake
+	collationID      byte   // id of collation, 0 before the handshake
+	serverCollation  byte   // default collation of the server
+	serverVersion    ServerVersion
+	kerberos         *kerberosTarget
+	scramble         []byte // auth data of the handshake, for COM_CHANGE_USER
+	authPlugin       string // auth plugin accepted by the server
+	cleartextAuth    bool   // the password was sent in clear text
+	ansiQuotes       bool   // sql_mode of the session includes ANSI_QUOTES
+	skipMetadata     bool   // the result set has the columns of the prepared statement
 
 	// for context support (Go 1.8+)
-	watching bool
-	watcher  chan<- context.Context
-	closech  chan struct{}
-	finished chan<- struct{}
-	canceled atomicError // set non-nil if conn is canceled
-	closed   atomicBool  // set when conn is closed, before closech is closed
+	watching   bool
+	watchCtx   context.Context // context of the running command
+	watchState *int32          // state of the running command, see watchIdle
+	stopWatch  func() bool     // stops watching the context of the running command
+	watcher    contextWatcher  // see afterFunc
+	closech    chan struct{}
+	canceled   atomicError // set non-nil if conn is canceled
+	closed     atomicBool  // set when conn is closed, before closech is closed
+
+	spool atomic.Value // *spoolWriter whose packets are read, closed by cleanup
+}
+
+// setRoles activates the Roles of the config.
+func (mc *mysqlConn) setRoles() error {
+	if len(mc.cfg.Roles) == 0 {
+		return nil
+	}
+	return mc.exec("SET ROLE " + formatRoles(mc.cfg.Roles))
+}
+
+// formatRoles formats the list of roles of SET ROLE. ALL, DEFAULT and NONE
+// are kept as keywords, other roles are quoted, with the host of name@host
+// quoted separately.
+func formatRoles(roles []string) string {
+	if len(roles) == 1 {
+		switch keyword := strings.ToUpper(roles[0]); keyword {
+		case "ALL", "DEFAULT", "NONE":
+			return keyword
+		}
+	}
+	quoted := make([]string, len(roles))
+	for i, role := range roles {
+		if at := strings.LastIndexByte(role, '@'); at >= 0 {
+			quoted[i] = QuoteIdentifier(role[:at]) + "@" + QuoteIdentifier(role[at+1:])
+		} else {
+			quoted[i] = QuoteIdentifier(role)
+		}
+	}
+	return strings.Join(quoted, ",")
 }
 
 // Handles parameters set in DSN after the connection is established
@@ -76,6 +290,7 @@ func (mc *mysqlConn) handleParams() (err error) {
 			cmdSet.WriteString(param)
 			cmdSet.WriteByte('=')
 			cmdSet.WriteString(val)
+			mc.sessionVarChanged(strings.ToLower(param), val)
 		}
 	}
 
@@ -89,6 +304,15 @@ func (mc *mysqlConn) handleParams() (err error) {
 	return
 }
 
+// checkQuerySize returns a *QuerySizeError if size exceeds the maxQuerySize
+// of the config.
+func (mc *mysqlConn) checkQuerySize(size int) error {
+	if mc.cfg.MaxQuerySize > 0 && size > mc.cfg.MaxQuerySize {
+		return &QuerySizeError{Size: size, Max: mc.cfg.MaxQuerySize}
+	}
+	return nil
+}
+
 func (mc *mysqlConn) markBadConn(err error) error {
 	if mc == nil {
 		return err
@@ -105,7 +329,7 @@ func (mc *mysqlConn) Begin() (driver.Tx, error) {
 
 func (mc *mysqlConn) begin(readOnly bool) (driver.Tx, error) {
 	if mc.closed.IsSet() {
-		errLog.Print(ErrInvalidConn)
+		mc.log(ErrInvalidConn)
 		return nil, driver.ErrBadConn
 	}
 	var q string
@@ -124,14 +348,35 @@ func (mc *mysqlConn) begin(readOnly bool) (driver.Tx, error) {
 func (mc *mysqlConn) Close() (err error) {
 	// Makes Close idempotent
 	if !mc.closed.IsSet() {
+		mc.logOpenStatements()
 		err = mc.writeCommandPacket(comQuit)
+		if err == nil && mc.cfg.CloseTimeout > 0 {
+			mc.awaitServerClose()
+		}
 	}
 
 	mc.cleanup()
+	mc.buf.release()
 
 	return
 }
 
+// awaitServerClose waits until the server closes the connection after
+// COM_QUIT, or CloseTimeout elapses. Anything the server still sends is
+// discarded. Letting the server close first avoids resetting connections
+// with unread data, which proxies and the server report as aborted.
+func (mc *mysqlConn) awaitServerClose() {
+	if err := mc.netConn.SetReadDeadline(time.Now().Add(mc.cfg.CloseTimeout)); err != nil {
+		return
+	}
+	var discard [256]byte
+	for {
+		if _, err := mc.netConn.Read(discard[:]); err != nil {
+			return
+		}
+	}
+}
+
 // Closes the network connection and unsets internal variables. Do not call this
 // function after successfully authentication, call Close instead. This function
 // is called before auth or on auth failure because MySQL will have already
@@ -143,11 +388,18 @@ func (mc *mysqlConn) cleanup() {
 
 	// Makes cleanup idempotent
 	close(mc.closech)
+	if w, _ := mc.spool.Load().(*spoolWriter); w != nil {
+		w.close()
+	}
+	if mc.connector != nil {
+		// only connections established by a connector are counted
+		atomic.AddInt64(&driverStats.connections, -1)
+	}
 	if mc.netConn == nil {
 		return
 	}
 	if err := mc.netConn.Close(); err != nil {
-		errLog.Print(err)
+		mc.log(err)
 	}
 }
 
@@ -163,33 +415,54 @@ func (mc *mysqlConn) error() error {
 
 func (mc *mysqlConn) Prepare(query string) (driver.Stmt, error) {
 	if mc.closed.IsSet() {
-		errLog.Print(ErrInvalidConn)
+		mc.log(ErrInvalidConn)
 		return nil, driver.ErrBadConn
 	}
+	if err := mc.checkQuerySize(len(query)); err != nil {
+		return nil, err
+	}
 	// Send command
 	err := mc.writeCommandPacketStr(comStmtPrepare, query)
 	if err != nil {
 		// STMT_PREPARE is safe to retry.  So we can return ErrBadConn here.
-		errLog.Print(err)
+		mc.log(err)
 		return nil, driver.ErrBadConn
 	}
 
 	stmt := &mysqlStmt{
-		mc: mc,
+		mc:        mc,
+		stats:     mc.statementStats(query),
+		queryText: query,
+		created:   time.Now(),
+	}
+	if mc.cfg.StmtStackTraces {
+		stmt.stack = debug.Stack()
 	}
 
 	// Read Result
 	columnCount, err := stmt.readPrepareResultPacket()
 	if err == nil {
 		if stmt.paramCount > 0 {
-			if err = mc.readUntilEOF(); err != nil {
+			if err = mc.skipColumns(stmt.paramCount); err != nil {
 				return nil, err
 			}
 		}
 
 		if columnCount > 0 {
-			err = mc.readUntilEOF()
+			if mc.mariadbFlags&mariadbClientCacheMetadata != 0 {
+				// kept for the executions whose columns MariaDB skips
+				stmt.columns = make([]mysqlField, columnCount)
+				_, err = mc.readColumnsInto(stmt.columns)
+			} else {
+				err = mc.skipColumns(int(columnCount))
+			}
+		}
+	}
+	if err == nil {
+		if mc.openStmts == nil {
+			mc.openStmts = make(map[uint32]*mysqlStmt)
 		}
+		mc.openStmts[stmt.id] = stmt
 	}
 
 	return stmt, err
@@ -200,11 +473,16 @@ func (mc *mysqlConn) interpolateParams(query string, args []driver.Value) (strin
 	if strings.Count(query, "?") != len(args) {
 		return "", driver.ErrSkip
 	}
+	// The DSN is checked for unsafe collations, but not the default
+	// collation of the server
+	if mc.cfg.ServerCollation && mc.unsafeCollation() {
+		return "", driver.ErrSkip
+	}
 
 	buf, err := mc.buf.takeCompleteBuffer()
 	if err != nil {
 		// can not take the buffer. Something must be wrong with the connection
-		errLog.Print(err)
+		mc.log(err)
 		return "", ErrInvalidConn
 	}
 	buf = buf[:0]
@@ -296,7 +574,7 @@ func (mc *mysqlConn) interpolateParams(query string, args []driver.Value) (strin
 
 func (mc *mysqlConn) Exec(query string, args []driver.Value) (driver.Result, error) {
 	if mc.closed.IsSet() {
-		errLog.Print(ErrInvalidConn)
+		mc.log(ErrInvalidConn)
 		return nil, driver.ErrBadConn
 	}
 	if len(args) != 0 {
@@ -310,45 +588,74 @@ func (mc *mysqlConn) Exec(query string, args []driver.Value) (driver.Result, err
 		}
 		query = prepared
 	}
+	if err := mc.checkQuerySize(len(query)); err != nil {
+		return nil, err
+	}
 	mc.affectedRows = 0
 	mc.insertId = 0
+	mc.warnings = 0
 
-	err := mc.exec(query)
+	returning, err := mc.execReturning(query)
 	if err == nil {
-		return &mysqlResult{
-			affectedRows: int64(mc.affectedRows),
-			insertId:     int64(mc.insertId),
-		}, err
+		return mc.newResult(returning), err
 	}
 	return nil, mc.markBadConn(err)
 }
 
+// newResult returns the result of the last statement, with the
+// auto_increment_increment of the session if it is known, see
+// mysqlResult.InsertIDs.
+func (mc *mysqlConn) newResult(returning *returningRows) *mysqlResult {
+	return &mysqlResult{
+		affectedRows: int64(mc.affectedRows),
+		insertId:     int64(mc.insertId),
+		returning:    returning,
+		increment:    mc.autoIncIncrement,
+	}
+}
+
 // Internal function to execute commands
 func (mc *mysqlConn) exec(query string) error {
+	_, err := mc.execReturning(query)
+	return err
+}
+
+// execReturning executes a command and returns the rows it returned, if they
+// are kept as requested by WithReturning.
+func (mc *mysqlConn) execReturning(query string) (*returningRows, error) {
 	// Send command
-	if err := mc.writeCommandPacketStr(comQuery, query); err != nil {
-		return mc.markBadConn(err)
+	if err := mc.writeQueryPacket(query); err != nil {
+		return nil, mc.markBadConn(err)
 	}
 
 	// Read Result
 	resLen, err := mc.readResultSetHeaderPacket()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if resLen > 0 {
+	var returning *returningRows
+	if resLen > 0 && mc.maxReturning > 0 {
+		rows := &textRows{mysqlRows{mc: mc}}
+		if rows.rs.columns, err = mc.readColumns(resLen); err != nil {
+			return nil, err
+		}
+		if returning, err = readReturning(rows, mc.maxReturning); err != nil {
+			return nil, err
+		}
+	} else if resLen > 0 {
 		// columns
-		if err := mc.readUntilEOF(); err != nil {
-			return err
+		if err := mc.skipColumns(resLen); err != nil {
+			return nil, err
 		}
 
 		// rows
 		if err := mc.readUntilEOF(); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return mc.discardResults()
+	return returning, mc.discardResults()
 }
 
 func (mc *mysqlConn) Query(query string, args []driver.Value) (driver.Rows, error) {
@@ -357,7 +664,7 @@ func (mc *mysqlConn) Query(query string, args []driver.Value) (driver.Rows, erro
 
 func (mc *mysqlConn) query(query string, args []driver.Value) (*textRows, error) {
 	if mc.closed.IsSet() {
-		errLog.Print(ErrInvalidConn)
+		mc.log(ErrInvalidConn)
 		return nil, driver.ErrBadConn
 	}
 	if len(args) != 0 {
@@ -371,9 +678,16 @@ func (mc *mysqlConn) query(query string, args []driver.Value) (*textRows, error)
 		}
 		query = prepared
 	}
+	if err := mc.checkQuerySize(len(query)); err != nil {
+		return nil, err
+	}
 	// Send command
-	err := mc.writeCommandPacketStr(comQuery, query)
+	err := mc.writeQueryPacket(query)
 	if err == nil {
+		mc.affectedRows = 0
+		mc.insertId = 0
+		mc.warnings = 0
+
 		// Read Result
 		var resLen int
 		resLen, err = mc.readResultSetHeaderPacket()
@@ -383,6 +697,10 @@ func (mc *mysqlConn) query(query string, args []driver.Value) (*textRows, error)
 
 			if resLen == 0 {
 				rows.rs.done = true
+				if mc.emptyResults {
+					rows.saveStatus(mc)
+					return rows, nil
+				}
 
 				switch err := rows.NextResultSet(); err {
 				case nil, io.EOF:
@@ -400,11 +718,82 @@ func (mc *mysqlConn) query(query string, args []driver.Value) (*textRows, error)
 	return nil, mc.markBadConn(err)
 }
 
+func (mc *mysqlConn) LocalAddr() net.Addr {
+	return mc.netConn.LocalAddr()
+}
+
+func (mc *mysqlConn) RemoteAddr() net.Addr {
+	return mc.netConn.RemoteAddr()
+}
+
+// tlsConn returns the TLS connection under the compressed protocol, if any.
+func (mc *mysqlConn) tlsConn() (*tls.Conn, bool) {
+	nc := mc.netConn
+	if cc, ok := nc.(*compressedConn); ok {
+		nc = cc.Conn
+	}
+	tc, ok := nc.(*tls.Conn)
+	return tc, ok
+}
+
+func (mc *mysqlConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	tc, ok := mc.tlsConn()
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tc.ConnectionState(), true
+}
+
+func (mc *mysqlConn) AuthInfo() AuthInfo {
+	_, tls := mc.tlsConn()
+	return AuthInfo{
+		Plugin:              mc.authPlugin,
+		TLS:                 tls,
+		Cleartext:           mc.cleartextAuth,
+		Capabilities:        uint32(mc.clientFlags),
+		MariaDBCapabilities: uint32(mc.mariadbFlags),
+	}
+}
+
+func (mc *mysqlConn) Collation() string {
+	if mc.collationID == 0 {
+		return mc.cfg.Collation
+	}
+	return mc.collation
+}
+
+// unsafeCollation reports whether escaping strings is unsafe in the collation
+// of the connection. Collations unknown to the driver are considered unsafe.
+func (mc *mysqlConn) unsafeCollation() bool {
+	collation := mc.Collation()
+	return collation == "" || unsafeCollations[collation]
+}
+
+func (mc *mysqlConn) EscapeString(s string) (string, error) {
+	if mc.unsafeCollation() {
+		return "", ErrUnsafeCollation
+	}
+	if mc.status&statusNoBackslashEscapes != 0 {
+		return string(escapeStringQuotes(nil, s)), nil
+	}
+	return string(escapeStringBackslash(nil, s)), nil
+}
+
+func (mc *mysqlConn) EscapeBytes(b []byte) ([]byte, error) {
+	if mc.unsafeCollation() {
+		return nil, ErrUnsafeCollation
+	}
+	if mc.status&statusNoBackslashEscapes != 0 {
+		return escapeBytesQuotes(nil, b), nil
+	}
+	return escapeBytesBackslash(nil, b), nil
+}
+
 // Gets the value of the given MySQL System Variable
 // The returned byte slice is only valid until the next read
 func (mc *mysqlConn) getSystemVar(name string) ([]byte, error) {
 	// Send command
-	if err := mc.writeCommandPacketStr(comQuery, "SELECT @@"+name); err != nil {
+	if err := mc.writeQueryPacket("SELECT @@" + name); err != nil {
 		return nil, err
 	}
 
@@ -417,41 +806,118 @@ func (mc *mysqlConn) getSystemVar(name string) ([]byte, error) {
 
 		if resLen > 0 {
 			// Columns
-			if err := mc.readUntilEOF(); err != nil {
+			if err := mc.skipColumns(resLen); err != nil {
 				return nil, err
 			}
 		}
 
 		dest := make([]driver.Value, resLen)
 		if err = rows.readRow(dest); err == nil {
-			return dest[0].([]byte), mc.readUntilEOF()
+			v, _ := dest[0].([]byte) // nil for NULL
+			return v, mc.readUntilEOF()
 		}
 	}
 	return nil, err
 }
 
+// queryValue returns the value of the first column of the first row of a
+// query of the driver.
+func (mc *mysqlConn) queryValue(ctx context.Context, query string) ([]byte, error) {
+	if err := mc.watchCancel(ctx); err != nil {
+		return nil, err
+	}
+	defer mc.finish()
+	rows, err := mc.query(query, nil)
+	if err != nil {
+		return nil, err
+	}
+	dest := make([]driver.Value, len(rows.Columns()))
+	err = rows.Next(dest)
+	if cerr := rows.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = ErrMalformPkt
+		}
+		return nil, err
+	}
+	v, _ := dest[0].([]byte)
+	return v, nil
+}
+
+// fetchMaxAllowedPacket queries max_allowed_packet from the server and uses it
+// as the packet size limit for the lifetime of the connection.
+func (mc *mysqlConn) fetchMaxAllowedPacket() error {
+	maxap, err := mc.getSystemVar("max_allowed_packet")
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(string(maxap))
+	if err != nil || n <= 1 {
+		return fmt.Errorf("invalid max_allowed_packet from server: %q", maxap)
+	}
+	mc.setMaxAllowedPacket(n - 1)
+	return nil
+}
+
+func (mc *mysqlConn) setMaxAllowedPacket(n int) {
+	mc.maxAllowedPacket = n
+	if n < maxPacketSize {
+		mc.maxWriteSize = n
+	} else {
+		mc.maxWriteSize = maxPacketSize - 1
+	}
+}
+
 // finish is called when the query has canceled.
 func (mc *mysqlConn) cancel(err error) {
 	mc.canceled.Set(err)
 	mc.cleanup()
 }
 
+// killQuery kills the query running on mc through a new connection, which
+// must be established and run KILL QUERY before ctx is done.
+func (mc *mysqlConn) killQuery(ctx context.Context) error {
+	if mc.connector == nil {
+		return ErrInvalidConn
+	}
+	kc, err := mc.connector.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer kc.Close()
+	if err := kc.watchCancel(ctx); err != nil {
+		return err
+	}
+	defer kc.finish()
+	return kc.exec("KILL QUERY " + strconv.FormatUint(uint64(mc.connectionID), 10))
+}
+
 // finish is called when the query has succeeded.
 func (mc *mysqlConn) finish() {
-	if !mc.watching || mc.finished == nil {
+	mc.allowInfile = false
+	mc.emptyResults = false
+	mc.maxReturning = 0
+	mc.queryAttrs = nil
+	if !mc.watching {
 		return
 	}
-	select {
-	case mc.finished <- struct{}{}:
+	if mc.stopWatch() ||
+		atomic.CompareAndSwapInt32(mc.watchState, watchIdle, watchFinished) ||
+		atomic.LoadInt32(mc.watchState) == watchCanceled {
+		// The connection is intact, even if the context has been canceled.
 		mc.watching = false
-	case <-mc.closech:
+		return
 	}
+	// The context has been canceled and the connection is being closed.
+	<-mc.closech
 }
 
 // Ping implements driver.Pinger interface
 func (mc *mysqlConn) Ping(ctx context.Context) (err error) {
 	if mc.closed.IsSet() {
-		errLog.Print(ErrInvalidConn)
+		mc.log(ErrInvalidConn)
 		return driver.ErrBadConn
 	}
 
@@ -492,22 +958,60 @@ func (mc *mysqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver
 	return mc.begin(opts.ReadOnly)
 }
 
+// rewriteQuery applies the QueryRewriter and the StatementPolicy of the
+// config to a query of the user.
+func (mc *mysqlConn) rewriteQuery(ctx context.Context, query string) (string, error) {
+	if mc.cfg.QueryRewriter != nil {
+		var err error
+		if query, err = mc.cfg.QueryRewriter(ctx, query); err != nil {
+			return "", err
+		}
+	}
+	if hints := optimizerHintsFromContext(ctx); hints != "" {
+		query = addOptimizerHints(query, hints, mc.sqlMode())
+	}
+	if err := mc.checkPolicy(ctx, query); err != nil {
+		return "", err
+	}
+	mc.invalidateSessionVars(query)
+	return query, nil
+}
+
 func (mc *mysqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
 	dargs, err := namedValueToValue(args)
 	if err != nil {
 		return nil, err
 	}
+	if query, err = mc.rewriteQuery(ctx, query); err != nil {
+		return nil, err
+	}
+	attrs, err := mc.queryAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := mc.watchCancel(ctx); err != nil {
 		return nil, err
 	}
+	mc.queryAttrs = attrs
+	mc.allowInfile = localInfileAllowed(ctx)
+	mc.parseTime = parseTimeFromContext(ctx, mc.cfg.ParseTime)
+	mc.emptyResults = emptyResultSetsWanted(ctx)
 
 	rows, err := mc.query(query, dargs)
 	if err != nil {
 		mc.finish()
 		return nil, err
 	}
+	if limit, ok := spoolLimitFromContext(ctx); ok && !rows.rs.done {
+		if err := mc.spoolRows(limit); err != nil {
+			mc.finish()
+			return nil, err
+		}
+	}
 	rows.finish = mc.finish
+	rows.maxRows = maxRowsFromContext(ctx)
+	rows.rowTimeout = rowTimeoutFromContext(ctx)
 	return rows, err
 }
 
@@ -516,16 +1020,32 @@ func (mc *mysqlConn) ExecContext(ctx context.Context, query string, args []drive
 	if err != nil {
 		return nil, err
 	}
+	if query, err = mc.rewriteQuery(ctx, query); err != nil {
+		return nil, err
+	}
+	attrs, err := mc.queryAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := mc.watchCancel(ctx); err != nil {
 		return nil, err
 	}
+	mc.queryAttrs = attrs
 	defer mc.finish()
+	mc.allowInfile = localInfileAllowed(ctx)
+	mc.parseTime = parseTimeFromContext(ctx, mc.cfg.ParseTime)
+	mc.maxReturning = maxReturningFromContext(ctx)
 
 	return mc.Exec(query, dargs)
 }
 
 func (mc *mysqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	query, err := mc.rewriteQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := mc.watchCancel(ctx); err != nil {
 		return nil, err
 	}
@@ -550,17 +1070,33 @@ func (stmt *mysqlStmt) QueryContext(ctx context.Context, args []driver.NamedValu
 	if err != nil {
 		return nil, err
 	}
+	attrs, err := stmt.mc.queryAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := stmt.mc.watchCancel(ctx); err != nil {
 		return nil, err
 	}
+	stmt.mc.queryAttrs = attrs
+	stmt.mc.allowInfile = localInfileAllowed(ctx)
+	stmt.mc.parseTime = parseTimeFromContext(ctx, stmt.mc.cfg.ParseTime)
+	stmt.mc.emptyResults = emptyResultSetsWanted(ctx)
 
 	rows, err := stmt.query(dargs)
 	if err != nil {
 		stmt.mc.finish()
 		return nil, err
 	}
+	if limit, ok := spoolLimitFromContext(ctx); ok && !rows.rs.done {
+		if err := stmt.mc.spoolRows(limit); err != nil {
+			stmt.mc.finish()
+			return nil, err
+		}
+	}
 	rows.finish = stmt.mc.finish
+	rows.maxRows = maxRowsFromContext(ctx)
+	rows.rowTimeout = rowTimeoutFromContext(ctx)
 	return rows, err
 }
 
@@ -569,15 +1105,32 @@ func (stmt *mysqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue
 	if err != nil {
 		return nil, err
 	}
+	attrs, err := stmt.mc.queryAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := stmt.mc.watchCancel(ctx); err != nil {
 		return nil, err
 	}
+	stmt.mc.queryAttrs = attrs
 	defer stmt.mc.finish()
+	stmt.mc.allowInfile = localInfileAllowed(ctx)
+	stmt.mc.parseTime = parseTimeFromContext(ctx, stmt.mc.cfg.ParseTime)
+	stmt.mc.maxReturning = maxReturningFromContext(ctx)
 
 	return stmt.Exec(dargs)
 }
 
+// States of a watched command. They tell whether the connection has to be
+// closed when the context is canceled.
+const (
+	watchIdle     int32 = iota // no packet has been read or written yet
+	watchStarted               // packets have been exchanged
+	watchCanceled              // canceled before any packet was exchanged
+	watchFinished              // finished before any packet was exchanged
+)
+
 func (mc *mysqlConn) watchCancel(ctx context.Context) error {
 	if mc.watching {
 		// Reach here if canceled,
@@ -593,39 +1146,43 @@ func (mc *mysqlConn) watchCancel(ctx context.Context) error {
 	if ctx.Done() == nil {
 		return nil
 	}
-	// When watcher is not alive, can't watch it.
-	if mc.watcher == nil {
-		return nil
-	}
 
+	// Each command gets its own state, so a late callback of a previous
+	// command can't affect it.
+	state := new(int32)
 	mc.watching = true
-	mc.watcher <- ctx
+	mc.watchCtx = ctx
+	mc.watchState = state
+	mc.stopWatch = mc.afterFunc(ctx, func() {
+		// Nothing has been sent yet, so the connection is still in sync
+		// and doesn't need to be closed.
+		if atomic.CompareAndSwapInt32(state, watchIdle, watchCanceled) {
+			return
+		}
+		if atomic.LoadInt32(state) == watchStarted {
+			mc.cancel(ctx.Err())
+		}
+	})
 	return nil
 }
 
-func (mc *mysqlConn) startWatcher() {
-	watcher := make(chan context.Context, 1)
-	mc.watcher = watcher
-	finished := make(chan struct{})
-	mc.finished = finished
-	go func() {
-		for {
-			var ctx context.Context
-			select {
-			case ctx = <-watcher:
-			case <-mc.closech:
-				return
-			}
+// commandContext returns the context of the running command.
+func (mc *mysqlConn) commandContext() context.Context {
+	if mc.watching {
+		return mc.watchCtx
+	}
+	return context.Background()
+}
 
-			select {
-			case <-ctx.Done():
-				mc.cancel(ctx.Err())
-			case <-finished:
-			case <-mc.closech:
-				return
-			}
-		}
-	}()
+// startIO is called before a packet is read or written. It returns the error
+// of the context if it was canceled before the first packet of the command.
+func (mc *mysqlConn) startIO() error {
+	if !mc.watching ||
+		atomic.CompareAndSwapInt32(mc.watchState, watchIdle, watchStarted) ||
+		atomic.LoadInt32(mc.watchState) != watchCanceled {
+		return nil
+	}
+	return mc.watchCtx.Err()
 }
 
 func (mc *mysqlConn) CheckNamedValue(nv *driver.NamedValue) (err error) {
@@ -639,12 +1196,84 @@ func (mc *mysqlConn) ResetSession(ctx context.Context) error {
 	if mc.closed.IsSet() {
 		return driver.ErrBadConn
 	}
-	mc.reset = true
+
+	// Perform a stale connection check. database/sql resets a connection
+	// when it is checked out of the pool, so a connection closed by the
+	// server or a firewall while it was idle is detected before any
+	// command is sent and database/sql retries with another connection.
+	if mc.cfg.CheckConnLiveness {
+		conn := mc.netConn
+		if mc.rawConn != nil {
+			conn = mc.rawConn
+		}
+		var err error
+		// If this connection has a ReadTimeout which we've been setting on
+		// reads, reset it to its default value before we attempt a
+		// non-blocking read, otherwise the scheduler will just time us out
+		// before we can read
+		if mc.cfg.ReadTimeout != 0 {
+			err = conn.SetReadDeadline(time.Time{})
+		}
+		if err == nil {
+			err = connCheck(conn)
+		}
+		if err != nil {
+			mc.log("closing bad idle connection: ", err)
+			return driver.ErrBadConn
+		}
+	}
+
+	// A transaction started with a BEGIN statement instead of BeginTx was
+	// left open when the connection was returned to the pool. Roll it back,
+	// so it doesn't hold its locks while the connection is reused.
+	if mc.status&statusInTrans != 0 {
+		mc.log("rolling back transaction left open on connection ", mc.connectionID)
+		if err := mc.watchCancel(ctx); err != nil {
+			return driver.ErrBadConn
+		}
+		err := mc.exec("ROLLBACK")
+		mc.finish()
+		if err != nil {
+			mc.log("closing connection, rollback failed: ", err)
+			mc.Close()
+			return driver.ErrBadConn
+		}
+	}
+
+	// Switch back to the user of the DSN, so the next user of the connection
+	// doesn't run with the privileges of the previous one.
+	if mc.dsnCfg != nil {
+		if err := mc.restoreUser(ctx); err != nil {
+			mc.log("closing connection, could not restore the user: ", err)
+			mc.Close()
+			return driver.ErrBadConn
+		}
+	}
+
+	// Restore multiStatements of the DSN, which SetMultiStatements may have
+	// changed for the previous user of the connection.
+	var multi clientFlag
+	if mc.cfg.MultiStatements {
+		multi = mc.flags & clientMultiStatements
+	}
+	if mc.clientFlags&clientMultiStatements != multi {
+		if err := mc.SetMultiStatements(ctx, multi != 0); err != nil {
+			mc.log("closing connection, could not restore multiStatements: ", err)
+			mc.Close()
+			return driver.ErrBadConn
+		}
+	}
 	return nil
 }
 
 // IsValid implements driver.Validator interface
 // (From Go 1.15)
 func (mc *mysqlConn) IsValid() bool {
-	return !mc.closed.IsSet()
+	if mc.closed.IsSet() {
+		return false
+	}
+	// database/sql calls IsValid before putting the connection back into the
+	// pool, so the buffers can be shared with other connections meanwhile.
+	mc.buf.release()
+	return true
 }
@@ -9,12 +9,20 @@
 package mysql
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestInterpolateParams(t *testing.T) {
@@ -135,7 +143,6 @@ func TestCleanCancel(t *testing.T) {
 	mc := &mysqlConn{
 		closech: make(chan struct{}),
 	}
-	mc.startWatcher()
 	defer mc.cleanup()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -201,3 +208,960 @@ func (bc badConnection) Write(b []byte) (n int, err error) {
 func (bc badConnection) Close() error {
 	return nil
 }
+
+func TestFetchMaxAllowedPacket(t *testing.T) {
+	resultSet := func(value string) []byte {
+		return append([]byte{
+			// column count
+			0x01, 0x00, 0x00, 0x01, 0x01,
+			// column definition (skipped)
+			0x03, 0x00, 0x00, 0x02, 0x03, 'd', 'e',
+			// EOF
+			0x05, 0x00, 0x00, 0x03, 0xfe, 0x00, 0x00, 0x02, 0x00,
+			// row
+			byte(len(value) + 1), 0x00, 0x00, 0x04, byte(len(value))},
+			append([]byte(value),
+				// EOF
+				0x05, 0x00, 0x00, 0x05, 0xfe, 0x00, 0x00, 0x02, 0x00)...)
+	}
+
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{resultSet("67108864")}
+	if err := mc.fetchMaxAllowedPacket(); err != nil {
+		t.Fatal(err)
+	}
+	if mc.maxAllowedPacket != 67108863 {
+		t.Errorf("expected maxAllowedPacket %d, got %d", 67108863, mc.maxAllowedPacket)
+	}
+	if mc.maxWriteSize != maxPacketSize-1 {
+		t.Errorf("expected maxWriteSize %d, got %d", maxPacketSize-1, mc.maxWriteSize)
+	}
+
+	// packets larger than the server limit are rejected client side
+	mc.setMaxAllowedPacket(1023)
+	if err := mc.writePacket(make([]byte, 4+1024)); err != ErrPktTooLarge {
+		t.Errorf("expected ErrPktTooLarge, got %v", err)
+	}
+
+	conn, mc = newRWMockConn(0)
+	conn.queuedReplies = [][]byte{resultSet("abc")}
+	if err := mc.fetchMaxAllowedPacket(); err == nil {
+		t.Error("expected an error for an invalid max_allowed_packet")
+	}
+}
+
+func TestWatchCancel(t *testing.T) {
+	// finished before the context is canceled
+	mc := &mysqlConn{closech: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := mc.watchCancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mc.finish()
+	cancel()
+	if mc.watching {
+		t.Error("expected watching is false, but true")
+	}
+	if mc.closed.IsSet() {
+		t.Error("expected mc is not closed, closed actually")
+	}
+
+	// canceled before any packet is sent
+	mc = &mysqlConn{closech: make(chan struct{})}
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := mc.watchCancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(mc.watchState) == watchIdle && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := mc.startIO(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %#v", err)
+	}
+	mc.finish()
+	if mc.watching {
+		t.Error("expected watching is false, but true")
+	}
+	if mc.closed.IsSet() {
+		t.Error("expected mc is not closed, closed actually")
+	}
+
+	// canceled while the command is running
+	mc = &mysqlConn{closech: make(chan struct{})}
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := mc.watchCancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.startIO(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case <-mc.closech:
+	case <-time.After(time.Second):
+		t.Fatal("connection is not closed after cancel")
+	}
+	mc.finish()
+	if err := mc.error(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %#v", err)
+	}
+}
+
+func TestCloseAwaitsServer(t *testing.T) {
+	client, server := net.Pipe()
+	mc := &mysqlConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		cfg:              NewConfig(),
+		closech:          make(chan struct{}),
+		maxAllowedPacket: defaultMaxAllowedPacket,
+	}
+	mc.cfg.CloseTimeout = time.Second
+
+	quit := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 5)
+		n, _ := server.Read(buf)
+		quit <- buf[:n]
+		// send some trailing data, then close after a short delay
+		server.Write([]byte{0x01, 0x02, 0x03})
+		time.Sleep(50 * time.Millisecond)
+		server.Close()
+	}()
+
+	start := time.Now()
+	if err := mc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if q := <-quit; !bytes.Equal(q, []byte{0x01, 0x00, 0x00, 0x00, comQuit}) {
+		t.Errorf("expected COM_QUIT, got %x", q)
+	}
+	if elapsed < 50*time.Millisecond || elapsed >= time.Second {
+		t.Errorf("expected Close to wait for the server, took %v", elapsed)
+	}
+
+	// the server never closes the connection
+	client, server = net.Pipe()
+	defer server.Close()
+	mc = &mysqlConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		cfg:              NewConfig(),
+		closech:          make(chan struct{}),
+		maxAllowedPacket: defaultMaxAllowedPacket,
+	}
+	mc.cfg.CloseTimeout = 50 * time.Millisecond
+	go server.Read(make([]byte, 5))
+
+	start = time.Now()
+	if err := mc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed >= time.Second {
+		t.Errorf("expected Close to return after CloseTimeout, took %v", elapsed)
+	}
+}
+
+// textResultSet returns the response to COM_QUERY with a result set of a
+// single VARCHAR column v holding the given values.
+func textResultSet(values ...string) []byte {
+	rows := make([][]interface{}, len(values))
+	for i, v := range values {
+		rows[i] = []interface{}{v}
+	}
+	return textResultSetColumns([]string{"v"}, rows...)
+}
+
+func TestQueryContextMaxRows(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{textResultSet("a", "b", "c")}
+	ctx := WithMaxRows(context.Background(), 2)
+	rows, err := mc.QueryContext(ctx, "SELECT v FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := make([]driver.Value, 1)
+	for i := 0; i < 2; i++ {
+		if err := rows.Next(dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rows.Next(dest)
+	if merr, ok := err.(*MaxRowsError); !ok || merr.Max != 2 {
+		t.Fatalf("expected *MaxRowsError with Max 2, got %#v", err)
+	}
+
+	// without a connector, the query can't be killed
+	if err := rows.Close(); err != ErrInvalidConn {
+		t.Errorf("expected ErrInvalidConn, got %v", err)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestQueryContextMaxRowsKill(t *testing.T) {
+	killed := make(chan struct{})
+	RegisterDialContext("maxrowskilltest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			serveReplies(server, [][]byte{serverHandshake, serverAuthOK, {7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}})
+			close(killed)
+		}()
+		return client, nil
+	})
+
+	client, server := net.Pipe()
+	defer server.Close()
+	cfg := NewConfig()
+	cfg.Net = "maxrowskilltest"
+	mc := &mysqlConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		cfg:              cfg,
+		connector:        newConnector(cfg),
+		closech:          make(chan struct{}),
+		maxAllowedPacket: defaultMaxAllowedPacket,
+	}
+
+	// the server sends rows until the query is killed
+	go func() {
+		for seq := byte(0); ; seq++ {
+			packet := []byte{0x02, 0x00, 0x00, seq, 0x01, 'a'}
+			select {
+			case <-killed:
+				// ER_QUERY_INTERRUPTED
+				packet = []byte{0x09, 0x00, 0x00, seq, 0xff, 0x25, 0x05, '#', '7', '0', '1', '0', '0'}
+			default:
+			}
+			if _, err := server.Write(packet); err != nil || packet[4] == 0xff {
+				return
+			}
+		}
+	}()
+
+	rows := &textRows{mysqlRows{mc: mc, maxRows: 1, rs: resultSet{columns: []mysqlField{{}}}}}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rows.Next(dest).(*MaxRowsError); !ok {
+		t.Fatal("expected *MaxRowsError")
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if mc.closed.IsSet() {
+		t.Error("expected the connection to be kept")
+	}
+}
+
+func TestQueryContextRowTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	mc := &mysqlConn{
+		buf:              newBuffer(client),
+		cfg:              NewConfig(),
+		netConn:          client,
+		closech:          make(chan struct{}),
+		maxAllowedPacket: defaultMaxAllowedPacket,
+		maxWriteSize:     defaultMaxAllowedPacket,
+	}
+
+	// split the result set into its packets
+	var packets [][]byte
+	for reply := textResultSet("a", "b", "c"); len(reply) > 0; {
+		n := 4 + int(reply[0])
+		packets = append(packets, reply[:n])
+		reply = reply[n:]
+	}
+	go func() {
+		var head [4]byte
+		io.ReadFull(server, head[:])
+		io.CopyN(ioutil.Discard, server, int64(head[0]))
+		// columns and the first row
+		server.Write(bytes.Join(packets[:4], nil))
+		// each of the other rows arrives within the row timeout, though
+		// the rows take longer than it together
+		for _, pkt := range packets[4:6] {
+			time.Sleep(60 * time.Millisecond)
+			server.Write(pkt)
+		}
+		// never finish the result set
+		io.Copy(ioutil.Discard, server)
+	}()
+
+	ctx := WithRowTimeout(context.Background(), 100*time.Millisecond)
+	rows, err := mc.QueryContext(ctx, "SELECT v FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	for i := 0; i < 3; i++ {
+		if err := rows.Next(dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rows.Next(dest)
+	if terr, ok := err.(*RowTimeoutError); !ok || terr.Timeout != 100*time.Millisecond {
+		t.Fatalf("expected *RowTimeoutError, got %#v", err)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("expected mc is closed, not closed actually")
+	}
+}
+
+func TestQueryContextSpooling(t *testing.T) {
+	for _, limit := range []int64{1 << 20, 16} { // in memory, on disk
+		conn, mc := newRWMockConn(0)
+		values := []string{"a", strings.Repeat("b", 100), "c"}
+		// a second result set follows, which is read ahead with the first
+		reply := textResultSet(values...)
+		reply[len(reply)-2] |= byte(statusMoreResultsExists)
+		reply = append(reply, 0x07, 0x00, 0x00, byte(5+len(values)), iOK, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00)
+		okReply := []byte{0x07, 0x00, 0x00, 0x01, iOK, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}
+		conn.queuedReplies = [][]byte{reply, okReply}
+
+		ctx := WithSpooling(context.Background(), limit)
+		rows, err := mc.QueryContext(ctx, "SELECT v FROM t", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conn.data) != 0 {
+			t.Errorf("limit %d: expected the result set to be read from the network", limit)
+		}
+
+		dest := make([]driver.Value, 1)
+		for _, want := range values {
+			if err := rows.Next(dest); err != nil {
+				t.Fatalf("limit %d: %v", limit, err)
+			}
+			if got := string(dest[0].([]byte)); got != want {
+				t.Errorf("limit %d: expected %q, got %q", limit, want, got)
+			}
+		}
+		if err := rows.Next(dest); err != io.EOF {
+			t.Errorf("limit %d: expected io.EOF, got %v", limit, err)
+		}
+		// the data read ahead is still available
+		if err := rows.(driver.RowsNextResultSet).NextResultSet(); err != io.EOF {
+			t.Errorf("limit %d: expected io.EOF, got %v", limit, err)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := mc.ExecContext(context.Background(), "DO 1", nil); err != nil {
+			t.Fatalf("limit %d: %v", limit, err)
+		}
+		if mc.buf.nc != conn {
+			t.Errorf("limit %d: expected the spool to be consumed", limit)
+		}
+	}
+}
+
+func TestQueryContextSpoolingClose(t *testing.T) {
+	for _, closeConn := range []func(mc *mysqlConn){
+		func(mc *mysqlConn) { mc.Close() },
+		func(mc *mysqlConn) { mc.buf.nc.Close() },
+	} {
+		conn, mc := newRWMockConn(0)
+		conn.queuedReplies = [][]byte{textResultSet("a", strings.Repeat("b", 100), "c")}
+		rows, err := mc.QueryContext(WithSpooling(context.Background(), 16), "SELECT v FROM t", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, _ := mc.spool.Load().(*spoolWriter)
+		if w == nil || w.file == nil {
+			t.Fatal("expected the result set to be spooled to a file")
+		}
+
+		// the spooled rows are not read before the connection is closed
+		closeConn(mc)
+		if _, err := w.file.Stat(); err == nil {
+			t.Error("expected the spool file to be closed")
+		}
+		rows.Close()
+	}
+}
+
+func TestQueryEach(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{textResultSetColumns(
+		[]string{"id", "name"},
+		[]interface{}{"1", "foo"},
+		[]interface{}{"2", nil},
+		[]interface{}{"3", ""},
+	)}
+
+	var ids []int64
+	var names []string
+	err := mc.QueryEach(context.Background(), "SELECT id, name FROM t WHERE id > ?", []interface{}{0}, func(row RowView) error {
+		if row.NumColumns() != 2 || row.ColumnName(1) != "name" {
+			t.Fatalf("unexpected columns in %+v", row)
+		}
+		id, err := row.Int64(0)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		if row.IsNull(1) {
+			names = append(names, "NULL")
+		} else {
+			names = append(names, row.String(1))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" || fmt.Sprint(names) != "[foo NULL ]" {
+		t.Errorf("got ids %v and names %v", ids, names)
+	}
+	if !bytes.Contains(conn.written, []byte("WHERE id > 0")) {
+		t.Errorf("arguments not interpolated: %q", conn.written)
+	}
+	if mc.buf.length != 0 {
+		t.Errorf("unread data in the buffer: %d bytes", mc.buf.length)
+	}
+}
+
+func TestQueryBatches(t *testing.T) {
+	reply := textResultSetColumns(
+		[]string{"id", "name"},
+		[]interface{}{"1", "foo"},
+		[]interface{}{"2", nil},
+		[]interface{}{nil, "bar"},
+	)
+	// the first column is a BIGINT column
+	reply[bytes.IndexByte(reply, byte(fieldTypeVarString))] = byte(fieldTypeLongLong)
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{reply}
+
+	var batches []string
+	err := mc.QueryBatches(context.Background(), "SELECT id, name FROM t", nil, 2, func(b *Batch) error {
+		id, name := &b.Columns[0], &b.Columns[1]
+		if id.Kind != BatchInt64 || id.DatabaseTypeName != "BIGINT" || name.Kind != BatchBytes || name.Name != "name" {
+			t.Fatalf("unexpected columns %+v", b.Columns)
+		}
+		var rows []string
+		for i := 0; i < b.Len; i++ {
+			row := fmt.Sprintf("%d %v %s %v", id.Int64s[i], id.IsNull(i), name.Bytes(i), name.IsNull(i))
+			rows = append(rows, row)
+		}
+		batches = append(batches, strings.Join(rows, ", "))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"1 false foo false, 2 false  true", "0 true bar false"}
+	if fmt.Sprint(batches) != fmt.Sprint(expected) {
+		t.Errorf("expected batches %q, got %q", expected, batches)
+	}
+}
+
+func TestQueryEachCallbackError(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{textResultSet("a", "b", "c")}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := mc.QueryEach(context.Background(), "SELECT v FROM t", nil, func(row RowView) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Fatalf("expected errStop after 1 call, got %v after %d calls", err, calls)
+	}
+
+	// the remaining rows are discarded
+	if mc.buf.length != 0 {
+		t.Errorf("unread data in the buffer: %d bytes", mc.buf.length)
+	}
+}
+
+func TestQueryEachClosed(t *testing.T) {
+	for _, args := range [][]interface{}{nil, {1}} {
+		conn, mc := newRWMockConn(0)
+		mc.closed.Set(true)
+
+		err := mc.QueryEach(context.Background(), "SELECT v FROM t", args, func(row RowView) error {
+			t.Error("unexpected row")
+			return nil
+		})
+		if err != driver.ErrBadConn {
+			t.Errorf("args %v: expected driver.ErrBadConn, got %v", args, err)
+		}
+		if len(conn.written) != 0 {
+			t.Errorf("args %v: unexpected packets %q", args, conn.written)
+		}
+	}
+}
+
+func TestEscapeString(t *testing.T) {
+	mc := &mysqlConn{cfg: NewConfig()}
+	if s, err := mc.EscapeString("it's \\"); err != nil || s != "it\\'s \\\\" {
+		t.Errorf("got %q, %v", s, err)
+	}
+	if b, err := mc.EscapeBytes([]byte("a\x00b")); err != nil || string(b) != "a\\0b" {
+		t.Errorf("got %q, %v", b, err)
+	}
+
+	mc.status |= statusNoBackslashEscapes
+	if s, err := mc.EscapeString("it's \\"); err != nil || s != "it''s \\" {
+		t.Errorf("NO_BACKSLASH_ESCAPES: got %q, %v", s, err)
+	}
+
+	mc.cfg.Collation = "sjis_japanese_ci"
+	if _, err := mc.EscapeString("foo"); err != ErrUnsafeCollation {
+		t.Errorf("expected ErrUnsafeCollation, got %v", err)
+	}
+}
+
+func TestQueryRewriter(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.cfg.QueryRewriter = func(ctx context.Context, query string) (string, error) {
+		if strings.HasPrefix(query, "DROP") {
+			return "", errors.New("DROP is not allowed")
+		}
+		return "/* tenant=42 */ " + query, nil
+	}
+	conn.queuedReplies = [][]byte{textResultSet("a")}
+
+	rows, err := mc.QueryContext(context.Background(), "SELECT v FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if !bytes.Contains(conn.written, []byte("/* tenant=42 */ SELECT v FROM t")) {
+		t.Errorf("query was not rewritten: %q", conn.written)
+	}
+
+	conn.written = nil
+	if _, err := mc.ExecContext(context.Background(), "DROP TABLE t", nil); err == nil || err.Error() != "DROP is not allowed" {
+		t.Errorf("expected the error of the rewriter, got %v", err)
+	}
+	if len(conn.written) != 0 {
+		t.Errorf("the query was sent: %q", conn.written)
+	}
+}
+
+func TestMaxQuerySize(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.cfg.MaxQuerySize = 32
+	mc.cfg.InterpolateParams = true
+
+	checkErr := func(err error, size int) {
+		t.Helper()
+		if serr, ok := err.(*QuerySizeError); !ok || serr.Size != size || serr.Max != 32 {
+			t.Errorf("expected a *QuerySizeError of %d bytes, got %#v", size, err)
+		}
+	}
+
+	long := strings.Repeat("x", 32)
+	_, err := mc.Exec("SELECT ?", []driver.Value{long})
+	checkErr(err, len("SELECT '"+long+"'"))
+	_, err = mc.Query("SELECT '"+long+"'", nil)
+	checkErr(err, len("SELECT '"+long+"'"))
+	_, err = mc.Prepare("SELECT v FROM t WHERE v IN (?, ?, ?)")
+	checkErr(err, len("SELECT v FROM t WHERE v IN (?, ?, ?)"))
+
+	stmt := &mysqlStmt{mc: mc, paramCount: 2}
+	_, err = stmt.Exec([]driver.Value{[]byte(long), int64(1)})
+	checkErr(err, 40)
+	if len(conn.written) != 0 {
+		t.Fatalf("a statement exceeding the limit was sent: %q", conn.written)
+	}
+
+	// within the limit
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}}
+	if _, err = stmt.Exec([]driver.Value{long, nil}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnAddrAndTLSState(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	mc := &mysqlConn{netConn: client}
+	if mc.LocalAddr() != client.LocalAddr() || mc.RemoteAddr() != client.RemoteAddr() {
+		t.Errorf("unexpected addresses %v, %v", mc.LocalAddr(), mc.RemoteAddr())
+	}
+	if _, ok := mc.TLSConnectionState(); ok {
+		t.Error("expected no TLS state for a plain connection")
+	}
+
+	mc.netConn = tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	if _, ok := mc.TLSConnectionState(); !ok {
+		t.Error("expected the TLS state of a TLS connection")
+	}
+}
+
+func TestExecReturning(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	rows := textResultSetColumns([]string{"id", "name"}, []interface{}{"1", "foo"}, []interface{}{"2", nil}, []interface{}{"3", "bar"})
+	conn.queuedReplies = [][]byte{rows, rows}
+
+	// the rows are discarded by default
+	query := "INSERT INTO t (name) VALUES ('foo'), (NULL), ('bar') RETURNING id, name"
+	res, err := mc.Exec(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns, rows := res.(Result).Returning(); columns != nil || rows != nil {
+		t.Errorf("expected the rows to be discarded, got %v %v", columns, rows)
+	}
+
+	// up to 2 rows are kept
+	res, err = mc.ExecContext(WithReturning(context.Background(), 2), query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, returned := res.(Result).Returning()
+	if fmt.Sprint(columns) != "[id name]" {
+		t.Errorf("unexpected columns %v", columns)
+	}
+	if len(returned) != 2 || string(returned[0][0].([]byte)) != "1" || string(returned[0][1].([]byte)) != "foo" ||
+		string(returned[1][0].([]byte)) != "2" || returned[1][1] != nil {
+		t.Errorf("unexpected rows %q", returned)
+	}
+	if mc.maxReturning != 0 {
+		t.Errorf("WithReturning applies to later statements")
+	}
+
+	// statements without a result set
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, 0, 1, 3, 2, 0, 0, 0}}
+	res, err = mc.ExecContext(WithReturning(context.Background(), 2), "DELETE FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns, rows := res.(Result).Returning(); columns != nil || rows != nil {
+		t.Errorf("expected no rows, got %v %v", columns, rows)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Errorf("expected 1 affected row, got %d", n)
+	}
+}
+
+func TestExecInsertIDs(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{
+		{7, 0, 0, 1, 0, 3, 10, 2, 0, 0, 0},
+		textResultSet("2"),
+		{7, 0, 0, 1, 0, 3, 10, 2, 0, 0, 0},
+		{7, 0, 0, 1, 0, 2, 20, 2, 0, 0, 0},
+		{7, 0, 0, 1, 0, 1, 30, 2, 0, 0, 0},
+	}
+
+	// auto_increment_increment is not queried by Exec
+	res, err := mc.Exec("INSERT INTO t (name) VALUES ...", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.(Result).InsertIDs(); err != ErrUnknownAutoIncrementIncrement {
+		t.Errorf("expected ErrUnknownAutoIncrementIncrement, got %v", err)
+	}
+	if bytes.Contains(conn.written, []byte("auto_increment_increment")) {
+		t.Error("auto_increment_increment was queried by Exec")
+	}
+
+	// but is known once it was read with ServerVariable
+	if _, err := mc.ServerVariable(context.Background(), "auto_increment_increment"); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]int64{{10, 12, 14}, {20, 22}, {30}}
+	for _, want := range expected {
+		res, err := mc.Exec("INSERT INTO t (name) VALUES ...", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := res.(Result).InsertIDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("expected %v, got %v", want, ids)
+		}
+	}
+
+	// SET statements make it unknown again
+	mc.invalidateSessionVars("SET auto_increment_increment = 5")
+	if mc.autoIncIncrement != 0 {
+		t.Errorf("expected auto_increment_increment to be unknown, got %d", mc.autoIncIncrement)
+	}
+
+	// the session tracking of the server reports it
+	mc.clientFlags |= clientSessionTrack
+	var sysvar []byte
+	sysvar = appendLengthEncodedString(sysvar, "auto_increment_increment")
+	sysvar = appendLengthEncodedString(sysvar, "5")
+	state := appendLengthEncodedString([]byte{byte(SessionTrackSystemVariables)}, string(sysvar))
+	ok := appendLengthEncodedString([]byte{iOK, 0, 0, 0x02, 0x40, 0, 0, 0}, string(state))
+	if err := mc.handleOkPacket(ok); err != nil {
+		t.Fatal(err)
+	}
+	if mc.autoIncIncrement != 5 {
+		t.Errorf("expected the tracked auto_increment_increment, got %d", mc.autoIncIncrement)
+	}
+}
+
+func TestQueryCallStatus(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	resultSet := textResultSetColumns([]string{"id"}, []interface{}{"1"})
+	resultSet[len(resultSet)-2] |= byte(statusMoreResultsExists)
+	conn.queuedReplies = [][]byte{append(resultSet, 7, 0, 0, 6, 0, 3, 0, 2, 0, 1, 0)}
+
+	rows, err := mc.Query("CALL update_prices()", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Next(dest); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	rs := rows.(Rows)
+	if !rs.HasNextResultSet() {
+		t.Fatal("expected the final OK packet to follow")
+	}
+	if err := rs.NextResultSet(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	expected := ResultStatus{AffectedRows: 3, Warnings: 1}
+	if status := rs.Status(); status != expected {
+		t.Errorf("expected %+v, got %+v", expected, status)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryContextEmptyResultSets(t *testing.T) {
+	// UPDATE ...; SELECT v ...; INSERT ...
+	reply := []byte{7, 0, 0, 1, 0, 2, 0, byte(statusMoreResultsExists) | 2, 0, 0, 0}
+	seq := byte(1)
+	for rs := textResultSet("a"); len(rs) > 0; {
+		n := 4 + int(rs[0])
+		seq = rs[3] + 1
+		reply = append(reply, rs[:4]...)
+		reply[len(reply)-1] = seq
+		reply = append(reply, rs[4:n]...)
+		rs = rs[n:]
+	}
+	reply[len(reply)-2] |= byte(statusMoreResultsExists)
+	reply = append(reply, 7, 0, 0, seq+1, 0, 1, 5, 2, 0, 0, 0)
+
+	// by default, the result sets without columns are skipped
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{reply}
+	rows, err := mc.QueryContext(context.Background(), "UPDATE ...; SELECT v ...; INSERT ...", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns := rows.Columns(); len(columns) != 1 {
+		t.Errorf("expected the columns of the SELECT, got %v", columns)
+	}
+	if err := rows.(Rows).NextResultSet(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	rows.Close()
+
+	conn, mc = newRWMockConn(0)
+	conn.queuedReplies = [][]byte{reply}
+	ctx := WithEmptyResultSets(context.Background())
+	rows, err = mc.QueryContext(ctx, "UPDATE ...; SELECT v ...; INSERT ...", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := rows.(Rows)
+	dest := make([]driver.Value, 1)
+
+	// UPDATE
+	if columns := rs.Columns(); len(columns) != 0 {
+		t.Errorf("expected no columns, got %v", columns)
+	}
+	if err := rs.Next(dest); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if status := rs.Status(); status != (ResultStatus{AffectedRows: 2}) {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// SELECT
+	if err := rs.NextResultSet(); err != nil {
+		t.Fatal(err)
+	}
+	if columns := rs.Columns(); len(columns) != 1 || columns[0] != "v" {
+		t.Errorf("expected column v, got %v", columns)
+	}
+	if err := rs.Next(dest); err != nil || string(dest[0].([]byte)) != "a" {
+		t.Errorf("expected row a, got %q, %v", dest[0], err)
+	}
+
+	// INSERT
+	if err := rs.NextResultSet(); err != nil {
+		t.Fatal(err)
+	}
+	if columns := rs.Columns(); len(columns) != 0 {
+		t.Errorf("expected no columns, got %v", columns)
+	}
+	if status := rs.Status(); status != (ResultStatus{AffectedRows: 1, LastInsertID: 5}) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if err := rs.NextResultSet(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryContextParseTime(t *testing.T) {
+	reply := textResultSet("2021-01-02 03:04:05")
+	reply[bytes.IndexByte(reply, byte(fieldTypeVarString))] = byte(fieldTypeDateTime)
+
+	conn, mc := newRWMockConn(0)
+	mc.cfg.ParseTime = true
+	tests := []struct {
+		ctx      context.Context
+		expected string
+	}{
+		{WithParseTime(context.Background(), false), "[]uint8"},
+		{context.Background(), "time.Time"},
+	}
+	for _, tt := range tests {
+		conn.queuedReplies = [][]byte{reply}
+		rows, err := mc.QueryContext(tt.ctx, "SELECT created_at FROM t", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := make([]driver.Value, 1)
+		if err := rows.Next(dest); err != nil {
+			t.Fatal(err)
+		}
+		if typ := fmt.Sprintf("%T", dest[0]); typ != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, typ)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResetSessionRollsBackOpenTransaction(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.cfg.CheckConnLiveness = false
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}}
+
+	// no transaction, nothing is sent
+	if err := mc.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.written) != 0 {
+		t.Fatalf("unexpected command %q", conn.written)
+	}
+
+	mc.status = statusInTrans | statusInAutocommit
+	if err := mc.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(conn.written, []byte("ROLLBACK")) {
+		t.Errorf("expected ROLLBACK, got %q", conn.written)
+	}
+	if mc.status&statusInTrans != 0 {
+		t.Error("expected the transaction to be ended")
+	}
+
+	// the connection is discarded if the rollback fails
+	mc.status = statusInTrans
+	conn.maxWrites = 1
+	if err := mc.ResetSession(context.Background()); err != driver.ErrBadConn {
+		t.Errorf("expected driver.ErrBadConn, got %v", err)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestFormatRoles(t *testing.T) {
+	tests := []struct {
+		roles []string
+		want  string
+	}{
+		{[]string{"all"}, "ALL"},
+		{[]string{"DEFAULT"}, "DEFAULT"},
+		{[]string{"app_read"}, "`app_read`"},
+		{[]string{"app_read", "admin@localhost"}, "`app_read`,`admin`@`localhost`"},
+		{[]string{"all", "none"}, "`all`,`none`"},
+	}
+	for _, tt := range tests {
+		if got := formatRoles(tt.roles); got != tt.want {
+			t.Errorf("formatRoles(%q) = %s, want %s", tt.roles, got, tt.want)
+		}
+	}
+}
+
+func TestConnectSetsRoles(t *testing.T) {
+	queries := make(chan string, 1)
+	RegisterDialContext("rolestest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			readPacket := func() []byte {
+				var head [4]byte
+				if _, err := io.ReadFull(server, head[:]); err != nil {
+					return nil
+				}
+				data := make([]byte, int(uint32(head[0])|uint32(head[1])<<8|uint32(head[2])<<16))
+				if _, err := io.ReadFull(server, data); err != nil {
+					return nil
+				}
+				return data
+			}
+			server.Write(serverHandshake)
+			readPacket()
+			server.Write(serverAuthOK)
+			query := readPacket()
+			if len(query) > 0 {
+				queries <- string(query[1:])
+			}
+			if addr == "denied" {
+				// ER_ROLE_NOT_GRANTED
+				server.Write(append([]byte{30, 0, 0, 1, 0xff, 0xca, 0x0d}, "role `admin` is not granted"...))
+			} else {
+				server.Write([]byte{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0})
+			}
+			io.Copy(ioutil.Discard, server)
+		}()
+		return client, nil
+	})
+
+	cfg := NewConfig()
+	cfg.Net = "rolestest"
+	cfg.Addr = "ok"
+	cfg.Roles = []string{"app_read", "admin@localhost"}
+	conn, err := newConnector(cfg).Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got, want := <-queries, "SET ROLE `app_read`,`admin`@`localhost`"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	cfg.Addr = "denied"
+	_, err = newConnector(cfg).Connect(context.Background())
+	<-queries
+	if !errors.Is(err, &MySQLError{Number: 3530}) {
+		t.Errorf("expected ER_ROLE_NOT_GRANTED, got %v", err)
+	}
+}
@@ -12,15 +12,114 @@ import (
 	"context"
 	"database/sql/driver"
 	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The backoff suggested after the server refused connections because of its
+// connection limits doubles from minRefusedBackoff up to maxRefusedBackoff.
+const (
+	minRefusedBackoff = 100 * time.Millisecond
+	maxRefusedBackoff = 10 * time.Second
 )
 
 type connector struct {
-	cfg *Config // immutable private copy.
+	cfg               *Config         // immutable private copy.
+	encodedAttributes string          // Encoded connection attributes.
+	replicas          []*connector    // Connectors of cfg.Replicas.
+	dial              DialContextFunc // Dials instead of the network of cfg.Net.
+
+	mu       sync.Mutex
+	refusals int       // consecutive connections refused by the server
+	backoff  time.Time // end of the backoff after the last refusal
+}
+
+func newConnector(cfg *Config) *connector {
+	c := &connector{
+		cfg:               cfg,
+		encodedAttributes: encodeConnectionAttributes(cfg),
+	}
+	for _, addr := range cfg.Replicas {
+		rcfg := cfg.Clone()
+		rcfg.Addr = addr
+		rcfg.Replicas = nil
+		if cfg.tls != nil {
+			// select the TLS config and ServerName for the replica, which
+			// normalize has checked already
+			rcfg.tls = nil
+			rcfg.normalize()
+		}
+		c.replicas = append(c.replicas, newConnector(rcfg))
+	}
+	return c
+}
+
+// encodeConnectionAttributes encodes the attributes sent with the handshake
+// response as a sequence of length encoded key-value strings.
+// https://dev.mysql.com/doc/refman/8.0/en/performance-schema-connection-attribute-tables.html
+func encodeConnectionAttributes(cfg *Config) string {
+	var buf []byte
+
+	// default connection attributes
+	buf = appendLengthEncodedString(buf, connAttrClientName)
+	buf = appendLengthEncodedString(buf, connAttrClientNameValue)
+	buf = appendLengthEncodedString(buf, connAttrOS)
+	buf = appendLengthEncodedString(buf, runtime.GOOS)
+	buf = appendLengthEncodedString(buf, connAttrPlatform)
+	buf = appendLengthEncodedString(buf, runtime.GOARCH)
+	buf = appendLengthEncodedString(buf, connAttrPid)
+	buf = appendLengthEncodedString(buf, strconv.Itoa(os.Getpid()))
+	if host, _, err := net.SplitHostPort(cfg.Addr); err == nil {
+		buf = appendLengthEncodedString(buf, connAttrServerHost)
+		buf = appendLengthEncodedString(buf, host)
+	}
+
+	// user defined connection attributes and the labels, which the
+	// attributes override, sorted for a stable encoding
+	attrs := make(map[string]string, len(cfg.Labels)+len(cfg.ConnectionAttributes))
+	for k, v := range cfg.Labels {
+		attrs[k] = v
+	}
+	for k, v := range cfg.ConnectionAttributes {
+		attrs[k] = v
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf = appendLengthEncodedString(buf, k)
+		buf = appendLengthEncodedString(buf, attrs[k])
+	}
+
+	return string(buf)
 }
 
 // Connect implements driver.Connector interface.
 // Connect returns a connection to the database.
 func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	mc, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// normalize rejects TxReplay with replicas
+	if len(c.replicas) > 0 {
+		return &replicaConn{mysqlConn: mc}, nil
+	}
+	if c.cfg.TxReplay {
+		return &txReplayConn{mysqlConn: mc}, nil
+	}
+	return mc, nil
+}
+
+// connect returns a connection to the server of c.cfg.
+func (c *connector) connect(ctx context.Context) (*mysqlConn, error) {
 	var err error
 
 	// New mysqlConn
@@ -29,14 +128,31 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 		maxWriteSize:     maxPacketSize - 1,
 		closech:          make(chan struct{}),
 		cfg:              c.cfg,
+		connector:        c,
+	}
+	if c.cfg.PasswordFunc != nil {
+		// the password of this connection, e.g. a short-lived token
+		passwd, err := c.cfg.PasswordFunc(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mc.cfg = c.cfg.Clone()
+		mc.cfg.Passwd = passwd
 	}
 	mc.parseTime = mc.cfg.ParseTime
+	mc.maxReadPacket = mc.cfg.MaxReadPacket
+	if mc.maxReadPacket <= 0 {
+		mc.maxReadPacket = defaultMaxReadPacket
+	}
 
 	// Connect to Server
-	dialsLock.RLock()
-	dial, ok := dials[mc.cfg.Net]
-	dialsLock.RUnlock()
-	if ok {
+	dial := c.dial
+	if dial == nil {
+		dialsLock.RLock()
+		dial = dials[mc.cfg.Net]
+		dialsLock.RUnlock()
+	}
+	if dial != nil {
 		dctx := ctx
 		if mc.cfg.Timeout > 0 {
 			var cancel context.CancelFunc
@@ -45,8 +161,7 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 		}
 		mc.netConn, err = dial(dctx, mc.cfg.Addr)
 	} else {
-		nd := net.Dialer{Timeout: mc.cfg.Timeout}
-		mc.netConn, err = nd.DialContext(ctx, mc.cfg.Net, mc.cfg.Addr)
+		mc.netConn, err = dialNet(ctx, mc.cfg)
 	}
 
 	if err != nil {
@@ -55,7 +170,12 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 
 	// Enable TCP Keepalives on TCP connections
 	if tc, ok := mc.netConn.(*net.TCPConn); ok {
-		if err := tc.SetKeepAlive(true); err != nil {
+		err := tc.SetKeepAlive(true)
+		if err == nil && !mc.cfg.TCPNoDelay {
+			// Go enables TCP_NODELAY by default
+			err = tc.SetNoDelay(false)
+		}
+		if err != nil {
 			// Don't send COM_QUIT before handshake.
 			mc.netConn.Close()
 			mc.netConn = nil
@@ -63,11 +183,20 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 		}
 	}
 
-	// Call startWatcher for context support (From Go 1.8)
-	mc.startWatcher()
-	if err := mc.watchCancel(ctx); err != nil {
+	// From here on cleanup must be called to release the connection
+	atomic.AddInt64(&driverStats.connections, 1)
+
+	// The auth timeout covers the handshake up to the result of the
+	// authentication, the context of the caller the whole connect.
+	actx := ctx
+	if mc.cfg.AuthTimeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, mc.cfg.AuthTimeout)
+		defer cancel()
+	}
+	if err := mc.watchCancel(actx); err != nil {
 		mc.cleanup()
-		return nil, err
+		return nil, mc.authTimeoutError(ctx, actx, err)
 	}
 	defer mc.finish()
 
@@ -81,18 +210,24 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 	authData, plugin, err := mc.readHandshakePacket()
 	if err != nil {
 		mc.cleanup()
-		return nil, err
+		return nil, c.refused(mc.authTimeoutError(ctx, actx, err))
 	}
+	mc.scramble = authData
 
 	if plugin == "" {
 		plugin = defaultAuthPlugin
 	}
+	if mc.cfg.FIPSMode && !fipsAuthPlugins[plugin] {
+		// The server sends an auth switch request if the account requires
+		// another plugin, which is then rejected by mc.auth.
+		plugin = fipsAuthPlugin
+	}
 
 	// Send Client Authentication Packet
 	authResp, err := mc.auth(authData, plugin)
 	if err != nil {
 		// try the default auth plugin, if using the requested plugin failed
-		errLog.Print("could not use requested auth plugin '"+plugin+"': ", err.Error())
+		mc.log("could not use requested auth plugin '"+plugin+"': ", err.Error())
 		plugin = defaultAuthPlugin
 		authResp, err = mc.auth(authData, plugin)
 		if err != nil {
@@ -102,7 +237,7 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 	}
 	if err = mc.writeHandshakeResponsePacket(authResp, plugin); err != nil {
 		mc.cleanup()
-		return nil, err
+		return nil, mc.authTimeoutError(ctx, actx, err)
 	}
 
 	// Handle response to auth packet, switch methods if possible
@@ -111,22 +246,36 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 		// (https://dev.mysql.com/doc/internals/en/authentication-fails.html).
 		// Do not send COM_QUIT, just cleanup and return the error.
 		mc.cleanup()
-		return nil, err
+		return nil, c.refused(mc.authTimeoutError(ctx, actx, err))
 	}
+	c.accepted()
 
-	if mc.cfg.MaxAllowedPacket > 0 {
-		mc.maxAllowedPacket = mc.cfg.MaxAllowedPacket
-	} else {
-		// Get max allowed packet size
-		maxap, err := mc.getSystemVar("max_allowed_packet")
-		if err != nil {
-			mc.Close()
+	if mc.clientFlags&(clientCompress|clientZstdCompressionAlgorithm) != 0 {
+		mc.startCompression()
+	}
+
+	if actx != ctx {
+		// Watch the context of the caller for the rest of the setup.
+		mc.finish()
+		if mc.closed.IsSet() {
+			return nil, mc.authTimeoutError(ctx, actx, ErrInvalidConn)
+		}
+		if err := mc.watchCancel(ctx); err != nil {
+			mc.cleanup()
 			return nil, err
 		}
-		mc.maxAllowedPacket = stringToInt(maxap) - 1
 	}
-	if mc.maxAllowedPacket < maxPacketSize {
-		mc.maxWriteSize = mc.maxAllowedPacket
+
+	if mc.cfg.MaxAllowedPacket > 0 {
+		mc.setMaxAllowedPacket(mc.cfg.MaxAllowedPacket)
+	} else if err = mc.fetchMaxAllowedPacket(); err != nil {
+		mc.Close()
+		return nil, err
+	}
+
+	if err = mc.setRoles(); err != nil {
+		mc.Close()
+		return nil, err
 	}
 
 	// Handle DSN Params
@@ -139,6 +288,58 @@ func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
 	return mc, nil
 }
 
+// authTimeoutError returns err as a *ConnectTimeoutError if actx, the
+// context of the auth phase, expired before ctx of the caller did.
+func (mc *mysqlConn) authTimeoutError(ctx, actx context.Context, err error) error {
+	if actx == ctx || actx.Err() == nil || ctx.Err() != nil {
+		return err
+	}
+	if _, ok := err.(*ConnectTimeoutError); ok {
+		return err
+	}
+	return &ConnectTimeoutError{Phase: "auth", Timeout: mc.cfg.AuthTimeout, Err: actx.Err(), Labels: mc.Labels()}
+}
+
+// refused returns err as a *TooManyConnectionsError if the server refused the
+// connection because of its connection limits, and extends the backoff.
+func (c *connector) refused(err error) error {
+	merr, ok := err.(*MySQLError)
+	if !ok {
+		return err
+	}
+	switch merr.Number {
+	case 1040, 1203, 1226: // ER_CON_COUNT_ERROR, ER_TOO_MANY_USER_CONNECTIONS, ER_USER_LIMIT_REACHED
+	default:
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	backoff := maxRefusedBackoff
+	if c.refusals < 7 {
+		backoff = minRefusedBackoff << uint(c.refusals)
+	}
+	c.refusals++
+	c.backoff = time.Now().Add(backoff)
+	return &TooManyConnectionsError{Addr: c.cfg.Addr, Backoff: backoff, Err: merr, Labels: c.cfg.Labels}
+}
+
+// accepted resets the backoff after the server accepted a connection.
+func (c *connector) accepted() {
+	c.mu.Lock()
+	c.refusals = 0
+	c.backoff = time.Time{}
+	c.mu.Unlock()
+}
+
+// backingOff reports whether the server refused the last connection and the
+// suggested backoff did not pass yet.
+func (c *connector) backingOff() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Before(c.backoff)
+}
+
 // Driver implements driver.Connector interface.
 // Driver returns &MySQLDriver{}.
 func (c *connector) Driver() driver.Driver {
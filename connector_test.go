@@ -2,17 +2,21 @@ package mysql
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"testing"
 	"time"
 )
 
 func TestConnectorReturnsTimeout(t *testing.T) {
-	connector := &connector{&Config{
+	connector := newConnector(&Config{
 		Net:     "tcp",
 		Addr:    "1.1.1.1:1234",
 		Timeout: 10 * time.Millisecond,
-	}}
+	})
 
 	_, err := connector.Connect(context.Background())
 	if err == nil {
@@ -28,3 +32,306 @@ func TestConnectorReturnsTimeout(t *testing.T) {
 		t.Fatalf("expected %T, got %T", nerr, err)
 	}
 }
+
+func TestConnectorStatsNoLeak(t *testing.T) {
+	RegisterDialContext("statstest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			// reject the connection with an error packet instead of a handshake
+			msg := "Host is not allowed to connect"
+			pkt := []byte{byte(9 + len(msg)), 0x00, 0x00, 0x00, 0xff, 0x6a, 0x04, '#', 'H', 'Y', '0', '0', '0'}
+			server.Write(append(pkt, msg...))
+		}()
+		return client, nil
+	})
+
+	before := Stats()
+	cfg := NewConfig()
+	cfg.Net = "statstest"
+	cfg.Addr = "localhost"
+	if _, err := newConnector(cfg).Connect(context.Background()); err == nil {
+		t.Fatal("error expected")
+	}
+
+	// the watcher goroutine exits asynchronously
+	deadline := time.Now().Add(time.Second)
+	for Stats() != before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := Stats(); after != before {
+		t.Errorf("leaked resources: before %+v, after %+v", before, after)
+	}
+}
+
+func TestConnectorTimeoutPhases(t *testing.T) {
+	// advertise TLS support in the capability flags
+	tlsHandshake := append([]byte(nil), serverHandshake...)
+	tlsHandshake[25] |= byte(clientSSL >> 8)
+	RegisterDialContext("phasetest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		switch addr {
+		case "silent":
+			// accept the connection, but never send the handshake
+			go io.Copy(ioutil.Discard, server)
+		case "tls":
+			// never answer the TLS client hello
+			go serveReplies(server, [][]byte{tlsHandshake})
+		}
+		return client, nil
+	})
+
+	tests := []struct {
+		addr  string
+		phase string
+		cfg   func(cfg *Config)
+	}{
+		{"silent", "auth", func(cfg *Config) {
+			cfg.AuthTimeout = 50 * time.Millisecond
+		}},
+		{"tls", "tls", func(cfg *Config) {
+			cfg.TLSConfig = "skip-verify"
+			cfg.TLSTimeout = 50 * time.Millisecond
+			cfg.AuthTimeout = 10 * time.Second
+		}},
+		{"tls", "auth", func(cfg *Config) {
+			cfg.TLSConfig = "skip-verify"
+			cfg.AuthTimeout = 50 * time.Millisecond
+		}},
+	}
+	for _, tt := range tests {
+		cfg := NewConfig()
+		cfg.Net = "phasetest"
+		cfg.Addr = tt.addr
+		tt.cfg(cfg)
+		if err := cfg.normalize(); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := newConnector(cfg).Connect(ctx)
+		cancel()
+		var terr *ConnectTimeoutError
+		if !errors.As(err, &terr) {
+			t.Errorf("%s: expected *ConnectTimeoutError, got %T: %v", tt.addr, err, err)
+			continue
+		}
+		if terr.Phase != tt.phase {
+			t.Errorf("%s: expected phase %s, got %s", tt.addr, tt.phase, terr.Phase)
+		}
+	}
+
+	// the context of the caller is reported as it is
+	cfg := NewConfig()
+	cfg.Net = "phasetest"
+	cfg.Addr = "silent"
+	cfg.AuthTimeout = 10 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := newConnector(cfg).Connect(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %T: %v", context.DeadlineExceeded, err, err)
+	}
+}
+
+func TestConnectorServerCollation(t *testing.T) {
+	sent := make(chan byte, 1)
+	RegisterDialContext("collationtest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			server.Write(serverHandshake)
+			var head [4]byte
+			if _, err := io.ReadFull(server, head[:]); err != nil {
+				return
+			}
+			payload := make([]byte, int(head[0])|int(head[1])<<8|int(head[2])<<16)
+			if _, err := io.ReadFull(server, payload); err != nil {
+				return
+			}
+			sent <- payload[8] // collation
+			server.Write(serverAuthOK)
+			io.Copy(ioutil.Discard, server)
+		}()
+		return client, nil
+	})
+
+	for _, serverCollation := range []bool{false, true} {
+		cfg := NewConfig()
+		cfg.Net = "collationtest"
+		cfg.ServerCollation = serverCollation
+		conn, err := newConnector(cfg).Connect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the handshake of the server reports utf8_general_ci
+		expected := "utf8_general_ci"
+		if !serverCollation {
+			expected = defaultCollation
+		}
+		if id := <-sent; id != collations[expected] {
+			t.Errorf("serverCollation=%v: sent collation %d, expected %d", serverCollation, id, collations[expected])
+		}
+		if collation := conn.(Conn).Collation(); collation != expected {
+			t.Errorf("serverCollation=%v: expected collation %s, got %s", serverCollation, expected, collation)
+		}
+		conn.Close()
+	}
+}
+
+func TestConnectorAuthInfo(t *testing.T) {
+	authSwitch := append([]byte{22, 0, 0, 2, 0xfe}, "mysql_clear_password\x00"...)
+	scripts := map[string][][]byte{
+		"native": {serverHandshake, serverAuthOK},
+		"switch": {serverHandshake, authSwitch, {7, 0, 0, 4, 0, 0, 0, 2, 0, 0, 0}},
+	}
+	RegisterDialContext("authinfotest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveReplies(server, scripts[addr])
+		return client, nil
+	})
+
+	tests := []struct {
+		addr   string
+		plugin string
+		err    error
+	}{
+		{"native", "mysql_native_password", nil},
+		// the password is not sent in clear text without TLS
+		{"switch", "mysql_clear_password", ErrInsecureCleartext},
+	}
+	for _, tt := range tests {
+		cfg := NewConfig()
+		cfg.Net = "authinfotest"
+		cfg.Addr = tt.addr
+		cfg.Passwd = "secret"
+		cfg.AllowCleartextPasswords = true
+		conn, err := newConnector(cfg).Connect(context.Background())
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: expected %v, got %v", tt.addr, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := conn.(Conn).AuthInfo()
+		if info.Plugin != tt.plugin || info.Cleartext || info.TLS {
+			t.Errorf("%s: unexpected auth info %+v", tt.addr, info)
+		}
+		if clientFlag(info.Capabilities)&clientProtocol41 == 0 || clientFlag(info.Capabilities)&clientSSL != 0 {
+			t.Errorf("%s: unexpected capabilities %x", tt.addr, info.Capabilities)
+		}
+		conn.Close()
+	}
+}
+
+func TestConnectorTooManyConnections(t *testing.T) {
+	// ER_CON_COUNT_ERROR, sent instead of the handshake
+	refusal := append([]byte{23, 0, 0, 0, 0xff, 0x10, 0x04}, "Too many connections"...)
+	RegisterDialContext("refusaltest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		if addr == "full" {
+			go serveReplies(server, [][]byte{refusal})
+		} else {
+			go serveReplies(server, [][]byte{serverHandshake, serverAuthOK})
+		}
+		return client, nil
+	})
+
+	cfg := NewConfig()
+	cfg.Net = "refusaltest"
+	cfg.Addr = "full"
+	c := newConnector(cfg)
+	for _, backoff := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		_, err := c.Connect(context.Background())
+		var terr *TooManyConnectionsError
+		if !errors.As(err, &terr) {
+			t.Fatalf("expected *TooManyConnectionsError, got %T: %v", err, err)
+		}
+		if terr.Backoff != backoff || terr.Addr != "full" {
+			t.Errorf("unexpected error %+v", terr)
+		}
+		if !errors.Is(err, &MySQLError{Number: 1040}) {
+			t.Errorf("expected the error to wrap ER_CON_COUNT_ERROR, got %v", err)
+		}
+		if !c.backingOff() {
+			t.Error("expected the connector to back off")
+		}
+	}
+
+	// an accepted connection resets the backoff
+	c.cfg.Addr = "ok"
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if c.backingOff() || c.refusals != 0 {
+		t.Error("expected the backoff to be reset")
+	}
+}
+
+func TestConnectorPasswordFunc(t *testing.T) {
+	RegisterDialContext("passwordfunctest", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveReplies(server, [][]byte{serverHandshake, serverAuthOK})
+		return client, nil
+	})
+
+	var calls int
+	errToken := errors.New("no token")
+	cfg := NewConfig()
+	cfg.Net = "passwordfunctest"
+	cfg.Addr = "localhost"
+	cfg.PasswordFunc = func(ctx context.Context) (string, error) {
+		calls++
+		if calls > 2 {
+			return "", errToken
+		}
+		return fmt.Sprintf("token%d", calls), nil
+	}
+	c := newConnector(cfg)
+	for i := 1; i <= 2; i++ {
+		conn, err := c.Connect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passwd := conn.(*mysqlConn).cfg.Passwd; passwd != fmt.Sprintf("token%d", i) {
+			t.Errorf("connection %d: unexpected password %q", i, passwd)
+		}
+		conn.Close()
+	}
+	if cfg.Passwd != "" {
+		t.Errorf("the password of the config was changed to %q", cfg.Passwd)
+	}
+	if _, err := c.Connect(context.Background()); err != errToken {
+		t.Errorf("expected the error of PasswordFunc, got %v", err)
+	}
+}
+
+func TestNewConnectorWithDialer(t *testing.T) {
+	var dialed []string
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		client, server := net.Pipe()
+		go serveReplies(server, [][]byte{serverHandshake, serverAuthOK})
+		return client, nil
+	}
+
+	cfg := NewConfig()
+	cfg.Net = "cloudsql"
+	cfg.Addr = "project:region:instance"
+	c, err := NewConnectorWithDialer(cfg, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if len(dialed) != 1 || dialed[0] != "project:region:instance" {
+		t.Errorf("unexpected dialed addresses %q", dialed)
+	}
+}
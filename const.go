@@ -11,11 +11,23 @@ package mysql
 const (
 	defaultAuthPlugin       = "mysql_native_password"
 	defaultMaxAllowedPacket = 4 << 20 // 4 MiB
+	defaultMaxReadPacket    = 1 << 30 // 1 GiB, the largest max_allowed_packet of the server
 	minProtocolVersion      = 10
 	maxPacketSize           = 1<<24 - 1
 	timeFormat              = "2006-01-02 15:04:05.999999"
 )
 
+// Connection attributes
+// See https://dev.mysql.com/doc/refman/8.0/en/performance-schema-connection-attribute-tables.html#performance-schema-connection-attributes-available
+const (
+	connAttrClientName      = "_client_name"
+	connAttrClientNameValue = "Go-MySQL-Driver"
+	connAttrOS              = "_os"
+	connAttrPlatform        = "_platform"
+	connAttrPid             = "_pid"
+	connAttrServerHost      = "_server_host"
+)
+
 // MySQL constants documentation:
 // http://dev.mysql.com/doc/internals/en/client-server-protocol.html
 
@@ -56,8 +68,28 @@ const (
 	clientCanHandleExpiredPasswords
 	clientSessionTrack
 	clientDeprecateEOF
+	clientOptionalResultsetMetadata
+	clientZstdCompressionAlgorithm
+	clientQueryAttributes
 )
 
+// MariaDB extended capability flags, which are exchanged in reserved bytes of
+// the handshake if the server does not set clientLongPassword (CLIENT_MYSQL).
+// https://mariadb.com/kb/en/connection/#capabilities
+type mariadbFlag uint32
+
+const (
+	mariadbClientProgress mariadbFlag = 1 << iota
+	mariadbClientComMulti
+	mariadbClientStmtBulkOperations
+	mariadbClientExtendedTypeInfo
+	mariadbClientCacheMetadata
+)
+
+// mariadbClientFlags are the MariaDB extended capabilities the driver
+// supports.
+const mariadbClientFlags = mariadbClientCacheMetadata
+
 const (
 	comQuit byte = iota + 1
 	comInitDB
@@ -89,6 +121,12 @@ const (
 	comStmtFetch
 )
 
+// options of COM_SET_OPTION
+const (
+	optionMultiStatementsOn uint16 = iota
+	optionMultiStatementsOff
+)
+
 // https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-Protocol::ColumnType
 type fieldType byte
 
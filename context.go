@@ -0,0 +1,198 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type maxRowsKey struct{}
+
+// WithMaxRows returns a copy of ctx which limits the number of rows a result
+// set of a query run with it may return. If a result set has more than n
+// rows, Rows.Next fails with a *MaxRowsError. Closing the rows kills the
+// query with KILL QUERY through a separate connection, so the server stops
+// sending the remaining rows; if it can't be killed, the connection is closed.
+// A value of n <= 0 means no limit.
+//
+//  ctx := mysql.WithMaxRows(context.Background(), 10000)
+//  rows, err := db.QueryContext(ctx, "SELECT * FROM big_table")
+func WithMaxRows(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, maxRowsKey{}, n)
+}
+
+func maxRowsFromContext(ctx context.Context) int64 {
+	n, _ := ctx.Value(maxRowsKey{}).(int64)
+	return n
+}
+
+type rowTimeoutKey struct{}
+
+// WithRowTimeout returns a copy of ctx which limits the time Rows.Next of the
+// queries run with it waits for the next row. Unlike a deadline of ctx, which
+// limits the whole query, it is applied to each call of Next, so a query which
+// keeps producing rows slowly is not interrupted. If a row does not arrive in
+// time, Next fails with a *RowTimeoutError and the connection is closed.
+// A value of d <= 0 means no timeout.
+//
+//  ctx := mysql.WithRowTimeout(context.Background(), 30*time.Second)
+//  rows, err := db.QueryContext(ctx, "SELECT * FROM slow_view")
+func WithRowTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, rowTimeoutKey{}, d)
+}
+
+func rowTimeoutFromContext(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(rowTimeoutKey{}).(time.Duration)
+	return d
+}
+
+type spoolKey struct{}
+
+// WithSpooling returns a copy of ctx which makes queries run with it read
+// the whole result set from the server before returning the rows. Up to
+// memoryLimit bytes of the result set are kept in memory, the rest is
+// written to a temporary file, from which the rows are then read.
+// This frees the server and the network from slow consumers of large result
+// sets, e.g. for exports, without holding them in memory.
+func WithSpooling(ctx context.Context, memoryLimit int64) context.Context {
+	return context.WithValue(ctx, spoolKey{}, memoryLimit)
+}
+
+func spoolLimitFromContext(ctx context.Context) (int64, bool) {
+	n, ok := ctx.Value(spoolKey{}).(int64)
+	return n, ok
+}
+
+type shardKey struct{}
+
+// WithShardKey returns a copy of ctx which carries the shard key for a
+// connector returned by NewShardedConnector.
+func WithShardKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, shardKey{}, key)
+}
+
+// ShardKeyFromContext returns the shard key added to ctx by WithShardKey.
+func ShardKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(shardKey{}).(string)
+	return key, ok
+}
+
+type optimizerHintsKey struct{}
+
+// WithOptimizerHints returns a copy of ctx which adds optimizer hints to the
+// SELECT, INSERT, REPLACE, UPDATE and DELETE statements run with it. The
+// hints are inserted after the first keyword of the statement, so they can
+// be applied by middleware without changing the queries. Hints added to a
+// context which already has some are appended to them.
+//
+//  ctx := mysql.WithOptimizerHints(ctx, "/*+ MAX_EXECUTION_TIME(500) JOIN_ORDER(a, b) */")
+//  rows, err := db.QueryContext(ctx, "SELECT * FROM a JOIN b USING (id)")
+//
+// The enclosing /*+ */ may be omitted.
+func WithOptimizerHints(ctx context.Context, hints string) context.Context {
+	hints = strings.TrimSpace(hints)
+	hints = strings.TrimPrefix(hints, "/*+")
+	hints = strings.TrimSuffix(hints, "*/")
+	hints = strings.TrimSpace(hints)
+	if hints == "" {
+		return ctx
+	}
+	if prev := optimizerHintsFromContext(ctx); prev != "" {
+		hints = prev + " " + hints
+	}
+	return context.WithValue(ctx, optimizerHintsKey{}, hints)
+}
+
+func optimizerHintsFromContext(ctx context.Context) string {
+	hints, _ := ctx.Value(optimizerHintsKey{}).(string)
+	return hints
+}
+
+type localInfileKey struct{}
+
+// AllowLocalInfile returns a copy of ctx which allows the statements run with
+// it to send any local file requested by LOAD DATA LOCAL INFILE, like the
+// allowAllFiles DSN parameter does for all statements. Other statements can
+// only send the files and readers registered with RegisterLocalFile and
+// RegisterReaderHandler, so a misbehaving server can't read arbitrary files.
+//
+//  ctx := mysql.AllowLocalInfile(ctx)
+//  _, err := db.ExecContext(ctx, "LOAD DATA LOCAL INFILE '/tmp/import.csv' INTO TABLE foo")
+func AllowLocalInfile(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localInfileKey{}, true)
+}
+
+func localInfileAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(localInfileKey{}).(bool)
+	return allowed
+}
+
+type emptyResultSetsKey struct{}
+
+// WithEmptyResultSets returns a copy of ctx which makes the queries run with
+// it yield every result set, including those of statements which return no
+// rows. By default, Rows.NextResultSet skips them, so the result sets of a
+// multi statement query or a stored procedure can't be matched with their
+// statements. The result sets without columns have no rows; their affected
+// rows and last insert ID are available from Rows.Status through
+// sql.Conn.Raw.
+//
+//  ctx := mysql.WithEmptyResultSets(ctx)
+//  rows, err := db.QueryContext(ctx, "UPDATE foo SET bar = 1; SELECT * FROM foo")
+func WithEmptyResultSets(ctx context.Context) context.Context {
+	return context.WithValue(ctx, emptyResultSetsKey{}, true)
+}
+
+func emptyResultSetsWanted(ctx context.Context) bool {
+	wanted, _ := ctx.Value(emptyResultSetsKey{}).(bool)
+	return wanted
+}
+
+type returningKey struct{}
+
+// WithReturning returns a copy of ctx which makes Exec keep up to maxRows rows
+// of a result set returned by the statement run with it, like INSERT ...
+// RETURNING and DELETE ... RETURNING of MariaDB, so they are available from
+// Result.Returning. Further rows are discarded. By default, or with
+// maxRows <= 0, all rows returned to Exec are discarded.
+//
+//  ctx := mysql.WithReturning(ctx, 1000)
+//  res, err := driverConn.(driver.ExecerContext).ExecContext(ctx, "DELETE FROM t RETURNING id", nil)
+func WithReturning(ctx context.Context, maxRows int64) context.Context {
+	return context.WithValue(ctx, returningKey{}, maxRows)
+}
+
+func maxReturningFromContext(ctx context.Context) int64 {
+	n, _ := ctx.Value(returningKey{}).(int64)
+	return n
+}
+
+type parseTimeKey struct{}
+
+// WithParseTime returns a copy of ctx which overrides the parseTime parameter
+// of the DSN for the statements run with it. With parse set to false, DATE
+// and DATETIME values are returned as []byte in the format of the server,
+// e.g. for an audit export through a pool configured with parseTime=true;
+// with parse set to true, they are returned as time.Time.
+//
+//  ctx := mysql.WithParseTime(ctx, false)
+//  rows, err := db.QueryContext(ctx, "SELECT created_at FROM audit_log")
+func WithParseTime(ctx context.Context, parse bool) context.Context {
+	return context.WithValue(ctx, parseTimeKey{}, parse)
+}
+
+// parseTimeFromContext returns the value set by WithParseTime, or def.
+func parseTimeFromContext(ctx context.Context, def bool) bool {
+	if parse, ok := ctx.Value(parseTimeKey{}).(bool); ok {
+		return parse
+	}
+	return def
+}
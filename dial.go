@@ -0,0 +1,24 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !js && !wasip1
+// +build !js,!wasip1
+
+package mysql
+
+import (
+	"context"
+	"net"
+)
+
+// dialNet connects to the address of cfg on a network without a registered
+// dial function.
+func dialNet(ctx context.Context, cfg *Config) (net.Conn, error) {
+	nd := net.Dialer{Timeout: cfg.Timeout}
+	return nd.DialContext(ctx, cfg.Net, cfg.Addr)
+}
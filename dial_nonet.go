@@ -0,0 +1,26 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build js || wasip1
+// +build js wasip1
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// dialNet fails, as WebAssembly runtimes have no sockets. The MySQL protocol
+// must be carried by a transport of the host, e.g. a WebSocket, which is
+// registered with RegisterDialContext.
+func dialNet(ctx context.Context, cfg *Config) (net.Conn, error) {
+	return nil, fmt.Errorf("network '%s' can not be dialed on %s/%s; register a transport with RegisterDialContext", cfg.Net, runtime.GOOS, runtime.GOARCH)
+}
@@ -74,9 +74,7 @@ func (d MySQLDriver) Open(dsn string) (driver.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	c := &connector{
-		cfg: cfg,
-	}
+	c := newConnector(cfg)
 	return c.Connect(context.Background())
 }
 
@@ -92,7 +90,28 @@ func NewConnector(cfg *Config) (driver.Connector, error) {
 	if err := cfg.normalize(); err != nil {
 		return nil, err
 	}
-	return &connector{cfg: cfg}, nil
+	return newConnector(cfg), nil
+}
+
+// NewConnectorWithDialer returns a driver.Connector like NewConnector, whose
+// connections are established by dial instead of the network of cfg.Net,
+// e.g. the dialer of a Cloud SQL connector which sets up ephemeral
+// certificates. This needs no network registered with RegisterDialContext.
+//
+// dial is called with cfg.Addr, or the address of a replica. Set cfg.Net to
+// a name describing the dialer, e.g. "cloudsql", to pass the address as it
+// is; addresses of "tcp" get the default port.
+func NewConnectorWithDialer(cfg *Config, dial DialContextFunc) (driver.Connector, error) {
+	cfg = cfg.Clone()
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	c := newConnector(cfg)
+	c.dial = dial
+	for _, r := range c.replicas {
+		r.dial = dial
+	}
+	return c, nil
 }
 
 // OpenConnector implements driver.DriverContext.
@@ -101,7 +120,5 @@ func (d MySQLDriver) OpenConnector(dsn string) (driver.Connector, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &connector{
-		cfg: cfg,
-	}, nil
+	return newConnector(cfg), nil
 }
@@ -80,6 +80,9 @@ func init() {
 		available = true
 		c.Close()
 	}
+	if dir := os.Getenv("MYSQL_TEST_CAPTURE"); dir != "" {
+		registerCaptureDial(prot, dir)
+	}
 }
 
 type DBTest struct {
@@ -10,6 +10,7 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"crypto/tls"
 	"errors"
@@ -43,13 +44,76 @@ type Config struct {
 	Collation        string            // Connection collation
 	Loc              *time.Location    // Location for time.Time values
 	MaxAllowedPacket int               // Max packet size allowed
+	MaxReadPacket    int               // Max size of a packet read from the server
+	MaxQuerySize     int               // Max size of a query or the parameters of a statement
 	ServerPubKey     string            // Server public key name
 	pubKey           *rsa.PublicKey    // Server public key
 	TLSConfig        string            // TLS configuration name
+	TLS              *tls.Config       // TLS configuration, takes precedence over TLSConfig
 	tls              *tls.Config       // TLS configuration
 	Timeout          time.Duration     // Dial timeout
+	TLSTimeout       time.Duration     // TLS handshake timeout
+	AuthTimeout      time.Duration     // Handshake and authentication timeout
 	ReadTimeout      time.Duration     // I/O read timeout
 	WriteTimeout     time.Duration     // I/O write timeout
+	CloseTimeout     time.Duration     // Wait for the server to close the connection on Close
+	DrainTimeout     time.Duration     // Kill the query if draining unread rows takes longer
+	Replicas         []string          // Addresses of replicas for read-only transactions
+	ReplicaGTIDWait  time.Duration     // Wait for replicas to catch up with the primary
+	Roles            []string          // Roles activated with SET ROLE after connecting
+
+	// ConnectionAttributes are sent to the server with the default
+	// attributes like _client_name and _os, e.g. to identify the pod or the
+	// service in performance_schema.session_connect_attrs.
+	ConnectionAttributes map[string]string
+
+	// Labels identify the pool of the connections, e.g. the service, the
+	// tenant or the name of the pool, in processes with many pools. They
+	// are sent as connection attributes, prefix the log messages of the
+	// connections, are available as Conn.Labels for metrics and are set in
+	// the errors describing failures of the connection.
+	Labels map[string]string
+
+	// QueryRewriter is called with every query before it is sent by Query,
+	// Exec and Prepare, e.g. to add hints or routing comments. The query may
+	// contain placeholders, which must be kept. Queries sent by the driver
+	// itself are not rewritten.
+	QueryRewriter func(ctx context.Context, query string) (string, error)
+
+	// StatementPolicy is called with every query of Query, Exec and Prepare
+	// after the QueryRewriter. It may reject the query before it is sent.
+	StatementPolicy StatementPolicy
+
+	// PasswordFunc returns the password of each new connection instead of
+	// Passwd, e.g. a fresh AWS RDS IAM auth token, which expires after 15
+	// minutes.
+	PasswordFunc func(ctx context.Context) (string, error)
+
+	// FIDOAuthenticator gets the assertion of the FIDO device for accounts
+	// identified with authentication_fido, e.g. after prompting the user to
+	// touch the device.
+	FIDOAuthenticator FIDOAuthenticator
+
+	// SessionStateChanged is called with the changes of the session state
+	// the server reports, e.g. of system variables changed by SET
+	// statements or of the default schema. The server reports the changes
+	// selected by session_track_system_variables, session_track_schema,
+	// session_track_state_change, session_track_gtids and
+	// session_track_transaction_info. It is called while the reply is
+	// read, and must not use the connection.
+	SessionStateChanged func(change SessionStateChange)
+
+	// CompressionAlgorithm is the algorithm of the compressed protocol if
+	// Compress is set, "zlib" (the default) or "zstd". zstd requires a codec
+	// registered with RegisterZstd and falls back to zlib if the server
+	// does not support it. ZstdLevel is the level of zstd from 1 to 22, 0
+	// for the default of 3, ZlibLevel the level of zlib from 1 to 9, 0 for
+	// the default of 6. Packets smaller than MinCompressLength bytes are
+	// sent uncompressed, 0 for the default of 50.
+	CompressionAlgorithm string
+	ZstdLevel            int
+	ZlibLevel            int
+	MinCompressLength    int
 
 	AllowAllFiles           bool // Allow all files to be used with LOAD DATA LOCAL INFILE
 	AllowCleartextPasswords bool // Allows the cleartext client side plugin
@@ -58,10 +122,17 @@ type Config struct {
 	CheckConnLiveness       bool // Check connections for liveness before using them
 	ClientFoundRows         bool // Return number of matching rows instead of rows changed
 	ColumnsWithAlias        bool // Prepend table alias to column names
+	Compress                bool // Compress the traffic with the zlib compressed protocol
+	DecimalAsFloat          bool // Decode DECIMAL values to float64, losing precision
+	FIPSMode                bool // Restrict the driver to FIPS-approved cryptography
 	InterpolateParams       bool // Interpolate placeholders into query string
 	MultiStatements         bool // Allow multiple statements in one query
 	ParseTime               bool // Parse time values to time.Time
 	RejectReadOnly          bool // Reject read-only connections
+	ServerCollation         bool // Use the default collation of the server instead of Collation
+	StmtStackTraces         bool // Record where statements are prepared, to report leaked ones
+	TCPNoDelay              bool // Disable Nagle's algorithm on TCP connections
+	TxReplay                bool // Replay transactions on a new connection after a failover (experimental)
 }
 
 // NewConfig creates a new Config and sets default values.
@@ -72,14 +143,36 @@ func NewConfig() *Config {
 		MaxAllowedPacket:     defaultMaxAllowedPacket,
 		AllowNativePasswords: true,
 		CheckConnLiveness:    true,
+		TCPNoDelay:           true,
 	}
 }
 
 func (cfg *Config) Clone() *Config {
 	cp := *cfg
+	if cp.TLS != nil {
+		cp.TLS = cfg.TLS.Clone()
+	}
 	if cp.tls != nil {
 		cp.tls = cfg.tls.Clone()
 	}
+	if len(cp.Replicas) > 0 {
+		cp.Replicas = append([]string(nil), cfg.Replicas...)
+	}
+	if len(cp.Roles) > 0 {
+		cp.Roles = append([]string(nil), cfg.Roles...)
+	}
+	if len(cp.ConnectionAttributes) > 0 {
+		cp.ConnectionAttributes = make(map[string]string, len(cfg.ConnectionAttributes))
+		for k, v := range cfg.ConnectionAttributes {
+			cp.ConnectionAttributes[k] = v
+		}
+	}
+	if len(cp.Labels) > 0 {
+		cp.Labels = make(map[string]string, len(cfg.Labels))
+		for k, v := range cfg.Labels {
+			cp.Labels[k] = v
+		}
+	}
 	if len(cp.Params) > 0 {
 		cp.Params = make(map[string]string, len(cfg.Params))
 		for k, v := range cfg.Params {
@@ -118,18 +211,50 @@ func (cfg *Config) normalize() error {
 	} else if cfg.Net == "tcp" {
 		cfg.Addr = ensureHavePort(cfg.Addr)
 	}
-
-	switch cfg.TLSConfig {
-	case "false", "":
-		// don't set anything
-	case "true":
-		cfg.tls = &tls.Config{}
-	case "skip-verify", "preferred":
-		cfg.tls = &tls.Config{InsecureSkipVerify: true}
+	switch cfg.CompressionAlgorithm {
+	case "", "zlib", "zstd":
 	default:
-		cfg.tls = getTLSConfigClone(cfg.TLSConfig)
-		if cfg.tls == nil {
-			return errors.New("invalid value / unknown config name: " + cfg.TLSConfig)
+		return errors.New("unknown compressionAlgorithm: " + cfg.CompressionAlgorithm)
+	}
+	if cfg.ZstdLevel < 0 || cfg.ZstdLevel > 22 {
+		return errors.New("zstdLevel must be between 1 and 22")
+	}
+	if cfg.ZlibLevel < 0 || cfg.ZlibLevel > 9 {
+		return errors.New("zlibLevel must be between 1 and 9")
+	}
+	if cfg.MinCompressLength < 0 {
+		return errors.New("minCompressLength must not be negative")
+	}
+	if cfg.TxReplay && len(cfg.Replicas) > 0 {
+		return errors.New("txReplay can not be used with replicas")
+	}
+	if cfg.Net == "tcp" {
+		for i, addr := range cfg.Replicas {
+			cfg.Replicas[i] = ensureHavePort(addr)
+		}
+	}
+
+	if tlsConfig, err := cfg.tlsConfigFor(cfg.Addr); err != nil {
+		return err
+	} else if tlsConfig != nil {
+		cfg.tls = tlsConfig
+	}
+	// the replicas may use configs registered for their hosts
+	for _, addr := range cfg.Replicas {
+		if _, err := cfg.tlsConfigFor(addr); err != nil {
+			return err
+		}
+	}
+
+	if cfg.tls != nil {
+		if err := checkTLSCertificates(cfg.tls); err != nil {
+			return err
+		}
+	}
+
+	if cfg.FIPSMode {
+		if err := cfg.normalizeFIPS(); err != nil {
+			return err
 		}
 	}
 
@@ -150,6 +275,96 @@ func (cfg *Config) normalize() error {
 	return nil
 }
 
+// tlsConfigFor returns a copy of the TLS config for connections to addr,
+// or nil if TLS is not used.
+func (cfg *Config) tlsConfigFor(addr string) (*tls.Config, error) {
+	if cfg.TLS != nil {
+		return cfg.TLS.Clone(), nil
+	}
+	switch cfg.TLSConfig {
+	case "false", "":
+		return nil, nil
+	case "true":
+		return &tls.Config{}, nil
+	case "skip-verify", "preferred":
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	case "verify-ca":
+		return &tls.Config{InsecureSkipVerify: true, VerifyPeerCertificate: verifyCertificateChain(nil)}, nil
+	case "verify-full":
+		return &tls.Config{}, nil
+	}
+	tlsConfig := getTLSConfigClone(cfg.TLSConfig, addr)
+	if tlsConfig == nil {
+		return nil, errors.New("invalid value / unknown config name: " + cfg.TLSConfig)
+	}
+	return tlsConfig, nil
+}
+
+// dsnParams are the names of the DSN parameters which are not system
+// variables, for the checks of ParseDSNStrict.
+var dsnParams = []string{
+	"allowAllFiles", "allowCleartextPasswords", "allowNativePasswords", "allowOldPasswords",
+	"authTimeout", "charset", "checkConnLiveness", "clientFoundRows", "closeTimeout", "collation",
+	"columnsWithAlias", "compress", "compressionAlgorithm", "connectionAttributes", "decimalAsFloat", "drainTimeout", "fipsMode",
+	"interpolateParams", "labels", "loc", "maxAllowedPacket", "maxQuerySize", "maxReadPacket",
+	"minCompressLength", "multiStatements", "parseTime", "readTimeout", "rejectReadOnly", "replicaGTIDWait",
+	"replicas", "roles", "serverCollation", "serverPubKey", "stmtStackTraces", "strict", "tcpNoDelay", "timeout", "tls",
+	"tlsTimeout", "txReplay", "writeTimeout", "zlibLevel", "zstdLevel",
+}
+
+// checkDSNParam rejects a parameter which is most likely a mistake.
+func checkDSNParam(param []string, seen map[string]bool) error {
+	name := param[0]
+	if len(param) != 2 {
+		if name == "" {
+			return nil // e.g. a trailing '&'
+		}
+		return fmt.Errorf("invalid DSN parameter %q: missing value", name)
+	}
+	if seen[name] {
+		return fmt.Errorf("invalid DSN parameter %s: given more than once", name)
+	}
+	seen[name] = true
+	for _, known := range dsnParams {
+		if name != known && strings.EqualFold(name, known) {
+			return fmt.Errorf("unknown DSN parameter %s, did you mean %s?", name, known)
+		}
+	}
+	return nil
+}
+
+// formatConnectionAttributes formats the attributes as a comma separated list
+// of key:value pairs, sorted by key.
+func formatConnectionAttributes(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + ":" + attrs[k]
+	}
+	return strings.Join(keys, ",")
+}
+
+// parseKeyValues parses the value of the DSN parameter name, a list of
+// key:value pairs, as formatted by formatConnectionAttributes.
+func parseKeyValues(name, value string) (map[string]string, error) {
+	value, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %v", name, err)
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid value for %s: %s", name, pair)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
 func writeDSNParam(buf *bytes.Buffer, hasParam *bool, name, value string) {
 	buf.Grow(1 + len(name) + 1 + len(value))
 	if !*hasParam {
@@ -212,6 +427,10 @@ func (cfg *Config) FormatDSN() string {
 		writeDSNParam(&buf, &hasParam, "allowOldPasswords", "true")
 	}
 
+	if cfg.AuthTimeout > 0 {
+		writeDSNParam(&buf, &hasParam, "authTimeout", cfg.AuthTimeout.String())
+	}
+
 	if !cfg.CheckConnLiveness {
 		writeDSNParam(&buf, &hasParam, "checkConnLiveness", "false")
 	}
@@ -220,6 +439,10 @@ func (cfg *Config) FormatDSN() string {
 		writeDSNParam(&buf, &hasParam, "clientFoundRows", "true")
 	}
 
+	if cfg.CloseTimeout > 0 {
+		writeDSNParam(&buf, &hasParam, "closeTimeout", cfg.CloseTimeout.String())
+	}
+
 	if col := cfg.Collation; col != defaultCollation && len(col) > 0 {
 		writeDSNParam(&buf, &hasParam, "collation", col)
 	}
@@ -228,10 +451,38 @@ func (cfg *Config) FormatDSN() string {
 		writeDSNParam(&buf, &hasParam, "columnsWithAlias", "true")
 	}
 
+	if cfg.Compress {
+		writeDSNParam(&buf, &hasParam, "compress", "true")
+	}
+
+	if len(cfg.CompressionAlgorithm) > 0 {
+		writeDSNParam(&buf, &hasParam, "compressionAlgorithm", cfg.CompressionAlgorithm)
+	}
+
+	if len(cfg.ConnectionAttributes) > 0 {
+		writeDSNParam(&buf, &hasParam, "connectionAttributes", url.QueryEscape(formatConnectionAttributes(cfg.ConnectionAttributes)))
+	}
+
+	if cfg.DecimalAsFloat {
+		writeDSNParam(&buf, &hasParam, "decimalAsFloat", "true")
+	}
+
+	if cfg.DrainTimeout > 0 {
+		writeDSNParam(&buf, &hasParam, "drainTimeout", cfg.DrainTimeout.String())
+	}
+
+	if cfg.FIPSMode {
+		writeDSNParam(&buf, &hasParam, "fipsMode", "true")
+	}
+
 	if cfg.InterpolateParams {
 		writeDSNParam(&buf, &hasParam, "interpolateParams", "true")
 	}
 
+	if len(cfg.Labels) > 0 {
+		writeDSNParam(&buf, &hasParam, "labels", url.QueryEscape(formatConnectionAttributes(cfg.Labels)))
+	}
+
 	if cfg.Loc != time.UTC && cfg.Loc != nil {
 		writeDSNParam(&buf, &hasParam, "loc", url.QueryEscape(cfg.Loc.String()))
 	}
@@ -252,10 +503,34 @@ func (cfg *Config) FormatDSN() string {
 		writeDSNParam(&buf, &hasParam, "rejectReadOnly", "true")
 	}
 
+	if cfg.ReplicaGTIDWait > 0 {
+		writeDSNParam(&buf, &hasParam, "replicaGTIDWait", cfg.ReplicaGTIDWait.String())
+	}
+
+	if len(cfg.Replicas) > 0 {
+		writeDSNParam(&buf, &hasParam, "replicas", url.QueryEscape(strings.Join(cfg.Replicas, ",")))
+	}
+
+	if len(cfg.Roles) > 0 {
+		writeDSNParam(&buf, &hasParam, "roles", url.QueryEscape(strings.Join(cfg.Roles, ",")))
+	}
+
+	if cfg.ServerCollation {
+		writeDSNParam(&buf, &hasParam, "serverCollation", "true")
+	}
+
 	if len(cfg.ServerPubKey) > 0 {
 		writeDSNParam(&buf, &hasParam, "serverPubKey", url.QueryEscape(cfg.ServerPubKey))
 	}
 
+	if cfg.StmtStackTraces {
+		writeDSNParam(&buf, &hasParam, "stmtStackTraces", "true")
+	}
+
+	if !cfg.TCPNoDelay {
+		writeDSNParam(&buf, &hasParam, "tcpNoDelay", "false")
+	}
+
 	if cfg.Timeout > 0 {
 		writeDSNParam(&buf, &hasParam, "timeout", cfg.Timeout.String())
 	}
@@ -264,14 +539,42 @@ func (cfg *Config) FormatDSN() string {
 		writeDSNParam(&buf, &hasParam, "tls", url.QueryEscape(cfg.TLSConfig))
 	}
 
+	if cfg.TLSTimeout > 0 {
+		writeDSNParam(&buf, &hasParam, "tlsTimeout", cfg.TLSTimeout.String())
+	}
+
+	if cfg.TxReplay {
+		writeDSNParam(&buf, &hasParam, "txReplay", "true")
+	}
+
 	if cfg.WriteTimeout > 0 {
 		writeDSNParam(&buf, &hasParam, "writeTimeout", cfg.WriteTimeout.String())
 	}
 
+	if cfg.ZlibLevel > 0 {
+		writeDSNParam(&buf, &hasParam, "zlibLevel", strconv.Itoa(cfg.ZlibLevel))
+	}
+
+	if cfg.ZstdLevel > 0 {
+		writeDSNParam(&buf, &hasParam, "zstdLevel", strconv.Itoa(cfg.ZstdLevel))
+	}
+
 	if cfg.MaxAllowedPacket != defaultMaxAllowedPacket {
 		writeDSNParam(&buf, &hasParam, "maxAllowedPacket", strconv.Itoa(cfg.MaxAllowedPacket))
 	}
 
+	if cfg.MaxQuerySize > 0 {
+		writeDSNParam(&buf, &hasParam, "maxQuerySize", strconv.Itoa(cfg.MaxQuerySize))
+	}
+
+	if cfg.MaxReadPacket > 0 {
+		writeDSNParam(&buf, &hasParam, "maxReadPacket", strconv.Itoa(cfg.MaxReadPacket))
+	}
+
+	if cfg.MinCompressLength > 0 {
+		writeDSNParam(&buf, &hasParam, "minCompressLength", strconv.Itoa(cfg.MinCompressLength))
+	}
+
 	// other params
 	if cfg.Params != nil {
 		var params []string
@@ -289,6 +592,19 @@ func (cfg *Config) FormatDSN() string {
 
 // ParseDSN parses the DSN string to a Config
 func ParseDSN(dsn string) (cfg *Config, err error) {
+	return parseDSN(dsn, false)
+}
+
+// ParseDSNStrict is like ParseDSN, but rejects parameters which are most
+// likely mistakes instead of sending them to the server as system variables:
+// parameters without a value, parameters given more than once and
+// parameters which differ from a parameter of the driver only in case, like
+// parsetime=true. Errors for invalid values name the parameter.
+func ParseDSNStrict(dsn string) (*Config, error) {
+	return parseDSN(dsn, true)
+}
+
+func parseDSN(dsn string, strict bool) (cfg *Config, err error) {
 	// New config with some default values
 	cfg = NewConfig()
 
@@ -342,7 +658,7 @@ func ParseDSN(dsn string) (cfg *Config, err error) {
 			// Find the first '?' in dsn[i+1:]
 			for j = i + 1; j < len(dsn); j++ {
 				if dsn[j] == '?' {
-					if err = parseDSNParams(cfg, dsn[j+1:]); err != nil {
+					if err = parseDSNParams(cfg, dsn[j+1:], strict); err != nil {
 						return
 					}
 					break
@@ -366,9 +682,29 @@ func ParseDSN(dsn string) (cfg *Config, err error) {
 
 // parseDSNParams parses the DSN "query string"
 // Values must be url.QueryEscape'ed
-func parseDSNParams(cfg *Config, params string) (err error) {
+func parseDSNParams(cfg *Config, params string, strict bool) (err error) {
+	var name string // the parameter being parsed, for errors in strict mode
+	if strict {
+		defer func() {
+			if err != nil && name != "" {
+				err = fmt.Errorf("invalid DSN parameter %s: %w", name, err)
+			}
+		}()
+	}
+
+	var seen map[string]bool
+	if strict {
+		seen = make(map[string]bool)
+	}
 	for _, v := range strings.Split(params, "&") {
 		param := strings.SplitN(v, "=", 2)
+		if strict {
+			name = ""
+			if err := checkDSNParam(param, seen); err != nil {
+				return err
+			}
+			name = param[0]
+		}
 		if len(param) != 2 {
 			continue
 		}
@@ -407,6 +743,13 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 				return errors.New("invalid bool value: " + value)
 			}
 
+		// Handshake and authentication Timeout
+		case "authTimeout":
+			cfg.AuthTimeout, err = time.ParseDuration(value)
+			if err != nil {
+				return
+			}
+
 		// Check connections for Liveness before using them
 		case "checkConnLiveness":
 			var isBool bool
@@ -423,6 +766,13 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 				return errors.New("invalid bool value: " + value)
 			}
 
+		// Wait for the server to close the connection
+		case "closeTimeout":
+			cfg.CloseTimeout, err = time.ParseDuration(value)
+			if err != nil {
+				return
+			}
+
 		// Collation
 		case "collation":
 			cfg.Collation = value
@@ -434,9 +784,46 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 				return errors.New("invalid bool value: " + value)
 			}
 
+		// Additional connection attributes
+		case "connectionAttributes":
+			if cfg.ConnectionAttributes, err = parseKeyValues(param[0], value); err != nil {
+				return
+			}
+
 		// Compression
 		case "compress":
-			return errors.New("compression not implemented yet")
+			var isBool bool
+			cfg.Compress, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
+
+		// Algorithm of the compressed protocol
+		case "compressionAlgorithm":
+			cfg.CompressionAlgorithm = value
+
+		// Decode DECIMAL values to float64
+		case "decimalAsFloat":
+			var isBool bool
+			cfg.DecimalAsFloat, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
+
+		// Kill the query if draining the result set takes too long
+		case "drainTimeout":
+			cfg.DrainTimeout, err = time.ParseDuration(value)
+			if err != nil {
+				return
+			}
+
+		// Restrict to FIPS-approved cryptography
+		case "fipsMode":
+			var isBool bool
+			cfg.FIPSMode, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
 
 		// Enable client side placeholder substitution
 		case "interpolateParams":
@@ -446,6 +833,12 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 				return errors.New("invalid bool value: " + value)
 			}
 
+		// Labels of the pool
+		case "labels":
+			if cfg.Labels, err = parseKeyValues(param[0], value); err != nil {
+				return
+			}
+
 		// Time Location
 		case "loc":
 			if value, err = url.QueryUnescape(value); err != nil {
@@ -487,6 +880,37 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 				return errors.New("invalid bool value: " + value)
 			}
 
+		// Wait for replicas to catch up before read-only transactions
+		case "replicaGTIDWait":
+			cfg.ReplicaGTIDWait, err = time.ParseDuration(value)
+			if err != nil {
+				return
+			}
+
+		// Replicas for read-only transactions
+		case "replicas":
+			replicas, err := url.QueryUnescape(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for replicas: %v", err)
+			}
+			cfg.Replicas = strings.Split(replicas, ",")
+
+		// Roles to activate
+		case "roles":
+			roles, err := url.QueryUnescape(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for roles: %v", err)
+			}
+			cfg.Roles = strings.Split(roles, ",")
+
+		// Use the default collation of the server
+		case "serverCollation":
+			var isBool bool
+			cfg.ServerCollation, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
+
 		// Server public key
 		case "serverPubKey":
 			name, err := url.QueryUnescape(value)
@@ -495,10 +919,26 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 			}
 			cfg.ServerPubKey = name
 
+		// Record the stacks of Prepare
+		case "stmtStackTraces":
+			var isBool bool
+			cfg.StmtStackTraces, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
+
 		// Strict mode
 		case "strict":
 			panic("strict mode has been removed. See https://github.com/go-sql-driver/mysql/wiki/strict-mode")
 
+		// Disable Nagle's algorithm
+		case "tcpNoDelay":
+			var isBool bool
+			cfg.TCPNoDelay, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
+
 		// Dial Timeout
 		case "timeout":
 			cfg.Timeout, err = time.ParseDuration(value)
@@ -515,7 +955,7 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 				} else {
 					cfg.TLSConfig = "false"
 				}
-			} else if vl := strings.ToLower(value); vl == "skip-verify" || vl == "preferred" {
+			} else if vl := strings.ToLower(value); isReservedTLSConfigName(vl) {
 				cfg.TLSConfig = vl
 			} else {
 				name, err := url.QueryUnescape(value)
@@ -525,17 +965,61 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 				cfg.TLSConfig = name
 			}
 
+		// TLS handshake Timeout
+		case "tlsTimeout":
+			cfg.TLSTimeout, err = time.ParseDuration(value)
+			if err != nil {
+				return
+			}
+
+		// Replay transactions after a failover
+		case "txReplay":
+			var isBool bool
+			cfg.TxReplay, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
+
 		// I/O write Timeout
 		case "writeTimeout":
 			cfg.WriteTimeout, err = time.ParseDuration(value)
 			if err != nil {
 				return
 			}
+
+		// zlib compression level
+		case "zlibLevel":
+			cfg.ZlibLevel, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
+
+		// zstd compression level
+		case "zstdLevel":
+			cfg.ZstdLevel, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
 		case "maxAllowedPacket":
 			cfg.MaxAllowedPacket, err = strconv.Atoi(value)
 			if err != nil {
 				return
 			}
+		case "maxQuerySize":
+			cfg.MaxQuerySize, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
+		case "maxReadPacket":
+			cfg.MaxReadPacket, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
+		case "minCompressLength":
+			cfg.MinCompressLength, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
 		default:
 			// lazy init
 			if cfg.Params == nil {
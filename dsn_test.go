@@ -9,6 +9,10 @@
 package mysql
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"fmt"
 	"net/url"
@@ -22,55 +26,97 @@ var testDSNs = []struct {
 	out *Config
 }{{
 	"username:password@protocol(address)/dbname?param=value",
-	&Config{User: "username", Passwd: "password", Net: "protocol", Addr: "address", DBName: "dbname", Params: map[string]string{"param": "value"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{User: "username", Passwd: "password", Net: "protocol", Addr: "address", DBName: "dbname", Params: map[string]string{"param": "value"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"username:password@protocol(address)/dbname?param=value&columnsWithAlias=true",
-	&Config{User: "username", Passwd: "password", Net: "protocol", Addr: "address", DBName: "dbname", Params: map[string]string{"param": "value"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, ColumnsWithAlias: true},
+	&Config{User: "username", Passwd: "password", Net: "protocol", Addr: "address", DBName: "dbname", Params: map[string]string{"param": "value"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, ColumnsWithAlias: true},
 }, {
 	"username:password@protocol(address)/dbname?param=value&columnsWithAlias=true&multiStatements=true",
-	&Config{User: "username", Passwd: "password", Net: "protocol", Addr: "address", DBName: "dbname", Params: map[string]string{"param": "value"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, ColumnsWithAlias: true, MultiStatements: true},
+	&Config{User: "username", Passwd: "password", Net: "protocol", Addr: "address", DBName: "dbname", Params: map[string]string{"param": "value"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, ColumnsWithAlias: true, MultiStatements: true},
 }, {
 	"user@unix(/path/to/socket)/dbname?charset=utf8",
-	&Config{User: "user", Net: "unix", Addr: "/path/to/socket", DBName: "dbname", Params: map[string]string{"charset": "utf8"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{User: "user", Net: "unix", Addr: "/path/to/socket", DBName: "dbname", Params: map[string]string{"charset": "utf8"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"user:password@tcp(localhost:5555)/dbname?charset=utf8&tls=true",
-	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Params: map[string]string{"charset": "utf8"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TLSConfig: "true"},
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Params: map[string]string{"charset": "utf8"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, TLSConfig: "true"},
 }, {
 	"user:password@tcp(localhost:5555)/dbname?charset=utf8mb4,utf8&tls=skip-verify",
-	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Params: map[string]string{"charset": "utf8mb4,utf8"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TLSConfig: "skip-verify"},
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Params: map[string]string{"charset": "utf8mb4,utf8"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, TLSConfig: "skip-verify"},
 }, {
-	"user:password@/dbname?loc=UTC&timeout=30s&readTimeout=1s&writeTimeout=1s&allowAllFiles=1&clientFoundRows=true&allowOldPasswords=TRUE&collation=utf8mb4_unicode_ci&maxAllowedPacket=16777216&tls=false&allowCleartextPasswords=true&parseTime=true&rejectReadOnly=true",
-	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_unicode_ci", Loc: time.UTC, TLSConfig: "false", AllowCleartextPasswords: true, AllowNativePasswords: true, Timeout: 30 * time.Second, ReadTimeout: time.Second, WriteTimeout: time.Second, AllowAllFiles: true, AllowOldPasswords: true, CheckConnLiveness: true, ClientFoundRows: true, MaxAllowedPacket: 16777216, ParseTime: true, RejectReadOnly: true},
+	"user:password@/dbname?loc=UTC&timeout=30s&readTimeout=1s&writeTimeout=1s&closeTimeout=100ms&drainTimeout=5s&allowAllFiles=1&clientFoundRows=true&allowOldPasswords=TRUE&collation=utf8mb4_unicode_ci&maxAllowedPacket=16777216&tls=false&allowCleartextPasswords=true&parseTime=true&rejectReadOnly=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_unicode_ci", Loc: time.UTC, TLSConfig: "false", AllowCleartextPasswords: true, AllowNativePasswords: true, Timeout: 30 * time.Second, ReadTimeout: time.Second, WriteTimeout: time.Second, CloseTimeout: 100 * time.Millisecond, DrainTimeout: 5 * time.Second, AllowAllFiles: true, AllowOldPasswords: true, CheckConnLiveness: true, TCPNoDelay: true, ClientFoundRows: true, MaxAllowedPacket: 16777216, ParseTime: true, RejectReadOnly: true},
 }, {
-	"user:password@/dbname?allowNativePasswords=false&checkConnLiveness=false&maxAllowedPacket=0",
-	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: 0, AllowNativePasswords: false, CheckConnLiveness: false},
+	"user:password@tcp(localhost:5555)/dbname?replicaGTIDWait=1s&replicas=replica1:3306,replica2",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, Replicas: []string{"replica1:3306", "replica2:3306"}, ReplicaGTIDWait: time.Second},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?roles=app_read,admin@localhost",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, Roles: []string{"app_read", "admin@localhost"}},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?compress=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, Compress: true},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?compress=true&compressionAlgorithm=zstd&zstdLevel=7",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, Compress: true, CompressionAlgorithm: "zstd", ZstdLevel: 7},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?compress=true&zlibLevel=1&minCompressLength=1024",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, Compress: true, ZlibLevel: 1, MinCompressLength: 1024},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?txReplay=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, TxReplay: true},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?labels=pool:orders,tenant:acme",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, Labels: map[string]string{"pool": "orders", "tenant": "acme"}},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?connectionAttributes=pod:web-1,service:billing",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, ConnectionAttributes: map[string]string{"pod": "web-1", "service": "billing"}},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?authTimeout=2s&timeout=1s&tlsTimeout=500ms",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, Timeout: time.Second, TLSTimeout: 500 * time.Millisecond, AuthTimeout: 2 * time.Second},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?decimalAsFloat=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, DecimalAsFloat: true},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?maxQuerySize=65536",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, MaxQuerySize: 65536, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?serverCollation=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, ServerCollation: true},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?stmtStackTraces=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, StmtStackTraces: true},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?tls=true&fipsMode=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true, TLSConfig: "true", FIPSMode: true},
+}, {
+	"user:password@/dbname?allowNativePasswords=false&checkConnLiveness=false&maxAllowedPacket=0&maxReadPacket=1048576&tcpNoDelay=false",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: 0, MaxReadPacket: 1048576, AllowNativePasswords: false, CheckConnLiveness: false, TCPNoDelay: false},
 }, {
 	"user:p@ss(word)@tcp([de:ad:be:ef::ca:fe]:80)/dbname?loc=Local",
-	&Config{User: "user", Passwd: "p@ss(word)", Net: "tcp", Addr: "[de:ad:be:ef::ca:fe]:80", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.Local, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{User: "user", Passwd: "p@ss(word)", Net: "tcp", Addr: "[de:ad:be:ef::ca:fe]:80", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.Local, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"/dbname",
-	&Config{Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"@/",
-	&Config{Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"/",
-	&Config{Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"",
-	&Config{Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"user:p@/ssword@/",
-	&Config{User: "user", Passwd: "p@/ssword", Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{User: "user", Passwd: "p@/ssword", Net: "tcp", Addr: "127.0.0.1:3306", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"unix/?arg=%2Fsome%2Fpath.ext",
-	&Config{Net: "unix", Addr: "/tmp/mysql.sock", Params: map[string]string{"arg": "/some/path.ext"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{Net: "unix", Addr: "/tmp/mysql.sock", Params: map[string]string{"arg": "/some/path.ext"}, Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"tcp(127.0.0.1)/dbname",
-	&Config{Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 }, {
 	"tcp(de:ad:be:ef::ca:fe)/dbname",
-	&Config{Net: "tcp", Addr: "[de:ad:be:ef::ca:fe]:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true},
+	&Config{Net: "tcp", Addr: "[de:ad:be:ef::ca:fe]:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: defaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, TCPNoDelay: true},
 },
 }
 
@@ -99,6 +145,8 @@ func TestDSNParserInvalid(t *testing.T) {
 		"net(addr)//",                 // unescaped
 		"User:pass@tcp(1.2.3.4:3306)", // no trailing slash
 		"net()/",                      // unknown default addr
+		"/?zlibLevel=10",              // out of range
+		"/?minCompressLength=-1",      // negative
 		//"/dbname?arg=/some/unescaped/path",
 	}
 
@@ -220,6 +268,37 @@ func TestDSNWithCustomTLS(t *testing.T) {
 	}
 }
 
+func TestTLSConfigForHost(t *testing.T) {
+	RegisterTLSConfig("host_test", &tls.Config{})
+	RegisterTLSConfigForHost("host_test", "replica1", &tls.Config{ServerName: "replica1.internal"})
+	RegisterTLSConfigForHost("host_test", "replica2:3307", &tls.Config{ServerName: "replica2.internal"})
+	defer DeregisterTLSConfig("host_test")
+
+	cfg, err := ParseDSN("tcp(primary)/?tls=host_test&replicas=replica1,replica2:3307,replica3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConnector(cfg)
+	expected := []string{"primary", "replica1.internal", "replica2.internal", "replica3"}
+	got := []string{c.cfg.tls.ServerName}
+	for _, r := range c.replicas {
+		got = append(got, r.cfg.tls.ServerName)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("expected ServerNames %v, got %v", expected, got)
+	}
+
+	// a host without a config of its own requires a default config
+	RegisterTLSConfigForHost("host_only_test", "replica1", &tls.Config{})
+	defer DeregisterTLSConfig("host_only_test")
+	if _, err := ParseDSN("tcp(replica1)/?tls=host_only_test"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseDSN("tcp(replica1)/?tls=host_only_test&replicas=replica2"); err == nil {
+		t.Error("expected an error for a replica without a TLS config")
+	}
+}
+
 func TestDSNTLSConfig(t *testing.T) {
 	expectedServerName := "example.com"
 	dsn := "tcp(example.com:1234)/?tls=true"
@@ -402,6 +481,100 @@ func TestNormalizeTLSConfig(t *testing.T) {
 	}
 }
 
+func TestNormalizeFIPSMode(t *testing.T) {
+	for _, tlsConfig := range []string{"", "false", "preferred"} {
+		cfg := NewConfig()
+		cfg.TLSConfig = tlsConfig
+		cfg.FIPSMode = true
+		if err := cfg.normalize(); err != errInvalidDSNFIPSNoTLS {
+			t.Errorf("tls=%q: expected %v, got %v", tlsConfig, errInvalidDSNFIPSNoTLS, err)
+		}
+	}
+
+	cfg := NewConfig()
+	cfg.TLSConfig = "true"
+	cfg.FIPSMode = true
+	if err := cfg.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.tls.MinVersion != tls.VersionTLS12 || cfg.tls.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 only, got 0x%x-0x%x", cfg.tls.MinVersion, cfg.tls.MaxVersion)
+	}
+	if !reflect.DeepEqual(cfg.tls.CipherSuites, fipsCipherSuites) {
+		t.Errorf("unexpected cipher suites: %v", cfg.tls.CipherSuites)
+	}
+
+	// the lists of the driver are not shared with the config
+	cfg.tls.CipherSuites[0] = tls.TLS_RSA_WITH_RC4_128_SHA
+	cfg.tls.CurvePreferences[0] = tls.X25519
+	if fipsCipherSuites[0] == tls.TLS_RSA_WITH_RC4_128_SHA || fipsCurves[0] == tls.X25519 {
+		t.Error("changing the config changed the FIPS lists")
+	}
+
+	cfg = NewConfig()
+	cfg.TLS = &tls.Config{CipherSuites: []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}}
+	cfg.FIPSMode = true
+	if err := cfg.normalize(); err == nil {
+		t.Error("expected error for a non-approved cipher suite")
+	}
+
+	for _, version := range []uint16{tls.VersionTLS11, tls.VersionTLS13} {
+		cfg = NewConfig()
+		cfg.TLS = &tls.Config{MaxVersion: version}
+		cfg.FIPSMode = true
+		if err := cfg.normalize(); err == nil {
+			t.Errorf("expected error for max version 0x%04x", version)
+		}
+	}
+	cfg = NewConfig()
+	cfg.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	cfg.FIPSMode = true
+	if err := cfg.normalize(); err != nil {
+		t.Errorf("unexpected error for max version TLS 1.2: %v", err)
+	}
+}
+
+type testSigner struct {
+	crypto.Signer
+}
+
+func TestNormalizeTLSCertificateSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewConfig()
+	cfg.Addr = "myserver:3306"
+	cfg.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{{0x30}},
+			PrivateKey:  testSigner{key},
+		}},
+	}
+	if err := cfg.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.tls == nil || cfg.tls == cfg.TLS {
+		t.Fatal("Config.TLS was not cloned into the effective TLS config")
+	}
+	if cfg.tls.ServerName != "myserver" {
+		t.Errorf("tls.ServerName doesn't match (want: 'myserver', got: '%s')", cfg.tls.ServerName)
+	}
+	if _, ok := cfg.tls.Certificates[0].PrivateKey.(testSigner); !ok {
+		t.Errorf("signer-backed private key was not preserved, got %T", cfg.tls.Certificates[0].PrivateKey)
+	}
+
+	cfg.TLS.Certificates[0].PrivateKey = "not a key"
+	if err := cfg.normalize(); err == nil {
+		t.Error("expected error for a private key which is not a crypto.Signer")
+	}
+	if err := RegisterTLSConfig("test_tls_signer", cfg.TLS); err == nil {
+		DeregisterTLSConfig("test_tls_signer")
+		t.Error("expected RegisterTLSConfig to reject a private key which is not a crypto.Signer")
+	}
+}
+
 func BenchmarkParseDSN(b *testing.B) {
 	b.ReportAllocs()
 
@@ -413,3 +586,45 @@ func BenchmarkParseDSN(b *testing.B) {
 		}
 	}
 }
+
+func TestParseDSNStrict(t *testing.T) {
+	valid := testDSNs[1].in
+	if _, err := ParseDSNStrict(valid); err != nil {
+		t.Errorf("%s: %v", valid, err)
+	}
+
+	tests := []struct {
+		dsn string
+		err string
+	}{
+		{"/dbname?parsetime=true", "unknown DSN parameter parsetime, did you mean parseTime?"},
+		{"/dbname?parseTime", `invalid DSN parameter "parseTime": missing value`},
+		{"/dbname?timeout=1s&timeout=2s", "invalid DSN parameter timeout: given more than once"},
+		{"/dbname?readTimeout=1", `invalid DSN parameter readTimeout: time: missing unit in duration "1"`},
+		{"/dbname?parseTime=yes", "invalid DSN parameter parseTime: invalid bool value: yes"},
+	}
+	for _, test := range tests {
+		if _, err := ParseDSNStrict(test.dsn); err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.dsn, test.err, err)
+		}
+	}
+
+	// system variables are still allowed, but not in ParseDSN
+	cfg, err := ParseDSNStrict("/dbname?sql_mode=ANSI&")
+	if err != nil || cfg.Params["sql_mode"] != "ANSI" {
+		t.Errorf("unexpected result for a system variable: %v, %v", cfg, err)
+	}
+	if _, err := ParseDSN("/dbname?parsetime=true&parseTime"); err != nil {
+		t.Errorf("ParseDSN is not strict: %v", err)
+	}
+
+	// all parameters of the driver are known
+	for _, name := range dsnParams {
+		if name == "strict" || name == "charset" {
+			continue
+		}
+		if cfg, err := ParseDSN("/dbname?" + name + "=x"); err == nil && cfg.Params[name] != "" {
+			t.Errorf("%s is not a parameter of the driver", name)
+		}
+	}
+}
@@ -13,22 +13,31 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
 // Various errors the driver might return. Can change between driver versions.
+// ErrMalformPkt, ErrPktSync and ErrPktSyncMul are returned wrapped in a
+// *ProtocolError; check for them with errors.Is.
 var (
 	ErrInvalidConn       = errors.New("invalid connection")
 	ErrMalformPkt        = errors.New("malformed packet")
 	ErrNoTLS             = errors.New("TLS requested but server does not support TLS")
 	ErrCleartextPassword = errors.New("this user requires clear text authentication. If you still want to use it, please add 'allowCleartextPasswords=1' to your DSN")
+	ErrInsecureCleartext = errors.New("this user requires clear text authentication, which is only allowed over TLS or a unix socket")
 	ErrNativePassword    = errors.New("this user requires mysql native password authentication.")
 	ErrOldPassword       = errors.New("this user requires old password authentication. If you still want to use it, please add 'allowOldPasswords=1' to your DSN. See also https://github.com/go-sql-driver/mysql/wiki/old_passwords")
 	ErrUnknownPlugin     = errors.New("this authentication plugin is not supported")
+	ErrFIPSAuthPlugin    = errors.New("this authentication plugin is not allowed in FIPS mode")
 	ErrOldProtocol       = errors.New("MySQL server does not support required protocol 41+")
 	ErrPktSync           = errors.New("commands out of sync. You can't run this command now")
 	ErrPktSyncMul        = errors.New("commands out of sync. Did you run multiple statements at once?")
 	ErrPktTooLarge       = errors.New("packet for query is too large. Try adjusting the 'max_allowed_packet' variable on the server")
+	ErrPktReadTooLarge   = errors.New("packet from server is too large. Try adjusting the 'maxReadPacket' DSN parameter")
 	ErrBusyBuffer        = errors.New("busy buffer")
+	ErrDrainTimeout      = errors.New("timed out draining the result set and could not kill the query; the connection was closed")
+	ErrUnsafeCollation   = errors.New("strings can not be escaped safely in the collation of the connection")
+	ErrNoShardKey        = errors.New("no shard key in the context; use WithShardKey")
 
 	// errBadConnNoWrite is used for connection errors where nothing was sent to the database yet.
 	// If this happens first in a function starting a database interaction, it should be replaced by driver.ErrBadConn
@@ -70,3 +79,163 @@ func (me *MySQLError) Is(err error) bool {
 	}
 	return false
 }
+
+// MaxRowsError is returned by Rows.Next if a result set has more rows than
+// allowed by WithMaxRows.
+type MaxRowsError struct {
+	Max int64
+}
+
+func (e *MaxRowsError) Error() string {
+	return fmt.Sprintf("result set exceeds the maximum of %d rows", e.Max)
+}
+
+// RowTimeoutError is returned by Rows.Next if the next row did not arrive
+// within the timeout set by WithRowTimeout. The server may still be running
+// the query, e.g. to kill it; the connection has been closed.
+type RowTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *RowTimeoutError) Error() string {
+	return fmt.Sprintf("no row received within %v", e.Timeout)
+}
+
+// QuerySizeError is returned if a query, or the parameters of a prepared
+// statement, are larger than the maxQuerySize of the config. Nothing was sent
+// to the server.
+type QuerySizeError struct {
+	Size int
+	Max  int
+}
+
+func (e *QuerySizeError) Error() string {
+	return fmt.Sprintf("query of %d bytes exceeds the maximum query size of %d bytes", e.Size, e.Max)
+}
+
+// PolicyError is returned if the StatementPolicy of the config rejects a
+// statement. The statement was not sent to the server.
+type PolicyError struct {
+	Statement *Statement
+	Err       error // the error returned by the policy
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s statement rejected by policy: %v", e.Statement.Keyword, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectTimeoutError is returned by Connect if a phase of the connection
+// setup exceeds its timeout, see Config.TLSTimeout and Config.AuthTimeout.
+// Timeouts of the dial are returned as *net.OpError, like before.
+type ConnectTimeoutError struct {
+	Phase   string        // "tls" or "auth"
+	Timeout time.Duration // timeout of the phase
+	Err     error         // the error of the interrupted I/O
+
+	Labels map[string]string // Labels of the config
+}
+
+func (e *ConnectTimeoutError) Error() string {
+	return fmt.Sprintf("%s phase of connect timed out after %v: %v", e.Phase, e.Timeout, e.Err)
+}
+
+func (e *ConnectTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// TooManyConnectionsError is returned by Connect if the server refused the
+// connection because of its connection limits (ER_CON_COUNT_ERROR,
+// ER_TOO_MANY_USER_CONNECTIONS or ER_USER_LIMIT_REACHED). Unlike failed
+// authentication, retrying can succeed, but not before Backoff, which
+// doubles with every consecutive refusal of the server.
+type TooManyConnectionsError struct {
+	Addr    string
+	Backoff time.Duration // suggested wait before connecting again
+	Err     *MySQLError
+
+	Labels map[string]string // Labels of the config
+}
+
+func (e *TooManyConnectionsError) Error() string {
+	return fmt.Sprintf("%s refused the connection, retry after %v: %v", e.Addr, e.Backoff, e.Err)
+}
+
+func (e *TooManyConnectionsError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError is returned if the driver received a packet it can not
+// handle. It describes the packet to help triaging bugs of proxies and
+// concurrent use of a connection. Err is ErrMalformPkt, ErrPktSync or
+// ErrPktSyncMul; use errors.Is to check for them.
+type ProtocolError struct {
+	Err         error
+	Command     byte   // command in flight, 0 during the handshake
+	ExpectedSeq uint8  // expected sequence number of the packet
+	ReceivedSeq uint8  // sequence number of the packet
+	PacketLen   int    // length of the packet
+	Head        []byte // first bytes of the packet, at most 16
+
+	Labels map[string]string // Labels of the config of the connection
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%v (command %s, sequence %d, expected %d, length %d, head %x)",
+		e.Err, commandName(e.Command), e.ReceivedSeq, e.ExpectedSeq, e.PacketLen, e.Head)
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// malformed returns a *ProtocolError for the malformed packet data, which
+// was the last packet read.
+func (mc *mysqlConn) malformed(data []byte) error {
+	head := data
+	if len(head) > 16 {
+		head = head[:16]
+	}
+	return &ProtocolError{
+		Err:         ErrMalformPkt,
+		Command:     mc.command,
+		ExpectedSeq: mc.sequence - 1,
+		ReceivedSeq: mc.sequence - 1,
+		PacketLen:   len(data),
+		Head:        append([]byte(nil), head...),
+		Labels:      mc.Labels(),
+	}
+}
+
+var commandNames = map[byte]string{
+	comQuit:             "COM_QUIT",
+	comInitDB:           "COM_INIT_DB",
+	comQuery:            "COM_QUERY",
+	comFieldList:        "COM_FIELD_LIST",
+	comStatistics:       "COM_STATISTICS",
+	comProcessKill:      "COM_PROCESS_KILL",
+	comShutdown:         "COM_SHUTDOWN",
+	comDebug:            "COM_DEBUG",
+	comPing:             "COM_PING",
+	comChangeUser:       "COM_CHANGE_USER",
+	comStmtPrepare:      "COM_STMT_PREPARE",
+	comStmtExecute:      "COM_STMT_EXECUTE",
+	comStmtSendLongData: "COM_STMT_SEND_LONG_DATA",
+	comStmtClose:        "COM_STMT_CLOSE",
+	comStmtReset:        "COM_STMT_RESET",
+	comSetOption:        "COM_SET_OPTION",
+	comStmtFetch:        "COM_STMT_FETCH",
+}
+
+func commandName(command byte) string {
+	if command == 0 {
+		return "handshake"
+	}
+	if name, ok := commandNames[command]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", command)
+}
@@ -0,0 +1,82 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"errors"
+)
+
+// FIDORequest is the assertion the server requests from the FIDO device of
+// an account identified with authentication_fido.
+type FIDORequest struct {
+	User           string // user of the connection
+	Challenge      []byte // challenge (client data hash) to sign
+	RelyingPartyID string // relying party the credential was registered for
+	CredentialID   []byte // credential registered for the account
+}
+
+// FIDOAssertion is the answer of the FIDO device to a FIDORequest.
+type FIDOAssertion struct {
+	AuthenticatorData []byte
+	Signature         []byte
+}
+
+// FIDOAuthenticator gets an assertion from the FIDO device, e.g. with
+// libfido2, usually after prompting the user to touch the device. It is
+// called while the connection is established and must return when ctx is
+// done.
+type FIDOAuthenticator func(ctx context.Context, req *FIDORequest) (*FIDOAssertion, error)
+
+var errNoFIDOAuthenticator = errors.New("this user requires FIDO authentication, which requires Config.FIDOAuthenticator")
+
+// parseFIDORequest parses the auth data of authentication_fido:
+//
+//	challenge [length encoded string]
+//	relying party id [length encoded string]
+//	credential id [length encoded string]
+func parseFIDORequest(data []byte) (*FIDORequest, error) {
+	var fields [3][]byte
+	for i := range fields {
+		v, _, n, err := readLengthEncodedString(data)
+		if err != nil {
+			return nil, ErrMalformPkt
+		}
+		fields[i] = append([]byte(nil), v...)
+		data = data[n:]
+	}
+	return &FIDORequest{
+		Challenge:      fields[0],
+		RelyingPartyID: string(fields[1]),
+		CredentialID:   fields[2],
+	}, nil
+}
+
+// fidoAuth returns the response of authentication_fido:
+//
+//	authenticator data [length encoded string]
+//	signature [length encoded string]
+func (mc *mysqlConn) fidoAuth(authData []byte) ([]byte, error) {
+	if mc.cfg.FIDOAuthenticator == nil {
+		return nil, errNoFIDOAuthenticator
+	}
+	req, err := parseFIDORequest(authData)
+	if err != nil {
+		return nil, err
+	}
+	req.User = mc.cfg.User
+	assertion, err := mc.cfg.FIDOAuthenticator(mc.commandContext(), req)
+	if err != nil {
+		return nil, err
+	}
+	resp := appendLengthEncodedInteger(nil, uint64(len(assertion.AuthenticatorData)))
+	resp = append(resp, assertion.AuthenticatorData...)
+	resp = appendLengthEncodedInteger(resp, uint64(len(assertion.Signature)))
+	return append(resp, assertion.Signature...), nil
+}
@@ -0,0 +1,51 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestAuthSwitchFIDO(t *testing.T) {
+	authData := []byte{123, 87, 15, 84, 20, 58, 37, 121, 91, 117, 51, 24, 19,
+		47, 43, 9, 41, 112, 67, 110}
+	authSwitch := append(append([]byte{254}, "authentication_fido_client\x00"...),
+		"\x04abcd\x09mysql.com\x03cid"...)
+	authSwitch = append([]byte{byte(len(authSwitch)), 0, 0, 2}, authSwitch...)
+
+	// no authenticator configured
+	conn, mc := newRWMockConn(2)
+	conn.data = authSwitch
+	conn.maxReads = 1
+	if err := mc.handleAuthResult(authData, "mysql_native_password"); err != errNoFIDOAuthenticator {
+		t.Errorf("expected errNoFIDOAuthenticator, got %v", err)
+	}
+
+	conn, mc = newRWMockConn(2)
+	mc.cfg.User = "alice"
+	mc.cfg.FIDOAuthenticator = func(ctx context.Context, req *FIDORequest) (*FIDOAssertion, error) {
+		if req.User != "alice" || string(req.Challenge) != "abcd" ||
+			req.RelyingPartyID != "mysql.com" || string(req.CredentialID) != "cid" {
+			t.Errorf("unexpected request %+v", req)
+		}
+		return &FIDOAssertion{AuthenticatorData: []byte("data"), Signature: []byte("sig")}, nil
+	}
+	conn.data = authSwitch
+	conn.queuedReplies = [][]byte{{7, 0, 0, 4, 0, 0, 0, 2, 0, 0, 0}}
+	conn.maxReads = 2
+	if err := mc.handleAuthResult(authData, "mysql_native_password"); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{9, 0, 0, 3}, "\x04data\x03sig"...)
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("got unexpected data: %q", conn.written)
+	}
+}
@@ -10,7 +10,10 @@ package mysql
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
 	"reflect"
+	"sync"
 )
 
 func (mf *mysqlField) typeDatabaseName() string {
@@ -123,9 +126,81 @@ type mysqlField struct {
 	fieldType fieldType
 	decimals  byte
 	charSet   uint8
+
+	asFloat bool        // a DECIMAL column decoded to float64, see Config.DecimalAsFloat
+	decoder DecoderFunc // registered with RegisterDecoder for the type of the column
+}
+
+// DecoderFunc decodes a value of a column, which is not NULL, to a Go type.
+// data is only valid during the call. The value is returned by Rows.Next as
+// it is, so Scan assigns it to destinations of its type or *interface{}.
+// Custom decoders must be registered with RegisterDecoder.
+type DecoderFunc func(data []byte) (driver.Value, error)
+
+var (
+	decodersLock sync.RWMutex
+	decoders     map[string]DecoderFunc
+)
+
+// decodableTypes are the database type names of the columns which are sent as
+// strings by both the text and the binary protocol.
+var decodableTypes = map[string]bool{
+	"BIT": true, "BLOB": true, "TEXT": true, "TINYBLOB": true, "TINYTEXT": true,
+	"MEDIUMBLOB": true, "MEDIUMTEXT": true, "LONGBLOB": true, "LONGTEXT": true,
+	"CHAR": true, "BINARY": true, "VARCHAR": true, "VARBINARY": true,
+	"DECIMAL": true, "ENUM": true, "SET": true, "GEOMETRY": true, "JSON": true,
+}
+
+// RegisterDecoder registers a decoder for the values of the columns with the
+// database type name typeName, as returned by sql.ColumnType.DatabaseTypeName.
+// It overrides the default conversion, including decimalAsFloat, so
+// applications can map e.g. GEOMETRY or JSON columns to their own types
+// without post-processing []byte. Only the types which are sent as strings
+// are supported: BIT, the BLOB and TEXT types, CHAR, BINARY, VARCHAR,
+// VARBINARY, DECIMAL, ENUM, SET, GEOMETRY and JSON.
+//
+//	mysql.RegisterDecoder("GEOMETRY", func(data []byte) (driver.Value, error) {
+//	    return geom.DecodeWKB(data[4:]) // skip the SRID
+//	})
+func RegisterDecoder(typeName string, dec DecoderFunc) error {
+	if !decodableTypes[typeName] {
+		return fmt.Errorf("decoders can not be registered for %s columns", typeName)
+	}
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	if decoders == nil {
+		decoders = make(map[string]DecoderFunc)
+	}
+	decoders[typeName] = dec
+	return nil
+}
+
+// DeregisterDecoder removes the decoder for the database type name typeName.
+func DeregisterDecoder(typeName string) {
+	decodersLock.Lock()
+	delete(decoders, typeName)
+	decodersLock.Unlock()
+}
+
+// getDecoder returns the decoder registered for the type of mf, or nil.
+func (mf *mysqlField) getDecoder() DecoderFunc {
+	decodersLock.RLock()
+	defer decodersLock.RUnlock()
+	if len(decoders) == 0 {
+		return nil
+	}
+	name := mf.typeDatabaseName()
+	if !decodableTypes[name] {
+		return nil
+	}
+	return decoders[name]
 }
 
 func (mf *mysqlField) scanType() reflect.Type {
+	if mf.decoder != nil {
+		return scanTypeUnknown
+	}
+
 	switch mf.fieldType {
 	case fieldTypeTiny:
 		if mf.flags&flagNotNULL != 0 {
@@ -180,6 +255,12 @@ func (mf *mysqlField) scanType() reflect.Type {
 		fieldTypeMediumBLOB, fieldTypeLongBLOB, fieldTypeBLOB,
 		fieldTypeVarString, fieldTypeString, fieldTypeGeometry, fieldTypeJSON,
 		fieldTypeTime:
+		if mf.asFloat {
+			if mf.flags&flagNotNULL != 0 {
+				return scanTypeFloat64
+			}
+			return scanTypeNullFloat
+		}
 		return scanTypeRawBytes
 
 	case fieldTypeDate, fieldTypeNewDate,
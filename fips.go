@@ -0,0 +1,97 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+var errInvalidDSNFIPSNoTLS = errors.New("invalid DSN: fipsMode requires tls (tls=preferred is not sufficient)")
+
+// fipsAuthPlugin is requested instead of mysql_native_password in FIPS mode.
+const fipsAuthPlugin = "caching_sha2_password"
+
+// fipsAuthPlugins lists the authentication plugins which can be used in FIPS
+// mode. mysql_old_password and mysql_native_password rely on non-approved
+// hash constructions and are rejected.
+var fipsAuthPlugins = map[string]bool{
+	"caching_sha2_password": true,
+	"sha256_password":       true,
+	"mysql_clear_password":  true,
+}
+
+// fipsCipherSuites are the TLS 1.2 cipher suites built exclusively from
+// FIPS 140-2 approved algorithms.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// normalizeFIPS restricts the TLS configuration to approved primitives.
+// TLS 1.3 is not negotiated, because crypto/tls does not allow restricting
+// its cipher suites. Settings of the user which conflict are rejected.
+func (cfg *Config) normalizeFIPS() error {
+	if cfg.tls == nil || cfg.TLSConfig == "preferred" {
+		return errInvalidDSNFIPSNoTLS
+	}
+
+	if v := cfg.tls.MaxVersion; v != 0 && v != tls.VersionTLS12 {
+		return fmt.Errorf("TLS max version 0x%04x is not allowed in FIPS mode, only TLS 1.2 is", v)
+	}
+	if cfg.tls.MinVersion < tls.VersionTLS12 {
+		cfg.tls.MinVersion = tls.VersionTLS12
+	}
+	cfg.tls.MaxVersion = tls.VersionTLS12
+
+	// copies, so changes of the config don't affect the lists
+	if len(cfg.tls.CipherSuites) == 0 {
+		cfg.tls.CipherSuites = append([]uint16(nil), fipsCipherSuites...)
+	} else {
+		for _, id := range cfg.tls.CipherSuites {
+			if !fipsCipherSuite(id) {
+				return fmt.Errorf("TLS cipher suite 0x%04x is not allowed in FIPS mode", id)
+			}
+		}
+	}
+
+	if len(cfg.tls.CurvePreferences) == 0 {
+		cfg.tls.CurvePreferences = append([]tls.CurveID(nil), fipsCurves...)
+	} else {
+		for _, id := range cfg.tls.CurvePreferences {
+			if !fipsCurve(id) {
+				return fmt.Errorf("TLS curve %d is not allowed in FIPS mode", id)
+			}
+		}
+	}
+	return nil
+}
+
+func fipsCipherSuite(id uint16) bool {
+	for _, v := range fipsCipherSuites {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func fipsCurve(id tls.CurveID) bool {
+	for _, v := range fipsCurves {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,9 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this file,
 // You can obtain one at http://mozilla.org/MPL/2.0/.
 
+//go:build !mysql_noinfile && !mysql_tiny
+// +build !mysql_noinfile,!mysql_tiny
+
 package mysql
 
 import (
@@ -26,7 +29,8 @@ var (
 // RegisterLocalFile adds the given file to the file allowlist,
 // so that it can be used by "LOAD DATA LOCAL INFILE <filepath>".
 // Alternatively you can allow the use of all local files with
-// the DSN parameter 'allowAllFiles=true'
+// the DSN parameter 'allowAllFiles=true', or for single statements
+// with AllowLocalInfile.
 //
 //  filePath := "/home/gopher/data.csv"
 //  mysql.RegisterLocalFile(filePath)
@@ -126,7 +130,7 @@ func (mc *mysqlConn) handleInFileRequest(name string) (err error) {
 		fileRegisterLock.RLock()
 		fr := fileRegister[name]
 		fileRegisterLock.RUnlock()
-		if mc.cfg.AllowAllFiles || fr {
+		if mc.cfg.AllowAllFiles || mc.allowInfile || fr {
 			var file *os.File
 			var fi os.FileInfo
 
@@ -0,0 +1,45 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build mysql_noinfile || mysql_tiny
+// +build mysql_noinfile mysql_tiny
+
+package mysql
+
+import (
+	"errors"
+	"io"
+)
+
+var errInFileDisabled = errors.New("LOAD DATA LOCAL INFILE is not supported by this build of the driver (mysql_noinfile)")
+
+// RegisterLocalFile does nothing, as this build of the driver does not
+// support LOAD DATA LOCAL INFILE.
+func RegisterLocalFile(filePath string) {}
+
+// DeregisterLocalFile does nothing, as this build of the driver does not
+// support LOAD DATA LOCAL INFILE.
+func DeregisterLocalFile(filePath string) {}
+
+// RegisterReaderHandler does nothing, as this build of the driver does not
+// support LOAD DATA LOCAL INFILE.
+func RegisterReaderHandler(name string, handler func() io.Reader) {}
+
+// DeregisterReaderHandler does nothing, as this build of the driver does not
+// support LOAD DATA LOCAL INFILE.
+func DeregisterReaderHandler(name string) {}
+
+// handleInFileRequest refuses the request by sending no data.
+func (mc *mysqlConn) handleInFileRequest(name string) error {
+	data := make([]byte, 4)
+	if err := mc.writePacket(data); err != nil {
+		return err
+	}
+	mc.readPacket()
+	return errInFileDisabled
+}
@@ -0,0 +1,63 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !mysql_noinfile && !mysql_tiny
+// +build !mysql_noinfile,!mysql_tiny
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAllowLocalInfile(t *testing.T) {
+	file, err := ioutil.TempFile("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("1,foo\n")
+	file.Close()
+	name := file.Name()
+	request := append([]byte{byte(1 + len(name)), 0, 0, 1, iLocalInFile}, name...)
+	query := "LOAD DATA LOCAL INFILE '" + name + "' INTO TABLE t"
+
+	// the file is not registered
+	conn, mc := newRWMockConn(0)
+	mc.maxWriteSize = defaultMaxAllowedPacket
+	conn.queuedReplies = [][]byte{
+		request,
+		{9, 0, 0, 3, 0xff, 0x51, 0x04, 'd', 'e', 'n', 'i', 'e', 'd'},
+	}
+	if _, err := mc.ExecContext(context.Background(), query, nil); err == nil {
+		t.Fatal("expected an error for a file which is not registered")
+	}
+	if bytes.Contains(conn.written, []byte("1,foo")) {
+		t.Fatal("the file was sent")
+	}
+
+	// allowed for this statement
+	conn.written = nil
+	conn.queuedReplies = [][]byte{
+		request,
+		{7, 0, 0, 4, 0, 1, 0, 2, 0, 0, 0},
+	}
+	if _, err := mc.ExecContext(AllowLocalInfile(context.Background()), query, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(conn.written, []byte("1,foo")) {
+		t.Error("the file was not sent")
+	}
+	if mc.allowInfile {
+		t.Error("the permission outlived the statement")
+	}
+}
@@ -0,0 +1,100 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// KerberosClient establishes the GSSAPI security context of the
+// authentication_kerberos plugin of MySQL Enterprise. The driver only
+// implements the exchange with the server; the GSSAPI mechanism is provided
+// by the application with RegisterKerberos, e.g. with
+// github.com/jcmturner/gokrb5 or the system's GSSAPI library, so that the
+// driver does not depend on either.
+type KerberosClient interface {
+	// InitSecContext returns the next GSSAPI token for the service principal
+	// spn of the realm. It is called with a nil input first, then with every
+	// token of the server until the server accepts the context.
+	InitSecContext(ctx context.Context, spn, realm string, input []byte) ([]byte, error)
+}
+
+var (
+	kerberosLock   sync.RWMutex
+	kerberosClient KerberosClient
+)
+
+// RegisterKerberos registers the GSSAPI implementation which is used for the
+// accounts identified with authentication_kerberos.
+func RegisterKerberos(client KerberosClient) {
+	kerberosLock.Lock()
+	defer kerberosLock.Unlock()
+	kerberosClient = client
+}
+
+func registeredKerberos() KerberosClient {
+	kerberosLock.RLock()
+	defer kerberosLock.RUnlock()
+	return kerberosClient
+}
+
+var errKerberosNotRegistered = errors.New("this user requires Kerberos authentication, which requires a client registered with RegisterKerberos")
+
+// kerberosTarget is the service principal the server announced.
+type kerberosTarget struct {
+	spn, realm string
+}
+
+// parseKerberosAuthData parses the auth data of authentication_kerberos:
+//
+//	service principal name length [2 bytes]
+//	service principal name [string]
+//	realm length [2 bytes]
+//	realm [string]
+func parseKerberosAuthData(data []byte) (*kerberosTarget, error) {
+	var fields [2]string
+	for i := range fields {
+		if len(data) < 2 {
+			return nil, ErrMalformPkt
+		}
+		n := int(binary.LittleEndian.Uint16(data))
+		if len(data) < 2+n {
+			return nil, ErrMalformPkt
+		}
+		fields[i] = string(data[2 : 2+n])
+		data = data[2+n:]
+	}
+	return &kerberosTarget{spn: fields[0], realm: fields[1]}, nil
+}
+
+// kerberosAuth returns the first token of authentication_kerberos.
+func (mc *mysqlConn) kerberosAuth(authData []byte) ([]byte, error) {
+	client := registeredKerberos()
+	if client == nil {
+		return nil, errKerberosNotRegistered
+	}
+	target, err := parseKerberosAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	mc.kerberos = target
+	return client.InitSecContext(mc.commandContext(), target.spn, target.realm, nil)
+}
+
+// kerberosContinue returns the token answering a token of the server.
+func (mc *mysqlConn) kerberosContinue(ctx context.Context, cfg *Config, data []byte) ([]byte, error) {
+	client := registeredKerberos()
+	if client == nil || mc.kerberos == nil {
+		return nil, ErrMalformPkt
+	}
+	return client.InitSecContext(ctx, mc.kerberos.spn, mc.kerberos.realm, data)
+}
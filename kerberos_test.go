@@ -0,0 +1,72 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+type fakeKerberos struct{}
+
+func (fakeKerberos) InitSecContext(ctx context.Context, spn, realm string, input []byte) ([]byte, error) {
+	if input == nil {
+		return []byte(spn + "@" + realm), nil
+	}
+	return append([]byte("re:"), input...), nil
+}
+
+func TestParseKerberosAuthData(t *testing.T) {
+	target, err := parseKerberosAuthData([]byte("\x0d\x00mysql/db.corp\x09\x00CORP.TEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.spn != "mysql/db.corp" || target.realm != "CORP.TEST" {
+		t.Errorf("unexpected target %+v", target)
+	}
+	if _, err := parseKerberosAuthData([]byte("\x0d\x00mysql")); err != ErrMalformPkt {
+		t.Errorf("expected ErrMalformPkt, got %v", err)
+	}
+}
+
+func TestAuthSwitchKerberos(t *testing.T) {
+	authData := []byte{123, 87, 15, 84, 20, 58, 37, 121, 91, 117, 51, 24, 19,
+		47, 43, 9, 41, 112, 67, 110}
+	authSwitch := append(append([]byte{254}, "authentication_kerberos_client\x00"...),
+		"\x0d\x00mysql/db.corp\x09\x00CORP.TEST"...)
+	authSwitch = append([]byte{byte(len(authSwitch)), 0, 0, 2}, authSwitch...)
+
+	// no client registered
+	conn, mc := newRWMockConn(2)
+	conn.data = authSwitch
+	conn.maxReads = 1
+	if err := mc.handleAuthResult(authData, "mysql_native_password"); err != errKerberosNotRegistered {
+		t.Errorf("expected errKerberosNotRegistered, got %v", err)
+	}
+
+	RegisterKerberos(fakeKerberos{})
+	defer RegisterKerberos(nil)
+	conn, mc = newRWMockConn(2)
+	conn.data = authSwitch
+	conn.queuedReplies = [][]byte{
+		// token of the server
+		{4, 0, 0, 4, 1, 's', 'r', 'v'},
+		// OK
+		{7, 0, 0, 6, 0, 0, 0, 2, 0, 0, 0},
+	}
+	conn.maxReads = 3
+	if err := mc.handleAuthResult(authData, "mysql_native_password"); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]byte{23, 0, 0, 3}, "mysql/db.corp@CORP.TEST"...), append([]byte{6, 0, 0, 5}, "re:srv"...)...)
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("got unexpected data: %q", conn.written)
+	}
+}
@@ -0,0 +1,68 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels returns the labels of the config of the connection, nil for none.
+func (mc *mysqlConn) Labels() map[string]string {
+	if mc.cfg == nil {
+		return nil
+	}
+	return mc.cfg.Labels
+}
+
+// formatLabels formats labels as "[key=value ...] ", sorted by key, to prefix
+// log messages.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	b.WriteString("] ")
+	return b.String()
+}
+
+// outputLogger is implemented by loggers which report the file and line of
+// the call site, like *log.Logger.
+type outputLogger interface {
+	Output(calldepth int, s string) error
+}
+
+// log prints v with the logger of the driver, prefixed with the labels of the
+// connection. Loggers like *log.Logger report the caller of log as the
+// origin of the message.
+func (mc *mysqlConn) log(v ...interface{}) {
+	if prefix := formatLabels(mc.Labels()); prefix != "" {
+		v = append([]interface{}{prefix}, v...)
+	}
+	if l, ok := errLog.(outputLogger); ok {
+		l.Output(2, fmt.Sprint(v...))
+		return
+	}
+	errLog.Print(v...)
+}
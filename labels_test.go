@@ -0,0 +1,70 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	previous := errLog
+	defer func() {
+		errLog = previous
+	}()
+	var logged bytes.Buffer
+	errLog = log.New(&logged, "", 0)
+
+	_, mc := newRWMockConn(0)
+	mc.cfg.Labels = map[string]string{"tenant": "acme", "pool": "orders", "pod": "web-2"}
+	mc.cfg.ConnectionAttributes = map[string]string{"pod": "web-1"}
+
+	// sent as connection attributes, which take precedence
+	attrs := encodeConnectionAttributes(mc.cfg)
+	if !strings.Contains(attrs, "\x04pool\x06orders") || !strings.Contains(attrs, "\x06tenant\x04acme") {
+		t.Error("connection attributes do not contain the labels")
+	}
+	if !strings.Contains(attrs, "\x03pod\x05web-1") || strings.Contains(attrs, "web-2") {
+		t.Error("connection attributes do not override the labels")
+	}
+
+	// prefix of log messages
+	mc.log("could not kill the query: ", errors.New("timeout"))
+	if out := logged.String(); out != "[pod=web-2 pool=orders tenant=acme] could not kill the query: timeout\n" {
+		t.Errorf("unexpected log message %q", out)
+	}
+
+	// context of errors
+	var perr *ProtocolError
+	if err := mc.malformed([]byte{0xfe}); !errors.As(err, &perr) || perr.Labels["pool"] != "orders" {
+		t.Errorf("expected the labels in the error, got %#v", err)
+	}
+
+	if labels := mc.Labels(); labels["tenant"] != "acme" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+}
+
+func TestLogCallSite(t *testing.T) {
+	previous := errLog
+	defer func() {
+		errLog = previous
+	}()
+	var logged bytes.Buffer
+	errLog = log.New(&logged, "", log.Lshortfile)
+
+	_, mc := newRWMockConn(0)
+	mc.log("message")
+	if out := logged.String(); !strings.HasPrefix(out, "labels_test.go:") {
+		t.Errorf("expected the call site in the log message, got %q", out)
+	}
+}
@@ -10,6 +10,7 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"database/sql/driver"
 	"encoding/binary"
@@ -18,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"time"
 )
 
@@ -26,7 +28,12 @@ import (
 
 // Read packet to buffer 'data'
 func (mc *mysqlConn) readPacket() ([]byte, error) {
-	var prevData []byte
+	if err := mc.startIO(); err != nil {
+		return nil, err
+	}
+
+	var chunks [][]byte
+	var total int
 	for {
 		// read packet header
 		data, err := mc.buf.readNext(4)
@@ -34,7 +41,7 @@ func (mc *mysqlConn) readPacket() ([]byte, error) {
 			if cerr := mc.canceled.Value(); cerr != nil {
 				return nil, cerr
 			}
-			errLog.Print(err)
+			mc.log(err)
 			mc.Close()
 			return nil, ErrInvalidConn
 		}
@@ -43,49 +50,102 @@ func (mc *mysqlConn) readPacket() ([]byte, error) {
 		pktLen := int(uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16)
 
 		// check packet sync [8 bit]
-		if data[3] != mc.sequence {
+		if mc.compress {
+			// the sequence ids of packets in compressed frames are not
+			// checked by the servers, continue from the one received
+			mc.sequence = data[3]
+		} else if data[3] != mc.sequence {
+			perr := &ProtocolError{
+				Err:         ErrPktSync,
+				Command:     mc.command,
+				ExpectedSeq: mc.sequence,
+				ReceivedSeq: data[3],
+				PacketLen:   pktLen,
+				Labels:      mc.Labels(),
+			}
 			if data[3] > mc.sequence {
-				return nil, ErrPktSyncMul
+				perr.Err = ErrPktSyncMul
 			}
-			return nil, ErrPktSync
+			return nil, perr
 		}
 		mc.sequence++
 
+		// refuse packets which are too large before allocating memory
+		if mc.maxReadPacket > 0 && total+pktLen > mc.maxReadPacket {
+			mc.log(ErrPktReadTooLarge)
+			mc.Close()
+			return nil, ErrPktReadTooLarge
+		}
+
 		// packets with length 0 terminate a previous packet which is a
 		// multiple of (2^24)-1 bytes long
 		if pktLen == 0 {
 			// there was no previous packet
-			if prevData == nil {
-				errLog.Print(ErrMalformPkt)
+			if chunks == nil {
+				mc.log(ErrMalformPkt)
 				mc.Close()
 				return nil, ErrInvalidConn
 			}
 
-			return prevData, nil
+			mc.stats.PacketsRead++
+			mc.stats.BytesRead += 4
+			return joinChunks(chunks, total), nil
 		}
 
 		// read packet body [pktLen bytes]
-		data, err = mc.buf.readNext(pktLen)
+		split := chunks != nil || pktLen == maxPacketSize
+		if !split {
+			// zero allocations for non-split packets
+			data, err = mc.buf.readNext(pktLen)
+		} else {
+			// parts of split packets are read into owned slices directly,
+			// so that they are copied only once when they are joined
+			data = make([]byte, pktLen)
+			err = mc.buf.readFull(data)
+		}
 		if err != nil {
 			if cerr := mc.canceled.Value(); cerr != nil {
 				return nil, cerr
 			}
-			errLog.Print(err)
+			mc.log(err)
 			mc.Close()
 			return nil, ErrInvalidConn
 		}
+		mc.stats.PacketsRead++
+		mc.stats.BytesRead += uint64(4 + pktLen)
+
+		if !split {
+			return data, nil
+		}
+
+		chunks = append(chunks, data)
+		total += pktLen
 
 		// return data if this was the last packet
 		if pktLen < maxPacketSize {
-			// zero allocations for non-split packets
-			if prevData == nil {
-				return data, nil
-			}
-
-			return append(prevData, data...), nil
+			return joinChunks(chunks, total), nil
 		}
+	}
+}
 
-		prevData = append(prevData, data...)
+// joinChunks concatenates the parts of a split packet.
+func joinChunks(chunks [][]byte, total int) []byte {
+	if len(chunks) == 1 {
+		return chunks[0]
+	}
+	data := make([]byte, 0, total)
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+	return data
+}
+
+// resetSequence resets the sequence of the packets, and of the frames of the
+// compressed protocol, at the start of a command.
+func (mc *mysqlConn) resetSequence() {
+	mc.sequence = 0
+	if cc, ok := mc.netConn.(*compressedConn); ok {
+		cc.sequence = 0
 	}
 }
 
@@ -97,33 +157,8 @@ func (mc *mysqlConn) writePacket(data []byte) error {
 		return ErrPktTooLarge
 	}
 
-	// Perform a stale connection check. We only perform this check for
-	// the first query on a connection that has been checked out of the
-	// connection pool: a fresh connection from the pool is more likely
-	// to be stale, and it has not performed any previous writes that
-	// could cause data corruption, so it's safe to return ErrBadConn
-	// if the check fails.
-	if mc.reset {
-		mc.reset = false
-		conn := mc.netConn
-		if mc.rawConn != nil {
-			conn = mc.rawConn
-		}
-		var err error
-		// If this connection has a ReadTimeout which we've been setting on
-		// reads, reset it to its default value before we attempt a non-blocking
-		// read, otherwise the scheduler will just time us out before we can read
-		if mc.cfg.ReadTimeout != 0 {
-			err = conn.SetReadDeadline(time.Time{})
-		}
-		if err == nil && mc.cfg.CheckConnLiveness {
-			err = connCheck(conn)
-		}
-		if err != nil {
-			errLog.Print("closing bad idle connection: ", err)
-			mc.Close()
-			return driver.ErrBadConn
-		}
+	if err := mc.startIO(); err != nil {
+		return err
 	}
 
 	for {
@@ -151,6 +186,8 @@ func (mc *mysqlConn) writePacket(data []byte) error {
 		n, err := mc.netConn.Write(data[:4+size])
 		if err == nil && n == 4+size {
 			mc.sequence++
+			mc.stats.PacketsWritten++
+			mc.stats.BytesWritten += uint64(4 + size)
 			if size != maxPacketSize {
 				return nil
 			}
@@ -162,7 +199,7 @@ func (mc *mysqlConn) writePacket(data []byte) error {
 		// Handle error
 		if err == nil { // n != len(data)
 			mc.cleanup()
-			errLog.Print(ErrMalformPkt)
+			mc.log(ErrMalformPkt)
 		} else {
 			if cerr := mc.canceled.Value(); cerr != nil {
 				return cerr
@@ -172,12 +209,113 @@ func (mc *mysqlConn) writePacket(data []byte) error {
 				return errBadConnNoWrite
 			}
 			mc.cleanup()
-			errLog.Print(err)
+			mc.log(err)
 		}
 		return ErrInvalidConn
 	}
 }
 
+// writePacketBuffers writes a packet whose body is head[4:] followed by
+// payload. The first 4 bytes of head are reserved for the packet header.
+// On TCP and unix socket connections both parts are sent with vectored
+// writes (writev), so the payload does not have to be copied behind the
+// header first.
+func (mc *mysqlConn) writePacketBuffers(head, payload []byte) error {
+	if !supportsBuffers(mc.netConn) {
+		data := make([]byte, len(head)+len(payload))
+		copy(data, head)
+		copy(data[len(head):], payload)
+		return mc.writePacket(data)
+	}
+
+	pktLen := len(head) - 4 + len(payload)
+	if pktLen > mc.maxAllowedPacket {
+		return ErrPktTooLarge
+	}
+
+	if err := mc.startIO(); err != nil {
+		return err
+	}
+
+	var header [4]byte
+	body := head[4:]
+	first := true
+	for {
+		size := pktLen
+		if size > maxPacketSize {
+			size = maxPacketSize
+		}
+		header[0] = byte(size)
+		header[1] = byte(size >> 8)
+		header[2] = byte(size >> 16)
+		header[3] = mc.sequence
+
+		// split the next size bytes of the body across head and payload
+		bufs := net.Buffers{header[:]}
+		n := size
+		if len(body) > 0 {
+			m := len(body)
+			if m > n {
+				m = n
+			}
+			bufs = append(bufs, body[:m])
+			body = body[m:]
+			n -= m
+		}
+		if n > 0 {
+			bufs = append(bufs, payload[:n])
+			payload = payload[n:]
+		}
+
+		// Write packet
+		if mc.writeTimeout > 0 {
+			if err := mc.netConn.SetWriteDeadline(time.Now().Add(mc.writeTimeout)); err != nil {
+				return err
+			}
+		}
+
+		written, err := bufs.WriteTo(mc.netConn)
+		if err == nil && written == int64(4+size) {
+			mc.sequence++
+			mc.stats.PacketsWritten++
+			mc.stats.BytesWritten += uint64(4 + size)
+			if size != maxPacketSize {
+				return nil
+			}
+			pktLen -= size
+			first = false
+			continue
+		}
+
+		// Handle error
+		if err == nil { // written != 4+size
+			mc.cleanup()
+			mc.log(ErrMalformPkt)
+		} else {
+			if cerr := mc.canceled.Value(); cerr != nil {
+				return cerr
+			}
+			if written == 0 && first {
+				// only for the first loop iteration when nothing was written yet
+				return errBadConnNoWrite
+			}
+			mc.cleanup()
+			mc.log(err)
+		}
+		return ErrInvalidConn
+	}
+}
+
+// supportsBuffers reports whether net.Buffers are written to conn with a
+// single vectored write instead of one write per buffer.
+func supportsBuffers(conn net.Conn) bool {
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	}
+	return false
+}
+
 /******************************************************************************
 *                           Initialization Process                            *
 ******************************************************************************/
@@ -209,8 +347,21 @@ func (mc *mysqlConn) readHandshakePacket() (data []byte, plugin string, err erro
 	}
 
 	// server version [null terminated string]
+	end := bytes.IndexByte(data[1:], 0x00)
+	if end < 0 {
+		return nil, "", mc.malformed(data)
+	}
+	mc.serverVersion = parseServerVersion(string(data[1 : 1+end]))
+	pos := 1 + end + 1
+
+	// connection id, auth data, filler and capability flags must follow
+	if len(data) < pos+4+8+1+2 {
+		return nil, "", mc.malformed(data)
+	}
+
 	// connection id [4 bytes]
-	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1 + 4
+	mc.connectionID = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
 
 	// first part of the password cipher [8 bytes]
 	authData := data[pos : pos+8]
@@ -233,12 +384,29 @@ func (mc *mysqlConn) readHandshakePacket() (data []byte, plugin string, err erro
 	pos += 2
 
 	if len(data) > pos {
+		// everything up to the second part of the password cipher is required
+		if len(data) < pos+1+2+2+1+10+12 {
+			return nil, "", mc.malformed(data)
+		}
+
 		// character set [1 byte]
+		mc.serverCollation = data[pos]
+
 		// status flags [2 bytes]
+		pos += 1 + 2
+
 		// capability flags (upper 2 bytes) [2 bytes]
+		mc.flags |= clientFlag(binary.LittleEndian.Uint16(data[pos:pos+2])) << 16
+		pos += 2
+
 		// length of auth-plugin-data [1 byte]
-		// reserved (all [00]) [10 bytes]
-		pos += 1 + 2 + 2 + 1 + 10
+		// reserved (all [00]) [6 bytes]
+		// MariaDB extended capability flags [4 bytes], reserved for other
+		// servers
+		if mc.mariadbExtended() {
+			mc.mariadbFlags = mariadbFlag(binary.LittleEndian.Uint32(data[pos+7 : pos+11]))
+		}
+		pos += 1 + 10
 
 		// second part of the password cipher [mininum 13 bytes],
 		// where len=MAX(13, length of auth-plugin-data - 8)
@@ -257,10 +425,12 @@ func (mc *mysqlConn) readHandshakePacket() (data []byte, plugin string, err erro
 
 		// EOF if version (>= 5.5.7 and < 5.5.10) or (>= 5.6.0 and < 5.6.2)
 		// \NUL otherwise
-		if end := bytes.IndexByte(data[pos:], 0x00); end != -1 {
-			plugin = string(data[pos : pos+end])
-		} else {
-			plugin = string(data[pos:])
+		if pos < len(data) {
+			if end := bytes.IndexByte(data[pos:], 0x00); end != -1 {
+				plugin = string(data[pos : pos+end])
+			} else {
+				plugin = string(data[pos:])
+			}
 		}
 
 		// make a memory safe copy of the cipher slice
@@ -286,7 +456,12 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 		clientLocalFiles |
 		clientPluginAuth |
 		clientMultiResults |
-		mc.flags&clientLongFlag
+		mc.flags&clientPSMultiResults |
+		mc.flags&clientLongFlag |
+		mc.flags&clientConnectAttrs |
+		mc.flags&clientSessionTrack |
+		mc.flags&clientQueryAttributes |
+		mc.flags&clientDeprecateEOF
 
 	if mc.cfg.ClientFoundRows {
 		clientFlags |= clientFoundRows
@@ -301,6 +476,27 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 		clientFlags |= clientMultiStatements
 	}
 
+	// zstd is only requested if the server supports it, otherwise the
+	// connection falls back to zlib; the server would prefer zlib if both
+	// were requested
+	if mc.cfg.Compress {
+		if mc.cfg.CompressionAlgorithm == "zstd" && mc.flags&clientZstdCompressionAlgorithm != 0 {
+			if registeredZstd() == nil {
+				return errZstdNotRegistered
+			}
+			clientFlags |= clientZstdCompressionAlgorithm
+		} else {
+			clientFlags |= mc.flags & clientCompress
+		}
+	}
+
+	// MariaDB reads extended capabilities instead of a part of the filler
+	// if clientLongPassword (CLIENT_MYSQL) is not set
+	mariadb := mc.mariadbExtended()
+	if mariadb {
+		clientFlags &^= clientLongPassword
+	}
+
 	// encode length of the auth plugin data
 	var authRespLEIBuf [9]byte
 	authRespLen := len(authResp)
@@ -311,7 +507,7 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 		clientFlags |= clientPluginAuthLenEncClientData
 	}
 
-	pktLen := 4 + 4 + 1 + 23 + len(mc.cfg.User) + 1 + len(authRespLEI) + len(authResp) + 21 + 1
+	pktLen := 4 + 4 + 1 + 23 + len(mc.cfg.User) + 1 + len(authRespLEI) + len(authResp) + len(plugin) + 1
 
 	// To specify a db name
 	if n := len(mc.cfg.DBName); n > 0 {
@@ -319,15 +515,33 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 		pktLen += n + 1
 	}
 
-	// Calculate packet length and get buffer with that size
-	data, err := mc.buf.takeSmallBuffer(pktLen + 4)
+	// encode length of the connection attributes
+	var connAttrsLEIBuf [9]byte
+	var connAttrs string
+	if clientFlags&clientConnectAttrs != 0 && mc.connector != nil {
+		connAttrs = mc.connector.encodedAttributes
+	}
+	connAttrsLEI := appendLengthEncodedInteger(connAttrsLEIBuf[:0], uint64(len(connAttrs)))
+	if clientFlags&clientConnectAttrs != 0 {
+		pktLen += len(connAttrsLEI) + len(connAttrs)
+	}
+	if clientFlags&clientZstdCompressionAlgorithm != 0 {
+		pktLen++
+	}
+
+	// Calculate packet length and get buffer with that size.
+	// The packet may exceed the default buffer size when many or long
+	// connection attributes are sent, so the buffer is allowed to grow.
+	data, err := mc.buf.takeBuffer(pktLen + 4)
 	if err != nil {
 		// cannot take the buffer. Something must be wrong with the connection
-		errLog.Print(err)
+		mc.log(err)
 		return errBadConnNoWrite
 	}
 
 	// ClientFlags [32 bit]
+	mc.clientFlags = clientFlags & mc.flags
+
 	data[4] = byte(clientFlags)
 	data[5] = byte(clientFlags >> 8)
 	data[6] = byte(clientFlags >> 16)
@@ -340,14 +554,21 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 	data[11] = 0x00
 
 	// Charset [1 byte]
-	var found bool
-	data[12], found = collations[mc.cfg.Collation]
-	if !found {
-		// Note possibility for false negatives:
-		// could be triggered  although the collation is valid if the
-		// collations map does not contain entries the server supports.
-		return errors.New("unknown collation")
+	if mc.cfg.ServerCollation && mc.serverCollation != 0 {
+		data[12] = mc.serverCollation
+		mc.collation = collationName(data[12])
+	} else {
+		var found bool
+		data[12], found = collations[mc.cfg.Collation]
+		if !found {
+			// Note possibility for false negatives:
+			// could be triggered  although the collation is valid if the
+			// collations map does not contain entries the server supports.
+			return errors.New("unknown collation")
+		}
+		mc.collation = mc.cfg.Collation
 	}
+	mc.collationID = data[12]
 
 	// Filler [23 bytes] (all 0x00)
 	pos := 13
@@ -355,6 +576,13 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 		data[pos] = 0
 	}
 
+	// MariaDB extended capability flags [4 bytes] in place of the end of
+	// the filler
+	if mariadb {
+		mc.mariadbFlags &= mariadbClientFlags
+		binary.LittleEndian.PutUint32(data[13+19:], uint32(mc.mariadbFlags))
+	}
+
 	// SSL Connection Request Packet
 	// http://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::SSLRequest
 	if mc.cfg.tls != nil {
@@ -365,7 +593,7 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 
 		// Switch to TLS
 		tlsConn := tls.Client(mc.netConn, mc.cfg.tls)
-		if err := tlsConn.Handshake(); err != nil {
+		if err := mc.tlsHandshake(tlsConn); err != nil {
 			return err
 		}
 		mc.rawConn = mc.netConn
@@ -395,17 +623,51 @@ func (mc *mysqlConn) writeHandshakeResponsePacket(authResp []byte, plugin string
 	data[pos] = 0x00
 	pos++
 
+	// Connection Attributes [length encoded string of key-value pairs]
+	if clientFlags&clientConnectAttrs != 0 {
+		pos += copy(data[pos:], connAttrsLEI)
+		pos += copy(data[pos:], connAttrs)
+	}
+
+	// zstd compression level [1 byte]
+	if clientFlags&clientZstdCompressionAlgorithm != 0 {
+		data[pos] = byte(mc.cfg.zstdLevel())
+		pos++
+	}
+
 	// Send Auth packet
 	return mc.writePacket(data[:pos])
 }
 
+// tlsHandshake runs the TLS handshake on the connection, within
+// mc.cfg.TLSTimeout if set. The context of the caller still applies, as it
+// closes the connection when it is done.
+func (mc *mysqlConn) tlsHandshake(tlsConn *tls.Conn) error {
+	timeout := mc.cfg.TLSTimeout
+	if timeout <= 0 {
+		return tlsConn.Handshake()
+	}
+	if err := mc.netConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	err := tlsConn.Handshake()
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return &ConnectTimeoutError{Phase: "tls", Timeout: timeout, Err: err, Labels: mc.Labels()}
+	}
+	if err != nil {
+		return err
+	}
+	// the read and write timeouts set their own deadlines
+	return mc.netConn.SetDeadline(time.Time{})
+}
+
 // http://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::AuthSwitchResponse
 func (mc *mysqlConn) writeAuthSwitchPacket(authData []byte) error {
 	pktLen := 4 + len(authData)
 	data, err := mc.buf.takeSmallBuffer(pktLen)
 	if err != nil {
 		// cannot take the buffer. Something must be wrong with the connection
-		errLog.Print(err)
+		mc.log(err)
 		return errBadConnNoWrite
 	}
 
@@ -418,14 +680,82 @@ func (mc *mysqlConn) writeAuthSwitchPacket(authData []byte) error {
 *                             Command Packets                                 *
 ******************************************************************************/
 
+// Change User Packet
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_change_user.html
+func (mc *mysqlConn) writeChangeUserPacket(authResp []byte, plugin string) error {
+	// the length of the auth data is a single byte
+	if len(authResp) > 255 {
+		return fmt.Errorf("auth data of %s is too long for COM_CHANGE_USER: %d bytes", plugin, len(authResp))
+	}
+
+	// Reset Packet Sequence
+	mc.resetSequence()
+	mc.command = comChangeUser
+	mc.stats.Commands++
+
+	var connAttrs string
+	if mc.clientFlags&clientConnectAttrs != 0 && mc.connector != nil {
+		connAttrs = mc.connector.encodedAttributes
+	}
+	pktLen := 1 + len(mc.cfg.User) + 1 + 1 + len(authResp) + len(mc.cfg.DBName) + 1 + 2 + len(plugin) + 1
+	if mc.clientFlags&clientConnectAttrs != 0 {
+		pktLen += lengthEncodedIntegerLen(uint64(len(connAttrs))) + len(connAttrs)
+	}
+	data, err := mc.buf.takeBuffer(pktLen + 4)
+	if err != nil {
+		// cannot take the buffer. Something must be wrong with the connection
+		mc.log(err)
+		return errBadConnNoWrite
+	}
+
+	// Add command byte
+	data[4] = comChangeUser
+	pos := 5
+
+	// User [null terminated string]
+	pos += copy(data[pos:], mc.cfg.User)
+	data[pos] = 0x00
+	pos++
+
+	// Auth Data [1 byte length + data]
+	data[pos] = byte(len(authResp))
+	pos++
+	pos += copy(data[pos:], authResp)
+
+	// Databasename [null terminated string]
+	pos += copy(data[pos:], mc.cfg.DBName)
+	data[pos] = 0x00
+	pos++
+
+	// Charset [2 bytes]
+	data[pos] = mc.collationID
+	data[pos+1] = 0x00
+	pos += 2
+
+	// Auth plugin [null terminated string]
+	pos += copy(data[pos:], plugin)
+	data[pos] = 0x00
+	pos++
+
+	// Connection Attributes [length encoded string of key-value pairs]
+	if mc.clientFlags&clientConnectAttrs != 0 {
+		pos += len(appendLengthEncodedInteger(data[pos:pos], uint64(len(connAttrs))))
+		pos += copy(data[pos:], connAttrs)
+	}
+
+	return mc.writePacket(data[:pos])
+}
+
 func (mc *mysqlConn) writeCommandPacket(command byte) error {
 	// Reset Packet Sequence
-	mc.sequence = 0
+	mc.resetSequence()
+	mc.command = command
+	mc.stats.Commands++
 
 	data, err := mc.buf.takeSmallBuffer(4 + 1)
 	if err != nil {
 		// cannot take the buffer. Something must be wrong with the connection
-		errLog.Print(err)
+		mc.log(err)
 		return errBadConnNoWrite
 	}
 
@@ -436,15 +766,34 @@ func (mc *mysqlConn) writeCommandPacket(command byte) error {
 	return mc.writePacket(data)
 }
 
+// writeQueryPacket sends COM_QUERY. Every query carries the query
+// attributes, if they are negotiated.
+func (mc *mysqlConn) writeQueryPacket(query string) error {
+	var attrs []byte
+	if mc.clientFlags&clientQueryAttributes != 0 {
+		attrs = appendQueryAttributes(nil, mc.queryAttrs)
+		mc.queryAttrs = nil
+	}
+	return mc.writeCommandPacketAttrs(comQuery, attrs, query)
+}
+
 func (mc *mysqlConn) writeCommandPacketStr(command byte, arg string) error {
+	return mc.writeCommandPacketAttrs(command, nil, arg)
+}
+
+// writeCommandPacketAttrs sends a command whose arg is preceded by the
+// encoded query attributes attrs.
+func (mc *mysqlConn) writeCommandPacketAttrs(command byte, attrs []byte, arg string) error {
 	// Reset Packet Sequence
-	mc.sequence = 0
+	mc.resetSequence()
+	mc.command = command
+	mc.stats.Commands++
 
-	pktLen := 1 + len(arg)
+	pktLen := 1 + len(attrs) + len(arg)
 	data, err := mc.buf.takeBuffer(pktLen + 4)
 	if err != nil {
 		// cannot take the buffer. Something must be wrong with the connection
-		errLog.Print(err)
+		mc.log(err)
 		return errBadConnNoWrite
 	}
 
@@ -452,7 +801,8 @@ func (mc *mysqlConn) writeCommandPacketStr(command byte, arg string) error {
 	data[4] = command
 
 	// Add arg
-	copy(data[5:], arg)
+	copy(data[5:], attrs)
+	copy(data[5+len(attrs):], arg)
 
 	// Send CMD packet
 	return mc.writePacket(data)
@@ -460,12 +810,14 @@ func (mc *mysqlConn) writeCommandPacketStr(command byte, arg string) error {
 
 func (mc *mysqlConn) writeCommandPacketUint32(command byte, arg uint32) error {
 	// Reset Packet Sequence
-	mc.sequence = 0
+	mc.resetSequence()
+	mc.command = command
+	mc.stats.Commands++
 
 	data, err := mc.buf.takeSmallBuffer(4 + 1 + 4)
 	if err != nil {
 		// cannot take the buffer. Something must be wrong with the connection
-		errLog.Print(err)
+		mc.log(err)
 		return errBadConnNoWrite
 	}
 
@@ -508,7 +860,7 @@ func (mc *mysqlConn) readAuthResult() ([]byte, string, error) {
 		}
 		pluginEndIndex := bytes.IndexByte(data, 0x00)
 		if pluginEndIndex < 0 {
-			return nil, "", ErrMalformPkt
+			return nil, "", mc.malformed(data)
 		}
 		plugin := string(data[1:pluginEndIndex])
 		authData := data[pluginEndIndex+1:]
@@ -552,10 +904,18 @@ func (mc *mysqlConn) readResultSetHeaderPacket() (int, error) {
 		// column count
 		num, _, n := readLengthEncodedInteger(data)
 		if n-len(data) == 0 {
+			mc.skipMetadata = false
 			return int(num), nil
 		}
 
-		return 0, ErrMalformPkt
+		// metadata follows [1 byte], if MariaDB caches the columns of
+		// prepared statements
+		if mc.mariadbFlags&mariadbClientCacheMetadata != 0 && n+1 == len(data) {
+			mc.skipMetadata = data[n] == 0
+			return int(num), nil
+		}
+
+		return 0, mc.malformed(data)
 	}
 	return 0, err
 }
@@ -563,8 +923,8 @@ func (mc *mysqlConn) readResultSetHeaderPacket() (int, error) {
 // Error Packet
 // http://dev.mysql.com/doc/internals/en/generic-response-packets.html#packet-ERR_Packet
 func (mc *mysqlConn) handleErrorPacket(data []byte) error {
-	if data[0] != iERR {
-		return ErrMalformPkt
+	if data[0] != iERR || len(data) < 3 {
+		return mc.malformed(data)
 	}
 
 	// 0xff [1 byte]
@@ -591,7 +951,7 @@ func (mc *mysqlConn) handleErrorPacket(data []byte) error {
 	pos := 3
 
 	// SQL State [optional: # + 5bytes string]
-	if data[3] == 0x23 {
+	if len(data) >= 9 && data[3] == 0x23 {
 		//sqlstate := string(data[4 : 4+5])
 		pos = 9
 	}
@@ -616,27 +976,72 @@ func (mc *mysqlConn) handleOkPacket(data []byte) error {
 
 	// Affected rows [Length Coded Binary]
 	mc.affectedRows, _, n = readLengthEncodedInteger(data[1:])
+	if 1+n > len(data) {
+		return mc.malformed(data)
+	}
 
 	// Insert id [Length Coded Binary]
 	mc.insertId, _, m = readLengthEncodedInteger(data[1+n:])
+	if 1+n+m > len(data) {
+		return mc.malformed(data)
+	}
 
 	// server_status [2 bytes]
-	mc.status = readStatus(data[1+n+m : 1+n+m+2])
-	if mc.status&statusMoreResultsExists != 0 {
-		return nil
+	// Some servers and proxies omit the trailing fields, see issue #349.
+	mc.status = 0
+	if len(data) >= 1+n+m+2 {
+		mc.status = readStatus(data[1+n+m : 1+n+m+2])
 	}
 
 	// warning count [2 bytes]
+	mc.warnings = 0
+	if len(data) >= 1+n+m+4 {
+		mc.warnings = binary.LittleEndian.Uint16(data[1+n+m+2 : 1+n+m+4])
+	}
+
+	// info [length encoded string]
+	// session state changes [length encoded string]
+	if mc.clientFlags&clientSessionTrack != 0 && mc.status&statusSessionStateChanged != 0 {
+		pos := 1 + n + m + 4
+		if pos > len(data) {
+			pos = len(data) // truncated like above; there is no state
+		}
+		state, err := readSessionState(data[pos:])
+		if err != nil {
+			// the cached variables may be stale
+			mc.sessionVars = nil
+		} else {
+			mc.handleSessionState(state)
+		}
+	}
 
 	return nil
 }
 
 // Read Packets as Field Packets until EOF-Packet or an Error appears
 // http://dev.mysql.com/doc/internals/en/com-query-response.html#packet-Protocol::ColumnDefinition41
+//
+// Only one result set can be active on a connection, so the returned slice is
+// reused by the next call. Column names which did not change since the
+// previous result set are kept without allocating new strings.
 func (mc *mysqlConn) readColumns(count int) ([]mysqlField, error) {
-	columns := make([]mysqlField, count)
+	if cap(mc.fields) < count {
+		mc.fields = make([]mysqlField, count)
+	}
+	return mc.readColumnsInto(mc.fields[:count])
+}
 
+// readColumnsInto reads the column definitions into columns, whose length
+// is the column count. The strings of the previous definitions are kept if
+// they did not change, so reading the same columns again does not allocate.
+func (mc *mysqlConn) readColumnsInto(columns []mysqlField) ([]mysqlField, error) {
+	count := len(columns)
 	for i := 0; ; i++ {
+		// no EOF packet follows the columns with DEPRECATE_EOF
+		if i == count && mc.clientFlags&clientDeprecateEOF != 0 {
+			return columns, nil
+		}
+
 		data, err := mc.readPacket()
 		if err != nil {
 			return nil, err
@@ -649,17 +1054,20 @@ func (mc *mysqlConn) readColumns(count int) ([]mysqlField, error) {
 			}
 			return nil, fmt.Errorf("column count mismatch n:%d len:%d", count, len(columns))
 		}
+		if i >= count {
+			return nil, mc.malformed(data)
+		}
 
 		// Catalog
 		pos, err := skipLengthEncodedString(data)
 		if err != nil {
-			return nil, err
+			return nil, mc.malformed(data)
 		}
 
 		// Database [len coded string]
 		n, err := skipLengthEncodedString(data[pos:])
 		if err != nil {
-			return nil, err
+			return nil, mc.malformed(data)
 		}
 		pos += n
 
@@ -667,14 +1075,16 @@ func (mc *mysqlConn) readColumns(count int) ([]mysqlField, error) {
 		if mc.cfg.ColumnsWithAlias {
 			tableName, _, n, err := readLengthEncodedString(data[pos:])
 			if err != nil {
-				return nil, err
+				return nil, mc.malformed(data)
 			}
 			pos += n
-			columns[i].tableName = string(tableName)
+			if string(tableName) != columns[i].tableName {
+				columns[i].tableName = string(tableName)
+			}
 		} else {
 			n, err = skipLengthEncodedString(data[pos:])
 			if err != nil {
-				return nil, err
+				return nil, mc.malformed(data)
 			}
 			pos += n
 		}
@@ -682,25 +1092,32 @@ func (mc *mysqlConn) readColumns(count int) ([]mysqlField, error) {
 		// Original table [len coded string]
 		n, err = skipLengthEncodedString(data[pos:])
 		if err != nil {
-			return nil, err
+			return nil, mc.malformed(data)
 		}
 		pos += n
 
 		// Name [len coded string]
 		name, _, n, err := readLengthEncodedString(data[pos:])
 		if err != nil {
-			return nil, err
+			return nil, mc.malformed(data)
+		}
+		if string(name) != columns[i].name {
+			columns[i].name = string(name)
 		}
-		columns[i].name = string(name)
 		pos += n
 
 		// Original name [len coded string]
 		n, err = skipLengthEncodedString(data[pos:])
 		if err != nil {
-			return nil, err
+			return nil, mc.malformed(data)
 		}
 		pos += n
 
+		// The fixed-length fields must follow
+		if len(data) < pos+11 {
+			return nil, mc.malformed(data)
+		}
+
 		// Filler [uint8]
 		pos++
 
@@ -722,6 +1139,10 @@ func (mc *mysqlConn) readColumns(count int) ([]mysqlField, error) {
 
 		// Decimals [uint8]
 		columns[i].decimals = data[pos]
+
+		columns[i].decoder = columns[i].getDecoder()
+		columns[i].asFloat = mc.cfg.DecimalAsFloat && columns[i].decoder == nil &&
+			(columns[i].fieldType == fieldTypeDecimal || columns[i].fieldType == fieldTypeNewDecimal)
 		//pos++
 
 		// Default value [len coded binary]
@@ -746,9 +1167,10 @@ func (rows *textRows) readRow(dest []driver.Value) error {
 	}
 
 	// EOF Packet
-	if data[0] == iEOF && len(data) == 5 {
-		// server_status [2 bytes]
-		rows.mc.status = readStatus(data[3:])
+	if mc.isEOFPacket(data) {
+		if err := mc.handleEOFPacket(data); err != nil {
+			return err
+		}
 		rows.rs.done = true
 		if !rows.HasNextResultSet() {
 			rows.mc = nil
@@ -759,6 +1181,7 @@ func (rows *textRows) readRow(dest []driver.Value) error {
 		rows.mc = nil
 		return mc.handleErrorPacket(data)
 	}
+	mc.stats.Rows++
 
 	// RowSet Packet
 	var n int
@@ -768,10 +1191,23 @@ func (rows *textRows) readRow(dest []driver.Value) error {
 	for i := range dest {
 		// Read bytes and convert to string
 		dest[i], isNull, n, err = readLengthEncodedString(data[pos:])
+		if err != nil {
+			return mc.malformed(data)
+		}
 		pos += n
 		if err == nil {
 			if !isNull {
-				if !mc.parseTime {
+				if dec := rows.rs.columns[i].decoder; dec != nil {
+					dest[i], err = dec(dest[i].([]byte))
+					if err == nil {
+						continue
+					}
+				} else if rows.rs.columns[i].asFloat {
+					dest[i], err = parseDecimal(dest[i].([]byte))
+					if err == nil {
+						continue
+					}
+				} else if !mc.parseTime {
 					continue
 				} else {
 					switch rows.rs.columns[i].fieldType {
@@ -800,6 +1236,130 @@ func (rows *textRows) readRow(dest []driver.Value) error {
 	return nil
 }
 
+// readRowView reads the next row like readRow, but stores the values as
+// slices of the read buffer.
+func (rows *textRows) readRowView(values [][]byte) error {
+	mc := rows.mc
+
+	if rows.rs.done {
+		return io.EOF
+	}
+
+	data, err := mc.readPacket()
+	if err != nil {
+		return err
+	}
+
+	// EOF Packet
+	if mc.isEOFPacket(data) {
+		if err := mc.handleEOFPacket(data); err != nil {
+			return err
+		}
+		rows.rs.done = true
+		if !rows.HasNextResultSet() {
+			rows.mc = nil
+		}
+		return io.EOF
+	}
+	if data[0] == iERR {
+		rows.mc = nil
+		return mc.handleErrorPacket(data)
+	}
+	mc.stats.Rows++
+
+	// RowSet Packet
+	pos := 0
+	for i := range values {
+		v, isNull, n, err := readLengthEncodedString(data[pos:])
+		if err != nil {
+			return mc.malformed(data)
+		}
+		pos += n
+		if isNull {
+			v = nil
+		}
+		values[i] = v
+	}
+	return nil
+}
+
+// defaultKillTimeout limits the time to kill a query which exceeded the limit
+// of WithMaxRows if neither DrainTimeout nor Timeout is set.
+const defaultKillTimeout = 10 * time.Second
+
+// drainRows reads the unread rows of the current result set until EOF. If
+// this takes longer than DrainTimeout, the query is killed through another
+// connection within another DrainTimeout, and the connection is closed if
+// that fails.
+func (mc *mysqlConn) drainRows() error {
+	timeout := mc.cfg.DrainTimeout
+	if timeout <= 0 {
+		return mc.readUntilEOF()
+	}
+	return mc.killAndDrain(timeout, timeout, ErrDrainTimeout)
+}
+
+// discardRows kills the query whose result set exceeded the limit of
+// WithMaxRows, so the server stops sending its rows, and reads the rows which
+// were sent until then. The query must be killed within DrainTimeout, or the
+// connect timeout, otherwise the connection is closed.
+func (mc *mysqlConn) discardRows() error {
+	timeout := mc.cfg.DrainTimeout
+	if timeout <= 0 {
+		timeout = mc.cfg.Timeout
+	}
+	if timeout <= 0 {
+		timeout = defaultKillTimeout
+	}
+	return mc.killAndDrain(0, timeout, ErrInvalidConn)
+}
+
+// killAndDrain reads the unread rows of the current result set until EOF.
+// The query is killed through another connection after delay, unless the
+// rows have been read by then, or right away if delay is 0. If it can't be
+// killed within timeout, the connection is canceled with cause.
+func (mc *mysqlConn) killAndDrain(delay, timeout time.Duration, cause error) error {
+	killed := make(chan error, 1)
+	kill := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err := mc.killQuery(ctx)
+		if err != nil {
+			mc.log("could not kill the query: ", err)
+			mc.cancel(cause)
+		}
+		killed <- err
+	}
+	var timer *time.Timer
+	if delay > 0 {
+		timer = time.AfterFunc(delay, kill)
+	} else {
+		go kill()
+	}
+	err := mc.readUntilEOF()
+	if timer != nil && timer.Stop() {
+		return err
+	}
+
+	// Wait for the kill, so that it can't hit the next query.
+	select {
+	case kerr := <-killed:
+		if kerr != nil {
+			return cause
+		}
+	case <-time.After(timeout):
+		// the kill is stuck and may still hit the next query
+		mc.log("could not kill the query: ", context.DeadlineExceeded)
+		mc.cancel(cause)
+		return cause
+	}
+	// ER_QUERY_INTERRUPTED terminates the killed result set
+	if merr, ok := err.(*MySQLError); ok && merr.Number == 1317 {
+		err = nil
+	}
+	return err
+}
+
 // Reads Packets until EOF-Packet or an Error appears. Returns count of Packets read
 func (mc *mysqlConn) readUntilEOF() error {
 	for {
@@ -808,18 +1368,58 @@ func (mc *mysqlConn) readUntilEOF() error {
 			return err
 		}
 
-		switch data[0] {
-		case iERR:
+		switch {
+		case data[0] == iERR:
 			return mc.handleErrorPacket(data)
-		case iEOF:
-			if len(data) == 5 {
-				mc.status = readStatus(data[3:])
-			}
+		case mc.isEOFPacket(data):
+			return mc.handleEOFPacket(data)
+		case data[0] == iEOF && len(data) == 1:
+			// EOF of servers before 4.1
 			return nil
 		}
 	}
 }
 
+// skipColumns skips the column definitions of a result set, or the
+// parameter definitions of a prepared statement, and the EOF packet which
+// follows them unless DEPRECATE_EOF is negotiated.
+func (mc *mysqlConn) skipColumns(count int) error {
+	if mc.clientFlags&clientDeprecateEOF == 0 {
+		return mc.readUntilEOF()
+	}
+	for i := 0; i < count; i++ {
+		if _, err := mc.readPacket(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isEOFPacket reports whether data terminates the rows of a result set. With
+// DEPRECATE_EOF this is an OK packet with the EOF header, which can be longer
+// than an EOF packet, but not as long as a row starting with 0xfe, whose
+// first value is at least 16MB.
+func (mc *mysqlConn) isEOFPacket(data []byte) bool {
+	if data[0] != iEOF {
+		return false
+	}
+	if mc.clientFlags&clientDeprecateEOF != 0 {
+		return len(data) < maxPacketSize
+	}
+	return len(data) == 5
+}
+
+// handleEOFPacket reads the status of the packet terminating the rows of a
+// result set.
+func (mc *mysqlConn) handleEOFPacket(data []byte) error {
+	if mc.clientFlags&clientDeprecateEOF != 0 {
+		return mc.handleOkPacket(data)
+	}
+	// server_status [2 bytes]
+	mc.status = readStatus(data[3:])
+	return nil
+}
+
 /******************************************************************************
 *                           Prepared Statements                               *
 ******************************************************************************/
@@ -833,6 +1433,9 @@ func (stmt *mysqlStmt) readPrepareResultPacket() (uint16, error) {
 		if data[0] != iOK {
 			return 0, stmt.mc.handleErrorPacket(data)
 		}
+		if len(data) < 9 {
+			return 0, stmt.mc.malformed(data)
+		}
 
 		// statement id [4 bytes]
 		stmt.id = binary.LittleEndian.Uint32(data[1:5])
@@ -855,7 +1458,6 @@ func (stmt *mysqlStmt) readPrepareResultPacket() (uint16, error) {
 // http://dev.mysql.com/doc/internals/en/com-stmt-send-long-data.html
 func (stmt *mysqlStmt) writeCommandLongData(paramID int, arg []byte) error {
 	maxLen := stmt.mc.maxAllowedPacket - 1
-	pktLen := maxLen
 
 	// After the header (bytes 0-3) follows before the data:
 	// 1 byte command
@@ -863,44 +1465,39 @@ func (stmt *mysqlStmt) writeCommandLongData(paramID int, arg []byte) error {
 	// 2 bytes paramID
 	const dataOffset = 1 + 4 + 2
 
-	// Cannot use the write buffer since
-	// a) the buffer is too small
-	// b) it is in use
-	data := make([]byte, 4+1+4+2+len(arg))
+	// The argument is sent behind this header as it is, the write buffer is
+	// neither large enough nor free.
+	var head [4 + dataOffset]byte
 
-	copy(data[4+dataOffset:], arg)
-
-	for argLen := len(arg); argLen > 0; argLen -= pktLen - dataOffset {
-		if dataOffset+argLen < maxLen {
-			pktLen = dataOffset + argLen
+	for len(arg) > 0 {
+		n := len(arg)
+		if n > maxLen-dataOffset {
+			n = maxLen - dataOffset
 		}
 
-		stmt.mc.sequence = 0
+		stmt.mc.resetSequence()
 		// Add command byte [1 byte]
-		data[4] = comStmtSendLongData
+		head[4] = comStmtSendLongData
 
 		// Add stmtID [32 bit]
-		data[5] = byte(stmt.id)
-		data[6] = byte(stmt.id >> 8)
-		data[7] = byte(stmt.id >> 16)
-		data[8] = byte(stmt.id >> 24)
+		head[5] = byte(stmt.id)
+		head[6] = byte(stmt.id >> 8)
+		head[7] = byte(stmt.id >> 16)
+		head[8] = byte(stmt.id >> 24)
 
 		// Add paramID [16 bit]
-		data[9] = byte(paramID)
-		data[10] = byte(paramID >> 8)
+		head[9] = byte(paramID)
+		head[10] = byte(paramID >> 8)
 
 		// Send CMD packet
-		err := stmt.mc.writePacket(data[:4+pktLen])
-		if err == nil {
-			data = data[pktLen-dataOffset:]
-			continue
+		if err := stmt.mc.writePacketBuffers(head[:], arg[:n]); err != nil {
+			return err
 		}
-		return err
-
+		arg = arg[n:]
 	}
 
 	// Reset Packet Sequence
-	stmt.mc.sequence = 0
+	stmt.mc.resetSequence()
 	return nil
 }
 
@@ -918,6 +1515,17 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 	const minPktLen = 4 + 1 + 4 + 1 + 4
 	mc := stmt.mc
 
+	// The parameters are followed by the query attributes, whose names
+	// are sent with the types of all parameters if they are negotiated.
+	queryAttrs := mc.clientFlags&clientQueryAttributes != 0
+	attrs := mc.queryAttrs
+	mc.queryAttrs = nil
+	params := len(args) + len(attrs)
+	typeLen := 2
+	if queryAttrs {
+		typeLen = 3 // the empty name of the parameters
+	}
+
 	// Determine threshold dynamically to avoid packet size shortage.
 	longDataSize := mc.maxAllowedPacket / (stmt.paramCount + 1)
 	if longDataSize < 64 {
@@ -925,12 +1533,14 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 	}
 
 	// Reset packet-sequence
-	mc.sequence = 0
+	mc.resetSequence()
+	mc.command = comStmtExecute
+	mc.stats.Commands++
 
 	var data []byte
 	var err error
 
-	if len(args) == 0 {
+	if params == 0 {
 		data, err = mc.buf.takeBuffer(minPktLen)
 	} else {
 		data, err = mc.buf.takeCompleteBuffer()
@@ -938,7 +1548,7 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 	}
 	if err != nil {
 		// cannot take the buffer. Something must be wrong with the connection
-		errLog.Print(err)
+		mc.log(err)
 		return errBadConnNoWrite
 	}
 
@@ -960,11 +1570,17 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 	data[12] = 0x00
 	data[13] = 0x00
 
-	if len(args) > 0 {
+	if params > 0 {
 		pos := minPktLen
 
+		// parameter count [length encoded integer]
+		if queryAttrs {
+			data[9] |= paramCountAvailable
+			pos += copy(data[pos:], appendLengthEncodedInteger(nil, uint64(params)))
+		}
+
 		var nullMask []byte
-		if maskLen, typesLen := (len(args)+7)/8, 1+2*len(args); pos+maskLen+typesLen >= cap(data) {
+		if maskLen, typesLen := (params+7)/8, 1+typeLen*len(args)+queryAttributeTypesLen(attrs); pos+maskLen+typesLen >= cap(data) {
 			// buffer has to be extended but we don't know by how much so
 			// we depend on append after all data with known sizes fit.
 			// We stop at that because we deal with a lot of columns here
@@ -987,9 +1603,16 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 		data[pos] = 0x01
 		pos++
 
-		// type of each parameter [len(args)*2 bytes]
+		// type of each parameter [len(args)*2 bytes], followed by the
+		// names if query attributes are negotiated
 		paramTypes := data[pos:]
-		pos += len(args) * 2
+		pos += len(args) * typeLen
+		if queryAttrs {
+			for i := range args {
+				paramTypes[i*typeLen+2] = 0
+			}
+			pos += putQueryAttributeTypes(data[pos:], nullMask, len(args), attrs)
+		}
 
 		// value of each parameter [n bytes]
 		paramValues := data[pos:pos]
@@ -999,8 +1622,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 			// build NULL-bitmap
 			if arg == nil {
 				nullMask[i/8] |= 1 << (uint(i) & 7)
-				paramTypes[i+i] = byte(fieldTypeNULL)
-				paramTypes[i+i+1] = 0x00
+				paramTypes[i*typeLen] = byte(fieldTypeNULL)
+				paramTypes[i*typeLen+1] = 0x00
 				continue
 			}
 
@@ -1010,8 +1633,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 			// cache types and values
 			switch v := arg.(type) {
 			case int64:
-				paramTypes[i+i] = byte(fieldTypeLongLong)
-				paramTypes[i+i+1] = 0x00
+				paramTypes[i*typeLen] = byte(fieldTypeLongLong)
+				paramTypes[i*typeLen+1] = 0x00
 
 				if cap(paramValues)-len(paramValues)-8 >= 0 {
 					paramValues = paramValues[:len(paramValues)+8]
@@ -1026,8 +1649,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 				}
 
 			case uint64:
-				paramTypes[i+i] = byte(fieldTypeLongLong)
-				paramTypes[i+i+1] = 0x80 // type is unsigned
+				paramTypes[i*typeLen] = byte(fieldTypeLongLong)
+				paramTypes[i*typeLen+1] = 0x80 // type is unsigned
 
 				if cap(paramValues)-len(paramValues)-8 >= 0 {
 					paramValues = paramValues[:len(paramValues)+8]
@@ -1042,8 +1665,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 				}
 
 			case float64:
-				paramTypes[i+i] = byte(fieldTypeDouble)
-				paramTypes[i+i+1] = 0x00
+				paramTypes[i*typeLen] = byte(fieldTypeDouble)
+				paramTypes[i*typeLen+1] = 0x00
 
 				if cap(paramValues)-len(paramValues)-8 >= 0 {
 					paramValues = paramValues[:len(paramValues)+8]
@@ -1058,8 +1681,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 				}
 
 			case bool:
-				paramTypes[i+i] = byte(fieldTypeTiny)
-				paramTypes[i+i+1] = 0x00
+				paramTypes[i*typeLen] = byte(fieldTypeTiny)
+				paramTypes[i*typeLen+1] = 0x00
 
 				if v {
 					paramValues = append(paramValues, 0x01)
@@ -1070,8 +1693,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 			case []byte:
 				// Common case (non-nil value) first
 				if v != nil {
-					paramTypes[i+i] = byte(fieldTypeString)
-					paramTypes[i+i+1] = 0x00
+					paramTypes[i*typeLen] = byte(fieldTypeString)
+					paramTypes[i*typeLen+1] = 0x00
 
 					if len(v) < longDataSize {
 						paramValues = appendLengthEncodedInteger(paramValues,
@@ -1088,12 +1711,12 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 
 				// Handle []byte(nil) as a NULL value
 				nullMask[i/8] |= 1 << (uint(i) & 7)
-				paramTypes[i+i] = byte(fieldTypeNULL)
-				paramTypes[i+i+1] = 0x00
+				paramTypes[i*typeLen] = byte(fieldTypeNULL)
+				paramTypes[i*typeLen+1] = 0x00
 
 			case string:
-				paramTypes[i+i] = byte(fieldTypeString)
-				paramTypes[i+i+1] = 0x00
+				paramTypes[i*typeLen] = byte(fieldTypeString)
+				paramTypes[i*typeLen+1] = 0x00
 
 				if len(v) < longDataSize {
 					paramValues = appendLengthEncodedInteger(paramValues,
@@ -1107,8 +1730,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 				}
 
 			case time.Time:
-				paramTypes[i+i] = byte(fieldTypeString)
-				paramTypes[i+i+1] = 0x00
+				paramTypes[i*typeLen] = byte(fieldTypeString)
+				paramTypes[i*typeLen+1] = 0x00
 
 				var a [64]byte
 				var b = a[:0]
@@ -1132,12 +1755,16 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 			}
 		}
 
+		for _, attr := range attrs {
+			paramValues = append(paramValues, attr.value...)
+		}
+
 		// Check if param values exceeded the available buffer
 		// In that case we must build the data packet with the new values buffer
 		if valuesCap != cap(paramValues) {
 			data = append(data[:pos], paramValues...)
 			if err = mc.buf.store(data); err != nil {
-				errLog.Print(err)
+				mc.log(err)
 				return errBadConnNoWrite
 			}
 		}
@@ -1157,7 +1784,7 @@ func (mc *mysqlConn) discardResults() error {
 		}
 		if resLen > 0 {
 			// columns
-			if err := mc.readUntilEOF(); err != nil {
+			if err := mc.skipColumns(resLen); err != nil {
 				return err
 			}
 			// rows
@@ -1169,6 +1796,55 @@ func (mc *mysqlConn) discardResults() error {
 	return nil
 }
 
+// rowReader reads the rows of a result set, see textRows and binaryRows.
+type rowReader interface {
+	Columns() []string
+	readRow(dest []driver.Value) error
+}
+
+// readReturning reads the rows of a result set returned by Exec and keeps up
+// to max of them. The values are copied, as they are kept after the next
+// packet is read.
+func readReturning(rows rowReader, max int64) (*returningRows, error) {
+	returning := &returningRows{columns: rows.Columns()}
+	dest := make([]driver.Value, len(returning.columns))
+	for {
+		err := rows.readRow(dest)
+		if err == io.EOF {
+			return returning, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(returning.rows)) >= max {
+			continue // discarded
+		}
+		row := append([]driver.Value(nil), dest...)
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = append([]byte(nil), b...)
+			}
+		}
+		returning.rows = append(returning.rows, row)
+	}
+}
+
+// binaryFieldSize returns the size of a value of the given type in the binary
+// protocol, or 0 if the value is length encoded.
+func binaryFieldSize(t fieldType) int {
+	switch t {
+	case fieldTypeTiny:
+		return 1
+	case fieldTypeShort, fieldTypeYear:
+		return 2
+	case fieldTypeInt24, fieldTypeLong, fieldTypeFloat:
+		return 4
+	case fieldTypeLongLong, fieldTypeDouble:
+		return 8
+	}
+	return 0
+}
+
 // http://dev.mysql.com/doc/internals/en/binary-protocol-resultset-row.html
 func (rows *binaryRows) readRow(dest []driver.Value) error {
 	data, err := rows.mc.readPacket()
@@ -1179,8 +1855,10 @@ func (rows *binaryRows) readRow(dest []driver.Value) error {
 	// packet indicator [1 byte]
 	if data[0] != iOK {
 		// EOF Packet
-		if data[0] == iEOF && len(data) == 5 {
-			rows.mc.status = readStatus(data[3:])
+		if rows.mc.isEOFPacket(data) {
+			if err := rows.mc.handleEOFPacket(data); err != nil {
+				return err
+			}
 			rows.rs.done = true
 			if !rows.HasNextResultSet() {
 				rows.mc = nil
@@ -1193,9 +1871,16 @@ func (rows *binaryRows) readRow(dest []driver.Value) error {
 		// Error otherwise
 		return mc.handleErrorPacket(data)
 	}
+	rows.mc.stats.Rows++
+	if rows.stmtStats != nil {
+		rows.stmtStats.Rows++
+	}
 
 	// NULL-bitmap,  [(column-count + 7 + 2) / 8 bytes]
 	pos := 1 + (len(dest)+7+2)>>3
+	if len(data) < pos {
+		return rows.mc.malformed(data)
+	}
 	nullMask := data[1:pos]
 
 	for i := range dest {
@@ -1206,6 +1891,11 @@ func (rows *binaryRows) readRow(dest []driver.Value) error {
 			continue
 		}
 
+		// Check the data length of fixed-length types
+		if binaryFieldSize(rows.rs.columns[i].fieldType) > len(data)-pos {
+			return rows.mc.malformed(data)
+		}
+
 		// Convert to byte-coded string
 		switch rows.rs.columns[i].fieldType {
 		case fieldTypeNULL:
@@ -1275,13 +1965,22 @@ func (rows *binaryRows) readRow(dest []driver.Value) error {
 			pos += n
 			if err == nil {
 				if !isNull {
+					if dec := rows.rs.columns[i].decoder; dec != nil {
+						if dest[i], err = dec(dest[i].([]byte)); err != nil {
+							return err
+						}
+					} else if rows.rs.columns[i].asFloat {
+						if dest[i], err = parseDecimal(dest[i].([]byte)); err != nil {
+							return err
+						}
+					}
 					continue
 				} else {
 					dest[i] = nil
 					continue
 				}
 			}
-			return err
+			return rows.mc.malformed(data)
 
 		case
 			fieldTypeDate, fieldTypeNewDate, // Date YYYY-MM-DD
@@ -1289,6 +1988,9 @@ func (rows *binaryRows) readRow(dest []driver.Value) error {
 			fieldTypeTimestamp, fieldTypeDateTime: // Timestamp YYYY-MM-DD HH:MM:SS[.fractal]
 
 			num, isNull, n := readLengthEncodedInteger(data[pos:])
+			if n > len(data)-pos || num > uint64(len(data)-pos-n) {
+				return rows.mc.malformed(data)
+			}
 			pos += n
 
 			switch {
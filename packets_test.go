@@ -10,8 +10,14 @@ package mysql
 
 import (
 	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -107,6 +113,39 @@ func newRWMockConn(sequence uint8) (*mockConn, *mysqlConn) {
 	return conn, mc
 }
 
+// testColumn is a column of the result sets built by appendColumns.
+type testColumn struct {
+	name      string
+	fieldType fieldType
+	decimals  byte
+}
+
+// appendTestPacket appends a packet with the sequence id seq to b.
+func appendTestPacket(b []byte, seq byte, payload ...byte) []byte {
+	b = append(b, byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16), seq)
+	return append(b, payload...)
+}
+
+// columnDefinition returns the payload of the column definition packet of
+// column.
+func columnDefinition(column testColumn) []byte {
+	var col []byte
+	for _, s := range []string{"def", "", "", "", column.name, ""} {
+		col = appendLengthEncodedString(col, s)
+	}
+	return append(col, 0x0c, 0x21, 0x00, 0x0b, 0x00, 0x00, 0x00, byte(column.fieldType), 0x00, 0x00, column.decimals, 0x00, 0x00)
+}
+
+// appendColumns appends the column definitions and the EOF packet following
+// them to b, starting with the sequence id seq.
+func appendColumns(b []byte, seq byte, columns ...testColumn) []byte {
+	for _, column := range columns {
+		b = appendTestPacket(b, seq, columnDefinition(column)...)
+		seq++
+	}
+	return appendTestPacket(b, seq, iEOF, 0x00, 0x00, 0x02, 0x00)
+}
+
 func TestReadPacketSingleByte(t *testing.T) {
 	conn := new(mockConn)
 	mc := &mysqlConn{
@@ -138,7 +177,7 @@ func TestReadPacketWrongSequenceID(t *testing.T) {
 	conn.maxReads = 1
 	mc.sequence = 1
 	_, err := mc.readPacket()
-	if err != ErrPktSync {
+	if !errors.Is(err, ErrPktSync) {
 		t.Errorf("expected ErrPktSync, got %v", err)
 	}
 
@@ -150,11 +189,39 @@ func TestReadPacketWrongSequenceID(t *testing.T) {
 	// too high sequence id
 	conn.data = []byte{0x01, 0x00, 0x00, 0x42, 0xff}
 	_, err = mc.readPacket()
-	if err != ErrPktSyncMul {
+	if !errors.Is(err, ErrPktSyncMul) {
 		t.Errorf("expected ErrPktSyncMul, got %v", err)
 	}
 }
 
+func TestProtocolErrorDiagnostics(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+
+	// a result set header with trailing garbage
+	conn.queuedReplies = [][]byte{{0x03, 0x00, 0x00, 0x01, 0x01, 0xab, 0xcd}}
+	_, err := mc.query("SELECT 1", nil)
+	perr, ok := err.(*ProtocolError)
+	if !ok {
+		t.Fatalf("expected *ProtocolError, got %#v", err)
+	}
+	expected := ProtocolError{Err: ErrMalformPkt, Command: comQuery, ExpectedSeq: 1, ReceivedSeq: 1, PacketLen: 3, Head: []byte{0x01, 0xab, 0xcd}}
+	if perr.Err != expected.Err || perr.Command != expected.Command || perr.ExpectedSeq != expected.ExpectedSeq ||
+		perr.ReceivedSeq != expected.ReceivedSeq || perr.PacketLen != expected.PacketLen || !bytes.Equal(perr.Head, expected.Head) {
+		t.Errorf("expected %+v, got %+v", expected, *perr)
+	}
+	if msg := "malformed packet (command COM_QUERY, sequence 1, expected 1, length 3, head 01abcd)"; err.Error() != msg {
+		t.Errorf("expected %q, got %q", msg, err.Error())
+	}
+
+	// a packet of another command
+	conn, mc = newRWMockConn(0)
+	conn.queuedReplies = [][]byte{{0x01, 0x00, 0x00, 0x05, 0x00}}
+	err = mc.Ping(context.Background())
+	if perr, ok := err.(*ProtocolError); !ok || perr.Command != comPing || perr.ExpectedSeq != 1 || perr.ReceivedSeq != 5 {
+		t.Errorf("unexpected error %#v", err)
+	}
+}
+
 func TestReadPacketSplit(t *testing.T) {
 	conn := new(mockConn)
 	mc := &mysqlConn{
@@ -260,6 +327,128 @@ func TestReadPacketSplit(t *testing.T) {
 	}
 }
 
+func TestReadPacketReleaseBuffer(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+
+	// a packet which does not fit into the default buffer
+	pktLen := 3 * defaultBufSize
+	data := make([]byte, 4+pktLen)
+	data[0] = byte(pktLen)
+	data[1] = byte(pktLen >> 8)
+	data[2] = byte(pktLen >> 16)
+	data[4+pktLen-1] = 0x42
+	conn.data = data
+
+	packet, err := mc.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet) != pktLen || packet[pktLen-1] != 0x42 {
+		t.Fatalf("unexpected packet (%d bytes)", len(packet))
+	}
+	if len(mc.buf.dbuf[0]) != 4*defaultBufSize {
+		t.Fatalf("expected a pooled buffer of %d bytes, got %d", 4*defaultBufSize, len(mc.buf.dbuf[0]))
+	}
+
+	// putting the connection back into the pool releases the large buffer
+	if !mc.IsValid() {
+		t.Fatal("connection should be valid")
+	}
+	if len(mc.buf.dbuf[0]) != defaultBufSize || len(mc.buf.buf) != defaultBufSize {
+		t.Errorf("expected the buffer to shrink to %d bytes, got %d", defaultBufSize, len(mc.buf.dbuf[0]))
+	}
+
+	// the connection is still usable afterwards
+	conn.data = []byte{0x01, 0x00, 0x00, 0x01, 0xff}
+	packet, err = mc.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet) != 1 || packet[0] != 0xff {
+		t.Errorf("unexpected packet: %v", packet)
+	}
+}
+
+func testLongDataPackets(stmtID uint32, paramID int, arg []byte, maxLen int) []byte {
+	var expected []byte
+	for len(arg) > 0 {
+		n := len(arg)
+		if n > maxLen-7 {
+			n = maxLen - 7
+		}
+		pktLen := 7 + n
+		expected = append(expected, byte(pktLen), byte(pktLen>>8), byte(pktLen>>16), 0,
+			comStmtSendLongData, byte(stmtID), byte(stmtID>>8), byte(stmtID>>16), byte(stmtID>>24),
+			byte(paramID), byte(paramID>>8))
+		expected = append(expected, arg[:n]...)
+		arg = arg[n:]
+	}
+	return expected
+}
+
+func TestWriteCommandLongData(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.maxAllowedPacket = 1024
+	stmt := &mysqlStmt{mc: mc, id: 0x01020304}
+
+	arg := bytes.Repeat([]byte("0123456789"), 250)
+	if err := stmt.writeCommandLongData(3, arg); err != nil {
+		t.Fatal(err)
+	}
+	expected := testLongDataPackets(stmt.id, 3, arg, mc.maxAllowedPacket-1)
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("unexpected long data packets (%d bytes, want %d)", len(conn.written), len(expected))
+	}
+}
+
+func TestWriteCommandLongDataBuffers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer c.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(c)
+		received <- buf.Bytes()
+	}()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := &mysqlConn{
+		buf:              newBuffer(nc),
+		cfg:              NewConfig(),
+		netConn:          nc,
+		closech:          make(chan struct{}),
+		maxAllowedPacket: 1024,
+	}
+	if !supportsBuffers(mc.netConn) {
+		t.Fatalf("expected vectored writes to be supported by %T", mc.netConn)
+	}
+	stmt := &mysqlStmt{mc: mc, id: 42}
+
+	arg := bytes.Repeat([]byte("0123456789"), 250)
+	if err := stmt.writeCommandLongData(1, arg); err != nil {
+		t.Fatal(err)
+	}
+	nc.Close()
+
+	expected := testLongDataPackets(stmt.id, 1, arg, mc.maxAllowedPacket-1)
+	if got := <-received; !bytes.Equal(got, expected) {
+		t.Errorf("unexpected long data packets (%d bytes, want %d)", len(got), len(expected))
+	}
+}
+
 func TestReadPacketFail(t *testing.T) {
 	conn := new(mockConn)
 	mc := &mysqlConn{
@@ -334,3 +523,504 @@ func TestRegression801(t *testing.T) {
 		t.Errorf("expected authData '%v', got '%v'", expectedAuthData, authData)
 	}
 }
+
+func TestReadHandshakePacketMalformed(t *testing.T) {
+	payload := []byte{10, 53, 46, 53, 46, 56, 0, 165, 0, 0, 0,
+		60, 70, 63, 58, 68, 104, 34, 97, 0, 223, 247, 33, 2, 0, 15, 128, 21, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 98, 120, 114, 47, 85, 75, 109, 99, 51, 77,
+		50, 64, 0, 109, 121, 115, 113, 108, 95, 110, 97, 116, 105, 118, 101, 95,
+		112, 97, 115, 115, 119, 111, 114, 100}
+
+	for n := 1; n <= len(payload); n++ {
+		conn, mc := newRWMockConn(0)
+		conn.data = append([]byte{byte(n), 0, 0, 0}, payload[:n]...)
+
+		_, _, err := mc.readHandshakePacket()
+		switch {
+		case n < 22, n > 22 && n < 50:
+			// truncated in the middle of a fixed-length field
+			if !errors.Is(err, ErrMalformPkt) {
+				t.Errorf("%d bytes: expected ErrMalformPkt, got %v", n, err)
+			}
+		default:
+			if err != nil {
+				t.Errorf("%d bytes: got error: %v", n, err)
+			}
+		}
+	}
+}
+
+func TestWriteHandshakeResponsePacketLarge(t *testing.T) {
+	conn, mc := newRWMockConn(1)
+	mc.cfg.User = "root"
+	mc.cfg.DBName = strings.Repeat("d", 5000)
+	mc.cfg.ConnectionAttributes = map[string]string{"pod": "web-1"}
+	mc.connector = newConnector(mc.cfg)
+	mc.flags = clientProtocol41 | clientConnectAttrs
+
+	if err := mc.writeHandshakeResponsePacket(make([]byte, 20), "mysql_native_password"); err != nil {
+		t.Fatal(err)
+	}
+
+	pkt := conn.written
+	if pktLen := int(uint32(pkt[0]) | uint32(pkt[1])<<8 | uint32(pkt[2])<<16); pktLen != len(pkt)-4 {
+		t.Fatalf("packet length mismatch: header %d, body %d", pktLen, len(pkt)-4)
+	}
+	if len(pkt) <= defaultBufSize {
+		t.Fatalf("expected a packet larger than %d bytes, got %d", defaultBufSize, len(pkt))
+	}
+	flags := clientFlag(binary.LittleEndian.Uint32(pkt[4:8]))
+	if flags&clientConnectAttrs == 0 {
+		t.Fatal("clientConnectAttrs is not set")
+	}
+
+	attrs := mc.connector.encodedAttributes
+	if !strings.Contains(attrs, connAttrClientNameValue) {
+		t.Errorf("connection attributes do not contain %q", connAttrClientNameValue)
+	}
+	if !strings.Contains(attrs, "\x03pod\x05web-1") {
+		t.Error("connection attributes do not contain the attributes of the config")
+	}
+	if !bytes.HasSuffix(pkt, []byte(attrs)) {
+		t.Error("connection attributes are not sent at the end of the packet")
+	}
+	lei := appendLengthEncodedInteger(nil, uint64(len(attrs)))
+	if !bytes.HasSuffix(pkt[:len(pkt)-len(attrs)], lei) {
+		t.Error("connection attributes length is not encoded")
+	}
+}
+
+func TestReadColumnsReuse(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+
+	var data []byte
+	appendPacket := func(seq byte, payload []byte) {
+		data = append(data, byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16), seq)
+		data = append(data, payload...)
+	}
+	for i, name := range []string{"id", "value"} {
+		var col []byte
+		col = appendLengthEncodedString(col, "def")  // catalog
+		col = appendLengthEncodedString(col, "test") // schema
+		col = appendLengthEncodedString(col, "t")    // table
+		col = appendLengthEncodedString(col, "t")    // original table
+		col = appendLengthEncodedString(col, name)   // name
+		col = appendLengthEncodedString(col, name)   // original name
+		col = append(col, 0x0c, 0x3f, 0x00, 0x0b, 0x00, 0x00, 0x00, byte(fieldTypeLong), 0x00, 0x00, 0x00, 0x00, 0x00)
+		appendPacket(byte(i), col)
+	}
+	appendPacket(2, []byte{iEOF, 0x00, 0x00, 0x02, 0x00})
+
+	read := func() []mysqlField {
+		conn.data = data
+		mc.sequence = 0
+		columns, err := mc.readColumns(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return columns
+	}
+
+	first := read()
+	if first[0].name != "id" || first[1].name != "value" || first[1].fieldType != fieldTypeLong {
+		t.Fatalf("unexpected columns: %+v", first)
+	}
+	second := read()
+	if &first[0] != &second[0] {
+		t.Error("column metadata is not reused")
+	}
+
+	if allocs := testing.AllocsPerRun(10, func() { read() }); allocs != 0 {
+		t.Errorf("expected no allocations for repeated columns, got %v", allocs)
+	}
+}
+
+func TestStmtColumnsCache(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+
+	columnsData := func(names ...string) []byte {
+		var columns []testColumn
+		for _, name := range names {
+			columns = append(columns, testColumn{name: name, fieldType: fieldTypeLong})
+		}
+		return appendColumns(nil, 0, columns...)
+	}
+	stmt1 := &mysqlStmt{mc: mc}
+	data1 := columnsData("id", "value")
+	stmt2 := &mysqlStmt{mc: mc}
+	data2 := columnsData("name")
+
+	read := func(stmt *mysqlStmt, data []byte, count int) []mysqlField {
+		conn.data = data
+		mc.sequence = 0
+		columns, err := stmt.readColumns(count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return columns
+	}
+
+	first := read(stmt1, data1, 2)
+	if first[0].name != "id" || first[1].name != "value" {
+		t.Fatalf("unexpected columns: %+v", first)
+	}
+	if columns := read(stmt2, data2, 1); columns[0].name != "name" {
+		t.Fatalf("unexpected columns: %+v", columns)
+	}
+	if second := read(stmt1, data1, 2); &first[0] != &second[0] || second[0].name != "id" {
+		t.Error("column metadata of the statement is not reused")
+	}
+
+	// alternating statements do not overwrite each other's columns
+	allocs := testing.AllocsPerRun(10, func() {
+		read(stmt1, data1, 2)
+		read(stmt2, data2, 1)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations for repeated executions, got %v", allocs)
+	}
+}
+
+func TestDecimalAsFloat(t *testing.T) {
+	reply := textResultSetFields([]testColumn{
+		{name: "price", fieldType: fieldTypeNewDecimal, decimals: 2},
+		{name: "name", fieldType: fieldTypeVarString, decimals: 2},
+	}, []interface{}{"12.34", "1.5"}, []interface{}{nil, nil})
+
+	conn, mc := newRWMockConn(0)
+	mc.cfg.DecimalAsFloat = true
+	conn.queuedReplies = [][]byte{reply}
+
+	rows, err := mc.Query("SELECT price, name FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	mrows := rows.(*textRows)
+	if typ := mrows.ColumnTypeScanType(0); typ != scanTypeNullFloat {
+		t.Errorf("expected scan type %v, got %v", scanTypeNullFloat, typ)
+	}
+	if typ := mrows.ColumnTypeScanType(1); typ != scanTypeRawBytes {
+		t.Errorf("expected scan type %v, got %v", scanTypeRawBytes, typ)
+	}
+
+	dest := make([]driver.Value, 2)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if dest[0] != 12.34 || string(dest[1].([]byte)) != "1.5" {
+		t.Errorf("unexpected values %#v", dest)
+	}
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if dest[0] != nil || dest[1] != nil {
+		t.Errorf("expected NULL values, got %#v", dest)
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	type document map[string]interface{}
+	if err := RegisterDecoder("JSON", func(data []byte) (driver.Value, error) {
+		var doc document
+		err := json.Unmarshal(data, &doc)
+		return doc, err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer DeregisterDecoder("JSON")
+	if err := RegisterDecoder("INT", nil); err == nil {
+		t.Error("expected an error for INT columns")
+	}
+
+	reply := textResultSetColumns([]string{"doc", "name"}, []interface{}{`{"a":1}`, `{"a":1}`}, []interface{}{nil, nil})
+	// the first column is a JSON column
+	reply[bytes.IndexByte(reply, byte(fieldTypeVarString))] = byte(fieldTypeJSON)
+
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{reply}
+	rows, err := mc.Query("SELECT doc, name FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	mrows := rows.(*textRows)
+	if typ := mrows.ColumnTypeScanType(0); typ != scanTypeUnknown {
+		t.Errorf("expected scan type %v, got %v", scanTypeUnknown, typ)
+	}
+
+	dest := make([]driver.Value, 2)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if doc, ok := dest[0].(document); !ok || doc["a"] != 1.0 {
+		t.Errorf("expected the decoded document, got %#v", dest[0])
+	}
+	if s, ok := dest[1].([]byte); !ok || string(s) != `{"a":1}` {
+		t.Errorf("expected the VARCHAR column as []byte, got %#v", dest[1])
+	}
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if dest[0] != nil {
+		t.Errorf("expected NULL, got %#v", dest[0])
+	}
+}
+
+func TestDrainRowsTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	mc := &mysqlConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		cfg:              NewConfig(),
+		closech:          make(chan struct{}),
+		maxAllowedPacket: defaultMaxAllowedPacket,
+	}
+	mc.cfg.DrainTimeout = 50 * time.Millisecond
+
+	// the server keeps sending rows and never finishes the result set
+	go func() {
+		for seq := byte(0); ; seq++ {
+			if _, err := server.Write([]byte{0x02, 0x00, 0x00, seq, 0x01, 'a'}); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	rows := &textRows{mysqlRows{mc: mc}}
+	start := time.Now()
+	// there is no connector to kill the query, so the connection is closed
+	if err := rows.Close(); err != ErrDrainTimeout {
+		t.Errorf("expected ErrDrainTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("draining was not bounded, took %v", elapsed)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestDrainRowsKillTimeout(t *testing.T) {
+	// the connection for KILL QUERY can never be established
+	RegisterDialContext("drainkilltest", func(ctx context.Context, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	client, server := net.Pipe()
+	defer server.Close()
+	cfg := NewConfig()
+	cfg.Net = "drainkilltest"
+	cfg.DrainTimeout = 50 * time.Millisecond
+	mc := &mysqlConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		cfg:              cfg,
+		connector:        newConnector(cfg),
+		closech:          make(chan struct{}),
+		maxAllowedPacket: defaultMaxAllowedPacket,
+	}
+
+	go func() {
+		for seq := byte(0); ; seq++ {
+			if _, err := server.Write([]byte{0x02, 0x00, 0x00, seq, 0x01, 'a'}); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	rows := &textRows{mysqlRows{mc: mc}}
+	start := time.Now()
+	if err := rows.Close(); err != ErrDrainTimeout {
+		t.Errorf("expected ErrDrainTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("killing the query was not bounded, took %v", elapsed)
+	}
+	if !mc.closed.IsSet() {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestReadPacketTooLarge(t *testing.T) {
+	for _, pktLen := range []int{101, maxPacketSize} {
+		conn, mc := newRWMockConn(0)
+		mc.maxReadPacket = 100
+		conn.data = []byte{byte(pktLen), byte(pktLen >> 8), byte(pktLen >> 16), 0x00, 0xff}
+
+		if _, err := mc.readPacket(); err != ErrPktReadTooLarge {
+			t.Errorf("packet of %d bytes: expected ErrPktReadTooLarge, got %v", pktLen, err)
+		}
+		if !mc.closed.IsSet() {
+			t.Errorf("packet of %d bytes: expected the connection to be closed", pktLen)
+		}
+	}
+
+	// packets up to the limit are accepted
+	conn, mc := newRWMockConn(0)
+	mc.maxReadPacket = 2
+	conn.data = []byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x02}
+	if _, err := mc.readPacket(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadMalformedPackets(t *testing.T) {
+	mc := &mysqlConn{cfg: NewConfig()}
+	for _, data := range [][]byte{
+		{iERR},
+		{iERR, 0x01},
+	} {
+		if err := mc.handleErrorPacket(data); !errors.Is(err, ErrMalformPkt) {
+			t.Errorf("ERR %x: expected ErrMalformPkt, got %v", data, err)
+		}
+	}
+	if err, ok := mc.handleErrorPacket([]byte{iERR, 0x01, 0x02, '#', 'H'}).(*MySQLError); !ok || err.Message != "#H" {
+		t.Errorf("ERR with short SQL state: unexpected error %v", err)
+	}
+	for _, data := range [][]byte{
+		{iOK, 0xfc, 0x01},
+		{iOK, 0x00, 0xfe, 0x01},
+	} {
+		if err := mc.handleOkPacket(data); !errors.Is(err, ErrMalformPkt) {
+			t.Errorf("OK %x: expected ErrMalformPkt, got %v", data, err)
+		}
+	}
+
+	packet := func(payload ...byte) []byte {
+		return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0x00}, payload...)
+	}
+
+	// column definition without the fixed-length fields
+	var col []byte
+	for i := 0; i < 6; i++ {
+		col = appendLengthEncodedString(col, "c")
+	}
+	col = append(col, 0x0c, 0x3f, 0x00)
+	conn, mc := newRWMockConn(0)
+	conn.data = packet(col...)
+	if _, err := mc.readColumns(1); !errors.Is(err, ErrMalformPkt) {
+		t.Errorf("column definition: expected ErrMalformPkt, got %v", err)
+	}
+
+	// prepare result without the counts
+	conn, mc = newRWMockConn(0)
+	conn.data = packet(iOK, 0x01, 0x00, 0x00, 0x00, 0x01)
+	stmt := &mysqlStmt{mc: mc}
+	if _, err := stmt.readPrepareResultPacket(); !errors.Is(err, ErrMalformPkt) {
+		t.Errorf("prepare result: expected ErrMalformPkt, got %v", err)
+	}
+
+	// binary rows with truncated values
+	for _, tst := range []struct {
+		fieldType fieldType
+		payload   []byte
+	}{
+		{fieldTypeLong, []byte{iOK}},
+		{fieldTypeLong, []byte{iOK, 0x00, 0x01, 0x02}},
+		{fieldTypeDouble, []byte{iOK, 0x00, 0x01, 0x02, 0x03, 0x04}},
+		{fieldTypeDateTime, []byte{iOK, 0x00, 0x07, 0xe5, 0x07, 0x01}},
+		{fieldTypeVarString, []byte{iOK, 0x00, 0xfc, 0x10}},
+	} {
+		conn, mc = newRWMockConn(0)
+		conn.data = packet(tst.payload...)
+		rows := &binaryRows{mysqlRows{mc: mc, rs: resultSet{columns: []mysqlField{{fieldType: tst.fieldType}}}}}
+		if err := rows.readRow(make([]driver.Value, 1)); !errors.Is(err, ErrMalformPkt) {
+			t.Errorf("binary row %x: expected ErrMalformPkt, got %v", tst.payload, err)
+		}
+	}
+
+	// the other packets the driver reads
+	for _, tst := range []struct {
+		name    string
+		payload []byte
+		read    func(mc *mysqlConn) error
+	}{
+		{"auth switch", []byte{iEOF, 'a'}, func(mc *mysqlConn) error {
+			_, _, err := mc.readAuthResult()
+			return err
+		}},
+		{"result set header", []byte{0x01, 0x02}, func(mc *mysqlConn) error {
+			_, err := mc.readResultSetHeaderPacket()
+			return err
+		}},
+		{"text row", []byte{0x01, 'a', 0xfc, 0x10}, func(mc *mysqlConn) error {
+			rows := &textRows{mysqlRows{mc: mc, rs: resultSet{columns: []mysqlField{{}, {}}}}}
+			return rows.readRow(make([]driver.Value, 2))
+		}},
+		{"row view", []byte{0x01, 'a', 0xfc, 0x10}, func(mc *mysqlConn) error {
+			rows := &textRows{mysqlRows{mc: mc, rs: resultSet{columns: []mysqlField{{}, {}}}}}
+			return rows.readRowView(make([][]byte, 2))
+		}},
+	} {
+		conn, mc = newRWMockConn(0)
+		conn.data = packet(tst.payload...)
+		if err := tst.read(mc); !errors.Is(err, ErrMalformPkt) {
+			t.Errorf("%s: expected ErrMalformPkt, got %v", tst.name, err)
+		}
+	}
+	conn, mc = newRWMockConn(0)
+	conn.queuedReplies = [][]byte{{0x01, 0x00, 0x00, 0x01, 0x01}}
+	if err := mc.simpleCommand(context.Background(), comPing, nil); !errors.Is(err, ErrMalformPkt) {
+		t.Errorf("simple command: expected ErrMalformPkt, got %v", err)
+	}
+	conn, mc = newRWMockConn(0)
+	mc.skipMetadata = true
+	if _, err := (&mysqlStmt{mc: mc}).readColumns(1); !errors.Is(err, ErrMalformPkt) {
+		t.Errorf("cached columns: expected ErrMalformPkt, got %v", err)
+	}
+}
+
+func TestDeprecateEOF(t *testing.T) {
+	column := columnDefinition(testColumn{name: "v", fieldType: fieldTypeVarString})
+	packets := func(payloads ...[]byte) []byte {
+		var b []byte
+		for i, p := range payloads {
+			b = appendTestPacket(b, byte(i+1), p...)
+		}
+		return b
+	}
+
+	conn, mc := newRWMockConn(0)
+	mc.clientFlags |= clientDeprecateEOF
+	conn.queuedReplies = [][]byte{
+		// no EOF after the columns, the rows end with an OK packet with
+		// the EOF header
+		packets([]byte{1}, column, []byte{1, 'a'}, []byte{iEOF, 0, 0, 0x02, 0x00, 0x01, 0x00}),
+		// prepare: one column and one parameter, without EOF packets
+		packets([]byte{iOK, 1, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0}, column, column),
+	}
+	rows, err := mc.QueryContext(context.Background(), "SELECT v", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if string(dest[0].([]byte)) != "a" {
+		t.Errorf("unexpected value %q", dest[0])
+	}
+	if err := rows.Next(dest); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if mc.warnings != 1 || mc.status != statusInAutocommit {
+		t.Errorf("unexpected warnings %d and status %x", mc.warnings, mc.status)
+	}
+	rows.Close()
+
+	stmt, err := mc.Prepare("SELECT v FROM t WHERE id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stmt.NumInput(); n != 1 {
+		t.Errorf("expected 1 parameter, got %d", n)
+	}
+	if len(conn.data) != 0 || mc.buf.length != 0 {
+		t.Error("expected the definitions to be read")
+	}
+}
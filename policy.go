@@ -0,0 +1,267 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"strings"
+)
+
+// StatementPolicy decides whether a statement may be sent to the server.
+// A non-nil error rejects the statement; it is returned wrapped in a
+// *PolicyError. For example, to keep a code path from changing the schema:
+//
+//	cfg.StatementPolicy = func(ctx context.Context, stmt *mysql.Statement) error {
+//		switch stmt.Keyword {
+//		case "CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE":
+//			return errors.New("DDL is not allowed")
+//		}
+//		if stmt.Multi {
+//			return errors.New("multiple statements are not allowed")
+//		}
+//		return nil
+//	}
+type StatementPolicy func(ctx context.Context, stmt *Statement) error
+
+// Statement describes a statement for a StatementPolicy.
+type Statement struct {
+	Query   string // the query after the QueryRewriter, with placeholders
+	Keyword string // the first keyword of the query in upper case, e.g. "SELECT"
+	Multi   bool   // the query contains more than one statement
+
+	keywordEnd int     // index of the end of Keyword in Query
+	inComment  bool    // Keyword is in an executable comment
+	mode       sqlMode // the SQL mode the query is parsed in
+}
+
+// Digest returns the query normalized like the statement digests of the
+// server: comments are removed, whitespace is collapsed and literals are
+// replaced with "?". Queries which only differ in their values have the same
+// digest.
+func (stmt *Statement) Digest() string {
+	var b strings.Builder
+	query := stmt.Query
+	space := false
+	comment := false
+	write := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			space = true
+		case c == '#' || isDashComment(query[i:]):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+			space = true
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if n := executableComment(query[i:]); n > 0 {
+				i += n - 1
+				comment = true
+			} else if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+			space = true
+		case comment && strings.HasPrefix(query[i:], "*/"):
+			i++
+			comment = false
+			space = true
+		case c == '\'' || c == '"' && stmt.mode&modeANSIQuotes == 0:
+			i = skipQuoted(query, i, stmt.mode)
+			write("?")
+		case c == '"' || c == '`':
+			j := skipQuoted(query, i, stmt.mode)
+			if j == len(query) {
+				j--
+			}
+			write(query[i : j+1])
+			i = j
+		case isIdentifierChar(c):
+			number := c >= '0' && c <= '9'
+			j := i
+			for j < len(query) && (isIdentifierChar(query[j]) || number && query[j] == '.') {
+				j++
+			}
+			if number {
+				write("?")
+			} else {
+				write(query[i:j])
+			}
+			i = j - 1
+		default:
+			write(query[i : i+1])
+		}
+	}
+	return b.String()
+}
+
+// checkPolicy applies the StatementPolicy of the config to a query of the
+// user.
+func (mc *mysqlConn) checkPolicy(ctx context.Context, query string) error {
+	if mc.cfg.StatementPolicy == nil {
+		return nil
+	}
+	stmt := parseStatement(query, mc.sqlMode())
+	if err := mc.cfg.StatementPolicy(ctx, stmt); err != nil {
+		return &PolicyError{Statement: stmt, Err: err}
+	}
+	return nil
+}
+
+// parseStatement finds the first keyword of the query and whether it
+// contains more than one statement. Comments, quoted strings and quoted
+// identifiers are skipped, as they are quoted in the SQL mode of the session.
+// Executable comments like "/*!50000 ... */" are parsed as SQL, as the server
+// runs them.
+func parseStatement(query string, mode sqlMode) *Statement {
+	stmt := &Statement{Query: query, mode: mode}
+	semicolon := false
+	comment := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '(':
+			continue
+		case c == '#' || isDashComment(query[i:]):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if n := executableComment(query[i:]); n > 0 {
+				i += n - 1
+				comment = true
+			} else if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+			continue
+		case comment && strings.HasPrefix(query[i:], "*/"):
+			i++
+			comment = false
+			continue
+		case c == ';':
+			semicolon = true
+			continue
+		}
+
+		// anything else after a semicolon is another statement
+		if semicolon {
+			stmt.Multi = true
+			return stmt
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			i = skipQuoted(query, i, mode)
+		default:
+			if stmt.Keyword == "" && isKeywordChar(c) {
+				j := i
+				for j < len(query) && isKeywordChar(query[j]) {
+					j++
+				}
+				stmt.Keyword = strings.ToUpper(query[i:j])
+				stmt.keywordEnd = j
+				stmt.inComment = comment
+				i = j - 1
+			}
+		}
+	}
+	return stmt
+}
+
+// addOptimizerHints adds the optimizer hints to a SELECT, INSERT, REPLACE,
+// UPDATE or DELETE statement. They are merged into the hint comment following
+// the keyword, if there is one, as the server only accepts one. Other
+// statements, and keywords in executable comments, which can't contain
+// another comment, are returned unchanged.
+func addOptimizerHints(query, hints string, mode sqlMode) string {
+	stmt := parseStatement(query, mode)
+	switch stmt.Keyword {
+	case "SELECT", "INSERT", "REPLACE", "UPDATE", "DELETE":
+	default:
+		return query
+	}
+	if stmt.inComment {
+		return query
+	}
+	rest := strings.TrimLeft(query[stmt.keywordEnd:], " \t\r\n")
+	if strings.HasPrefix(rest, "/*+") {
+		i := len(query) - len(rest) + len("/*+")
+		return query[:i] + " " + hints + query[i:]
+	}
+	return query[:stmt.keywordEnd] + " /*+ " + hints + " */" + query[stmt.keywordEnd:]
+}
+
+// skipQuoted returns the index of the quote ending the string or identifier
+// starting at query[i]. Backslashes escape characters in strings, unless
+// NO_BACKSLASH_ESCAPES is set, but not in identifiers, which are enclosed in
+// double quotes with ANSI_QUOTES.
+func skipQuoted(query string, i int, mode sqlMode) int {
+	quote := query[i]
+	escapes := mode&modeNoBackslashEscapes == 0 &&
+		(quote == '\'' || quote == '"' && mode&modeANSIQuotes == 0)
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++ // doubled quote
+				continue
+			}
+			return i
+		}
+	}
+	return i
+}
+
+// isDashComment reports whether query starts with a "-- " comment. MySQL
+// requires a whitespace or control character after the dashes.
+func isDashComment(query string) bool {
+	return strings.HasPrefix(query, "--") && (len(query) == 2 || query[2] <= ' ')
+}
+
+// executableComment returns the length of the start of an executable comment
+// at the start of query, "/*!" or "/*M!" followed by an optional version, or 0
+// if there is none.
+func executableComment(query string) int {
+	var n int
+	switch {
+	case strings.HasPrefix(query, "/*!"):
+		n = len("/*!")
+	case strings.HasPrefix(query, "/*M!"):
+		n = len("/*M!")
+	default:
+		return 0
+	}
+	for n < len(query) && query[n] >= '0' && query[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+func isKeywordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
@@ -0,0 +1,170 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		query   string
+		keyword string
+		multi   bool
+	}{
+		{"SELECT 1", "SELECT", false},
+		{"  select 1;", "SELECT", false},
+		{"select 1; \n", "SELECT", false},
+		{"/* comment */ DROP TABLE t", "DROP", false},
+		{"-- comment\nupdate t set v = 1", "UPDATE", false},
+		{"# comment\n(SELECT 1) UNION (SELECT 2)", "SELECT", false},
+		{"--1", "", false},
+		{"SELECT ';' FROM t", "SELECT", false},
+		{"SELECT 'it''s;' FROM t", "SELECT", false},
+		{"SELECT `a;b` FROM t -- ; DROP", "SELECT", false},
+		{"SELECT 1; DROP TABLE t", "SELECT", true},
+		{"SELECT 1;/* c */;INSERT INTO t VALUES (1)", "SELECT", true},
+		{"SELECT 'a\\';DROP'", "SELECT", false},
+		{"SELECT 1; /*!DROP TABLE t*/", "SELECT", true},
+		{"/*!50000 DROP TABLE t*/", "DROP", false},
+		{"/*M!100100 DROP TABLE t */", "DROP", false},
+		{"/*!80000 SELECT 1 */; /*! DROP TABLE t */", "SELECT", true},
+		{"SELECT /*+ BKA(t) */ 1 /*!; DROP TABLE t */", "SELECT", true},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		stmt := parseStatement(test.query, 0)
+		if stmt.Keyword != test.keyword || stmt.Multi != test.multi || stmt.Query != test.query {
+			t.Errorf("parseStatement(%q) = %+v, expected keyword %q, multi %v", test.query, stmt, test.keyword, test.multi)
+		}
+	}
+}
+
+func TestStatementDigest(t *testing.T) {
+	tests := []struct {
+		query  string
+		mode   sqlMode
+		digest string
+	}{
+		{"SELECT 1", 0, "SELECT ?"},
+		{"  SELECT *\n\tFROM t1   WHERE id = 42 -- c\n", 0, "SELECT * FROM t1 WHERE id = ?"},
+		{"INSERT INTO `t` (a, b) VALUES ('x''y', 1.5e3), (?, ?)", 0, "INSERT INTO `t` (a, b) VALUES (?, ?), (?, ?)"},
+		{"/* c */ UPDATE t SET v = \"a\" /*!50000 , w = 0x1F */", 0, "UPDATE t SET v = ? , w = ?"},
+		{`SELECT "col" FROM t`, modeANSIQuotes, `SELECT "col" FROM t`},
+	}
+	for _, test := range tests {
+		if digest := parseStatement(test.query, test.mode).Digest(); digest != test.digest {
+			t.Errorf("Digest of %q = %q, expected %q", test.query, digest, test.digest)
+		}
+	}
+}
+
+func TestParseStatementSQLMode(t *testing.T) {
+	tests := []struct {
+		query string
+		mode  sqlMode
+		multi bool
+	}{
+		{`SELECT 'a\';DROP'`, 0, false},
+		{`SELECT 'a\';DROP'`, modeNoBackslashEscapes, true},
+		{`SELECT "a\";DROP"`, 0, false},
+		{`SELECT "a\";DROP"`, modeANSIQuotes, true},
+		{`SELECT "a\";DROP"`, modeNoBackslashEscapes, true},
+		{`SELECT 'a\';DROP'`, modeANSIQuotes, false},
+		{"SELECT `a\\`;DROP`", 0, true},
+	}
+	for _, test := range tests {
+		if stmt := parseStatement(test.query, test.mode); stmt.Multi != test.multi {
+			t.Errorf("parseStatement(%q, %d) = %+v, expected multi %v", test.query, test.mode, stmt, test.multi)
+		}
+	}
+
+	for _, tst := range []struct {
+		sqlMode string
+		ansi    bool
+	}{
+		{"STRICT_TRANS_TABLES,ANSI_QUOTES", true},
+		{"'ansi'", true},
+		{"REAL_AS_FLOAT,PIPES_AS_CONCAT", false},
+		{"", false},
+	} {
+		if ansi := hasANSIQuotes(tst.sqlMode); ansi != tst.ansi {
+			t.Errorf("hasANSIQuotes(%q) = %v, expected %v", tst.sqlMode, ansi, tst.ansi)
+		}
+	}
+}
+
+func TestStatementPolicy(t *testing.T) {
+	errDDL := errors.New("DDL is not allowed")
+	conn, mc := newRWMockConn(0)
+	mc.cfg.StatementPolicy = func(ctx context.Context, stmt *Statement) error {
+		if stmt.Keyword == "DROP" {
+			return errDDL
+		}
+		return nil
+	}
+	conn.queuedReplies = [][]byte{textResultSet("a")}
+
+	rows, err := mc.QueryContext(context.Background(), "SELECT v FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	conn.written = nil
+	for _, do := range []func() error{
+		func() error { _, err := mc.ExecContext(context.Background(), "drop table t", nil); return err },
+		func() error { _, err := mc.PrepareContext(context.Background(), "DROP TABLE t"); return err },
+		func() error {
+			return mc.QueryEach(context.Background(), "DROP TABLE t", nil, func(RowView) error { return nil })
+		},
+	} {
+		err := do()
+		perr, ok := err.(*PolicyError)
+		if !ok || perr.Statement.Keyword != "DROP" || !errors.Is(err, errDDL) {
+			t.Errorf("expected a *PolicyError, got %#v", err)
+		}
+	}
+	if len(conn.written) != 0 {
+		t.Errorf("a rejected statement was sent: %q", conn.written)
+	}
+}
+
+func TestOptimizerHints(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT * FROM t", "SELECT /*+ NO_ICP(t) */ * FROM t"},
+		{"/* c */ (select 1) UNION (SELECT 2)", "/* c */ (select /*+ NO_ICP(t) */ 1) UNION (SELECT 2)"},
+		{"UPDATE /*+ BKA(t) */ t SET v = 1", "UPDATE /*+ NO_ICP(t) BKA(t) */ t SET v = 1"},
+		{"/*!50000 SELECT * FROM t */", "/*!50000 SELECT * FROM t */"},
+		{"SET @a = 1", "SET @a = 1"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := addOptimizerHints(test.query, "NO_ICP(t)", 0); got != test.expected {
+			t.Errorf("addOptimizerHints(%q) = %q, expected %q", test.query, got, test.expected)
+		}
+	}
+
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}}
+	ctx := WithOptimizerHints(context.Background(), "/*+ MAX_EXECUTION_TIME(500) */")
+	ctx = WithOptimizerHints(ctx, "JOIN_ORDER(a, b)")
+	if _, err := mc.ExecContext(ctx, "DELETE FROM a", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "DELETE /*+ MAX_EXECUTION_TIME(500) JOIN_ORDER(a, b) */ FROM a"
+	if got := string(conn.written[5:]); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
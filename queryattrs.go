@@ -0,0 +1,174 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// paramCountAvailable is the flag of COM_STMT_EXECUTE which announces the
+// parameter count, which includes the query attributes.
+const paramCountAvailable = 0x08
+
+type queryAttributesKey struct{}
+
+// WithQueryAttributes returns a context which sends attrs as query
+// attributes with the queries and statement executions it is used for, e.g.
+// trace ids or tenant tags. The server reads them with
+// mysql_query_attribute_string('name'). Values are converted like the
+// arguments of a query.
+//
+// Query attributes require MySQL 8.0.23 or later; other servers ignore
+// them.
+func WithQueryAttributes(ctx context.Context, attrs map[string]interface{}) context.Context {
+	return context.WithValue(ctx, queryAttributesKey{}, attrs)
+}
+
+// queryAttribute is a query attribute, encoded for the binary protocol.
+type queryAttribute struct {
+	name  string
+	typ   fieldType
+	flags byte
+	value []byte // nil for NULL
+}
+
+// queryAttributes returns the query attributes of ctx, sorted by name.
+func (mc *mysqlConn) queryAttributes(ctx context.Context) ([]queryAttribute, error) {
+	attrs, _ := ctx.Value(queryAttributesKey{}).(map[string]interface{})
+	if len(attrs) == 0 || mc.clientFlags&clientQueryAttributes == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	encoded := make([]queryAttribute, 0, len(names))
+	for _, name := range names {
+		v, err := converter{}.ConvertValue(attrs[name])
+		if err != nil {
+			return nil, fmt.Errorf("query attribute %q: %v", name, err)
+		}
+		attr := queryAttribute{name: name, typ: fieldTypeString}
+		switch v := v.(type) {
+		case nil:
+			attr.typ = fieldTypeNULL
+		case int64:
+			attr.typ = fieldTypeLongLong
+			attr.value = uint64ToBytes(uint64(v))
+		case uint64:
+			attr.typ = fieldTypeLongLong
+			attr.flags = 0x80 // unsigned
+			attr.value = uint64ToBytes(v)
+		case float64:
+			attr.typ = fieldTypeDouble
+			attr.value = uint64ToBytes(math.Float64bits(v))
+		case bool:
+			attr.typ = fieldTypeTiny
+			attr.value = []byte{0}
+			if v {
+				attr.value[0] = 1
+			}
+		case []byte:
+			if v == nil {
+				attr.typ = fieldTypeNULL
+				break
+			}
+			attr.value = appendLengthEncodedInteger(nil, uint64(len(v)))
+			attr.value = append(attr.value, v...)
+		case string:
+			attr.value = appendLengthEncodedInteger(nil, uint64(len(v)))
+			attr.value = append(attr.value, v...)
+		case time.Time:
+			var b []byte
+			if v.IsZero() {
+				b = []byte("0000-00-00")
+			} else if b, err = appendDateTime(nil, v.In(mc.cfg.Loc)); err != nil {
+				return nil, fmt.Errorf("query attribute %q: %v", name, err)
+			}
+			attr.value = appendLengthEncodedInteger(nil, uint64(len(b)))
+			attr.value = append(attr.value, b...)
+		default:
+			return nil, fmt.Errorf("query attribute %q: cannot convert type: %T", name, v)
+		}
+		encoded = append(encoded, attr)
+	}
+	return encoded, nil
+}
+
+// appendQueryAttributes appends the query attributes of COM_QUERY, which are
+// sent whenever the capability is negotiated:
+//
+//	parameter count [length encoded integer]
+//	parameter set count [length encoded integer], always 1
+//	if parameter count > 0:
+//	  NULL bitmap [(parameter count + 7) / 8 bytes]
+//	  new params bind flag [1 byte], always 1
+//	  type [2 bytes] and name [length encoded string] of each parameter
+//	  value of each parameter [binary protocol]
+func appendQueryAttributes(b []byte, attrs []queryAttribute) []byte {
+	b = appendLengthEncodedInteger(b, uint64(len(attrs)))
+	b = append(b, 1)
+	if len(attrs) == 0 {
+		return b
+	}
+	nullMask := len(b)
+	b = append(b, make([]byte, (len(attrs)+7)/8)...)
+	b = append(b, 1)
+	for i, attr := range attrs {
+		if attr.value == nil {
+			b[nullMask+i/8] |= 1 << (uint(i) & 7)
+		}
+		b = append(b, byte(attr.typ), attr.flags)
+		b = appendLengthEncodedString(b, attr.name)
+	}
+	for _, attr := range attrs {
+		b = append(b, attr.value...)
+	}
+	return b
+}
+
+// putQueryAttributeTypes writes the types and names of the attributes, which
+// follow the types of the parameters of COM_STMT_EXECUTE, and sets their
+// bits of the NULL bitmap. It returns the number of bytes written.
+func putQueryAttributeTypes(types, nullMask []byte, offset int, attrs []queryAttribute) int {
+	pos := 0
+	for i, attr := range attrs {
+		if attr.value == nil {
+			nullMask[(offset+i)/8] |= 1 << (uint(offset+i) & 7)
+		}
+		types[pos] = byte(attr.typ)
+		types[pos+1] = attr.flags
+		pos += 2
+		var lei [9]byte
+		pos += copy(types[pos:], appendLengthEncodedInteger(lei[:0], uint64(len(attr.name))))
+		pos += copy(types[pos:], attr.name)
+	}
+	return pos
+}
+
+// queryAttributeTypesLen returns the size of the types and names of attrs.
+func queryAttributeTypesLen(attrs []queryAttribute) int {
+	n := 0
+	for _, attr := range attrs {
+		n += 2 + lengthEncodedIntegerLen(uint64(len(attr.name))) + len(attr.name)
+	}
+	return n
+}
+
+// lengthEncodedIntegerLen returns the size of n as a length encoded integer.
+func lengthEncodedIntegerLen(n uint64) int {
+	var b [9]byte
+	return len(appendLengthEncodedInteger(b[:0], n))
+}
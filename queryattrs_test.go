@@ -0,0 +1,116 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestQueryAttributesQuery(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.clientFlags |= clientQueryAttributes
+	conn.queuedReplies = [][]byte{
+		{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0},
+		{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0},
+	}
+	conn.maxReads = 2
+
+	ctx := WithQueryAttributes(context.Background(), map[string]interface{}{
+		"trace": "abc",
+		"n":     7,
+		"none":  nil,
+	})
+	if _, err := mc.ExecContext(ctx, "DO 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte{comQuery, 3, 1,
+		0x02, // NULL bitmap: none
+		1,
+		byte(fieldTypeLongLong), 0, 1, 'n',
+		byte(fieldTypeNULL), 0, 4, 'n', 'o', 'n', 'e',
+		byte(fieldTypeString), 0, 5, 't', 'r', 'a', 'c', 'e',
+		7, 0, 0, 0, 0, 0, 0, 0,
+		3, 'a', 'b', 'c',
+		'D', 'O', ' ', '1',
+	}
+	expected := append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("unexpected packet\n got: %v\nwant: %v", conn.written, expected)
+	}
+
+	// queries without attributes still announce that there are none
+	conn.written = nil
+	if _, err := mc.ExecContext(context.Background(), "DO 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected = []byte{7, 0, 0, 0, comQuery, 0, 1, 'D', 'O', ' ', '1'}
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("unexpected packet\n got: %v\nwant: %v", conn.written, expected)
+	}
+}
+
+func TestQueryAttributesNotNegotiated(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}}
+	conn.maxReads = 1
+
+	ctx := WithQueryAttributes(context.Background(), map[string]interface{}{"trace": "abc"})
+	if _, err := mc.ExecContext(ctx, "DO 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{5, 0, 0, 0, comQuery, 'D', 'O', ' ', '1'}
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("unexpected packet\n got: %v\nwant: %v", conn.written, expected)
+	}
+}
+
+func TestQueryAttributesRawCommand(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.clientFlags |= clientQueryAttributes
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}}
+
+	// the arguments are sent as given, with the header built by the caller
+	arg := []byte{0, 1, 'D', 'O', ' ', '1'}
+	if err := mc.simpleCommand(context.Background(), comQuery, arg); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{7, 0, 0, 0, comQuery}, arg...)
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("unexpected packet\n got: %v\nwant: %v", conn.written, expected)
+	}
+}
+
+func TestQueryAttributesExecute(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.clientFlags |= clientQueryAttributes
+	conn.queuedReplies = [][]byte{{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}}
+	conn.maxReads = 1
+	stmt := &mysqlStmt{mc: mc, id: 1, paramCount: 1}
+
+	ctx := WithQueryAttributes(context.Background(), map[string]interface{}{"t": "x"})
+	if _, err := stmt.ExecContext(ctx, []driver.NamedValue{{Ordinal: 1, Value: int64(5)}}); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte{comStmtExecute, 1, 0, 0, 0,
+		paramCountAvailable, 1, 0, 0, 0,
+		2,    // parameter count
+		0, 1, // NULL bitmap, new params bind flag
+		byte(fieldTypeLongLong), 0, 0,
+		byte(fieldTypeString), 0, 1, 't',
+		5, 0, 0, 0, 0, 0, 0, 0,
+		1, 'x',
+	}
+	expected := append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+	if !bytes.Equal(conn.written, expected) {
+		t.Errorf("unexpected packet\n got: %v\nwant: %v", conn.written, expected)
+	}
+}
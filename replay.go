@@ -0,0 +1,365 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"strings"
+)
+
+// txReplayConn is a connection which replays its transaction on a new
+// connection if the server fails over before the transaction is committed,
+// see Config.TxReplay.
+type txReplayConn struct {
+	*mysqlConn           // replaced by the new connection after a replay
+	tx         driver.Tx // the transaction of mysqlConn
+	txLog      *txLog    // the statements of tx, nil if no transaction runs
+}
+
+// txLog records the statements of a transaction to replay it.
+type txLog struct {
+	opts       driver.TxOptions
+	gtid       string // gtid_executed of the server when the transaction began
+	stmts      []*replayStmt
+	commands   uint64 // Commands of the connection after the last recorded statement
+	replayable bool
+}
+
+// replayStmt is a statement of a transaction and the result the application
+// has seen.
+type replayStmt struct {
+	query string
+	args  []driver.NamedValue
+
+	exec         bool
+	rowsAffected int64
+	lastInsertID int64
+
+	rows []int       // rows read from each result set
+	sum  hash.Hash64 // of the rows read
+}
+
+// failoverErrors are the errors of a server which is shut down or was
+// demoted to a read-only replica.
+var failoverErrors = map[uint16]bool{
+	1053: true, // ER_SERVER_SHUTDOWN
+	1290: true, // ER_OPTION_PREVENTS_STATEMENT, e.g. --read-only
+	1836: true, // ER_READ_ONLY_MODE
+}
+
+// failedOver reports whether err is caused by a failover of the server.
+func (rc *txReplayConn) failedOver(err error) bool {
+	if rc.mysqlConn.closed.IsSet() {
+		return true
+	}
+	var merr *MySQLError
+	return errors.As(err, &merr) && failoverErrors[merr.Number]
+}
+
+// nondeterministicFunctions are the functions whose results change when a
+// statement runs again.
+var nondeterministicFunctions = map[string]bool{
+	"CONNECTION_ID": true, "CURDATE": true, "CURRENT_DATE": true, "CURRENT_TIME": true,
+	"CURRENT_TIMESTAMP": true, "CURTIME": true, "FOUND_ROWS": true, "GET_LOCK": true,
+	"LAST_INSERT_ID": true, "LOCALTIME": true, "LOCALTIMESTAMP": true, "NOW": true,
+	"RAND": true, "RELEASE_LOCK": true, "ROW_COUNT": true, "SLEEP": true, "SYSDATE": true,
+	"UNIX_TIMESTAMP": true, "UTC_DATE": true, "UTC_TIME": true, "UTC_TIMESTAMP": true,
+	"UUID": true, "UUID_SHORT": true,
+}
+
+// isReplayable reports whether a statement gives the same result when it
+// runs again in a transaction on a new connection. Only single DML statements
+// without nondeterministic functions and variables are replayable.
+func isReplayable(query string, mode sqlMode) bool {
+	stmt := parseStatement(query, mode)
+	if stmt.Multi {
+		return false
+	}
+	switch stmt.Keyword {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "WITH":
+	default:
+		return false
+	}
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i, mode)
+		case c == '@':
+			// user variables are lost with the session
+			return false
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := i
+			for i+1 < len(query) && isIdentifierChar(query[i+1]) {
+				i++
+			}
+			if nondeterministicFunctions[strings.ToUpper(query[start:i+1])] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// startStmt marks the transaction as not replayable if the statement is not
+// replayable, or if commands ran on the connection which were not recorded,
+// e.g. executions of prepared statements.
+func (rc *txReplayConn) startStmt(query string) {
+	if rc.txLog.commands != rc.mysqlConn.stats.Commands || !isReplayable(query, rc.sqlMode()) {
+		rc.txLog.replayable = false
+	}
+}
+
+func (rc *txReplayConn) Begin() (driver.Tx, error) {
+	return rc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx begins a transaction which is recorded to replay it. It can't be
+// replayed if the server has no GTIDs to check that a new server has all
+// transactions the old one had.
+func (rc *txReplayConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	gtid, err := rc.gtidExecuted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := rc.mysqlConn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	rc.tx = tx
+	rc.txLog = &txLog{
+		opts:       opts,
+		gtid:       gtid,
+		commands:   rc.mysqlConn.stats.Commands,
+		replayable: gtid != "",
+	}
+	return &txReplayTx{rc: rc}, nil
+}
+
+// gtidExecuted returns the GTID set executed by the server, which is empty
+// if the server doesn't support GTIDs.
+func (rc *txReplayConn) gtidExecuted(ctx context.Context) (string, error) {
+	if rc.mysqlConn.closed.IsSet() {
+		return "", driver.ErrBadConn
+	}
+	if err := rc.mysqlConn.watchCancel(ctx); err != nil {
+		return "", err
+	}
+	defer rc.mysqlConn.finish()
+	gtid, err := rc.mysqlConn.getSystemVar("GLOBAL.gtid_executed")
+	if _, ok := err.(*MySQLError); ok {
+		// e.g. MariaDB, whose GTIDs are not supported
+		return "", nil
+	}
+	return string(gtid), err
+}
+
+func (rc *txReplayConn) Prepare(query string) (driver.Stmt, error) {
+	return rc.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement, whose executions can't be replayed.
+func (rc *txReplayConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if rc.txLog != nil {
+		rc.txLog.replayable = false
+	}
+	return rc.mysqlConn.PrepareContext(ctx, query)
+}
+
+func (rc *txReplayConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if rc.txLog == nil {
+		return rc.mysqlConn.ExecContext(ctx, query, args)
+	}
+	rc.startStmt(query)
+	res, err := rc.mysqlConn.ExecContext(ctx, query, args)
+	if err != nil && rc.replay(ctx, err) {
+		res, err = rc.mysqlConn.ExecContext(ctx, query, args)
+	}
+	rc.txLog.commands = rc.mysqlConn.stats.Commands
+	if err != nil {
+		return nil, err
+	}
+	s := &replayStmt{query: query, args: args, exec: true}
+	s.rowsAffected, _ = res.RowsAffected()
+	s.lastInsertID, _ = res.LastInsertId()
+	rc.txLog.stmts = append(rc.txLog.stmts, s)
+	return res, nil
+}
+
+func (rc *txReplayConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if rc.txLog == nil {
+		return rc.mysqlConn.QueryContext(ctx, query, args)
+	}
+	rc.startStmt(query)
+	rows, err := rc.mysqlConn.QueryContext(ctx, query, args)
+	if err != nil && rc.replay(ctx, err) {
+		rows, err = rc.mysqlConn.QueryContext(ctx, query, args)
+	}
+	rc.txLog.commands = rc.mysqlConn.stats.Commands
+	if err != nil {
+		return nil, err
+	}
+	s := &replayStmt{query: query, args: args, rows: []int{0}, sum: fnv.New64a()}
+	rc.txLog.stmts = append(rc.txLog.stmts, s)
+	return &replayRows{textRows: rows.(*textRows), stmt: s}, nil
+}
+
+// replay replays the transaction on a new connection after a statement
+// failed with err. It reports whether the statement can run again on the
+// new connection, which replaced the failed one.
+func (rc *txReplayConn) replay(ctx context.Context, err error) bool {
+	if !rc.txLog.replayable || !rc.failedOver(err) {
+		return false
+	}
+	rc.log("replaying the transaction after: ", err)
+	mc, err := rc.connector.connect(ctx)
+	if err != nil {
+		rc.log("could not connect to replay the transaction: ", err)
+		return false
+	}
+	tx, err := rc.txLog.replayOn(ctx, mc)
+	if err != nil {
+		mc.log("could not replay the transaction: ", err)
+		mc.Close()
+		return false
+	}
+	rc.mysqlConn.Close()
+	rc.mysqlConn = mc
+	rc.tx = tx
+	return true
+}
+
+// replayOn replays the transaction on mc, after checking that the server
+// executed all transactions the old server had when the transaction began.
+// It fails if a statement gives a different result than before.
+func (l *txLog) replayOn(ctx context.Context, mc *mysqlConn) (driver.Tx, error) {
+	subset, err := mc.queryValue(ctx, "SELECT GTID_SUBSET('"+string(escapeBytesBackslash(nil, []byte(l.gtid)))+"', @@GLOBAL.gtid_executed)")
+	if err != nil {
+		return nil, err
+	}
+	if string(subset) != "1" {
+		return nil, errors.New("the server misses transactions of the old server")
+	}
+
+	tx, err := mc.BeginTx(ctx, l.opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range l.stmts {
+		if err := s.replayOn(ctx, mc); err != nil {
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// replayOn runs the statement again on mc and checks that the application
+// would see the same result.
+func (s *replayStmt) replayOn(ctx context.Context, mc *mysqlConn) error {
+	if s.exec {
+		res, err := mc.ExecContext(ctx, s.query, s.args)
+		if err != nil {
+			return err
+		}
+		rowsAffected, _ := res.RowsAffected()
+		lastInsertID, _ := res.LastInsertId()
+		if rowsAffected != s.rowsAffected || lastInsertID != s.lastInsertID {
+			return fmt.Errorf("the result of %q changed", s.query)
+		}
+		return nil
+	}
+
+	rows, err := mc.QueryContext(ctx, s.query, s.args)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	sum := fnv.New64a()
+	for i, n := range s.rows {
+		if i > 0 {
+			if err := rows.(driver.RowsNextResultSet).NextResultSet(); err != nil {
+				return err
+			}
+		}
+		dest := make([]driver.Value, len(rows.Columns()))
+		for j := 0; j < n; j++ {
+			if err := rows.Next(dest); err != nil {
+				if err == io.EOF {
+					return fmt.Errorf("the rows of %q changed", s.query)
+				}
+				return err
+			}
+			hashRow(sum, dest)
+		}
+	}
+	if sum.Sum64() != s.sum.Sum64() {
+		return fmt.Errorf("the rows of %q changed", s.query)
+	}
+	return nil
+}
+
+func hashRow(h hash.Hash64, row []driver.Value) {
+	for _, v := range row {
+		if b, ok := v.([]byte); ok {
+			h.Write(b)
+		} else {
+			fmt.Fprintf(h, "%T:%v", v, v)
+		}
+		h.Write([]byte{0})
+	}
+}
+
+// replayRows records the rows the application read, which must be the same
+// when the transaction is replayed.
+type replayRows struct {
+	*textRows
+	stmt *replayStmt
+}
+
+func (rows *replayRows) Next(dest []driver.Value) error {
+	if err := rows.textRows.Next(dest); err != nil {
+		return err
+	}
+	rows.stmt.rows[len(rows.stmt.rows)-1]++
+	hashRow(rows.stmt.sum, dest)
+	return nil
+}
+
+func (rows *replayRows) NextResultSet() error {
+	if err := rows.textRows.NextResultSet(); err != nil {
+		return err
+	}
+	rows.stmt.rows = append(rows.stmt.rows, 0)
+	return nil
+}
+
+type txReplayTx struct {
+	rc *txReplayConn
+}
+
+// Commit commits the transaction. A failed commit is never replayed, as the
+// transaction may have been committed.
+func (tx *txReplayTx) Commit() error {
+	tx.rc.txLog = nil
+	return tx.rc.tx.Commit()
+}
+
+func (tx *txReplayTx) Rollback() error {
+	tx.rc.txLog = nil
+	return tx.rc.tx.Rollback()
+}
@@ -0,0 +1,184 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsReplayable(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM t WHERE id = 1 FOR UPDATE", true},
+		{"INSERT INTO t (v) VALUES ('now()')", true},
+		{"UPDATE t SET v = v + 1", true},
+		{"UPDATE t SET updated = NOW()", false},
+		{"insert into t (id) values (uuid())", false},
+		{"SELECT CURRENT_TIMESTAMP", false},
+		{"SELECT @x", false},
+		{"SET @x = 1", false},
+		{"CREATE TABLE t (id INT)", false},
+		{"DELETE FROM t; DELETE FROM u", false},
+	}
+	for _, tt := range tests {
+		if got := isReplayable(tt.query, 0); got != tt.want {
+			t.Errorf("isReplayable(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// txReplayConnector returns a connector with TxReplay, whose connections are
+// served with the replies of conns in turn.
+func txReplayConnector(t *testing.T, conns ...[][]byte) (*connector, *int32) {
+	var dials int32
+	network := "txreplaytest" + t.Name()
+	RegisterDialContext(network, func(ctx context.Context, addr string) (net.Conn, error) {
+		i := atomic.AddInt32(&dials, 1) - 1
+		if int(i) >= len(conns) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		client, server := net.Pipe()
+		go serveReplies(server, append([][]byte{serverHandshake, serverAuthOK}, conns[i]...))
+		return client, nil
+	})
+	cfg := NewConfig()
+	cfg.Net = network
+	cfg.Addr = "primary"
+	cfg.TxReplay = true
+	return newConnector(cfg), &dials
+}
+
+var (
+	// ER_SERVER_SHUTDOWN
+	txReplayShutdown = append([]byte{30, 0, 0, 1, 0xff, 0x1d, 0x04}, "Server shutdown in progress"...)
+	txReplayOK       = []byte{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}
+	txReplayInsert   = []byte{7, 0, 0, 1, 0, 1, 5, 2, 0, 0, 0}
+)
+
+// runTxReplay runs a transaction whose UPDATE fails over.
+func runTxReplay(t *testing.T, c *connector, update string) error {
+	conn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+	tx, err := conn.(driver.ConnBeginTx).BeginTx(ctx, driver.TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := conn.(driver.ExecerContext).ExecContext(ctx, "INSERT INTO t (v) VALUES ('a')", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := res.LastInsertId(); id != 5 {
+		t.Fatalf("unexpected insert id %d", id)
+	}
+	rows, err := conn.(driver.QueryerContext).QueryContext(ctx, "SELECT v FROM t WHERE id = 5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if _, err := conn.(driver.ExecerContext).ExecContext(ctx, update, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func TestTxReplay(t *testing.T) {
+	c, dials := txReplayConnector(t, [][]byte{
+		textResultSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"),
+		txReplayOK,
+		txReplayInsert,
+		textResultSet("a"),
+		txReplayShutdown,
+	}, [][]byte{
+		textResultSet("1"), // GTID_SUBSET
+		txReplayOK,
+		txReplayInsert,
+		textResultSet("a"),
+		txReplayOK, // the UPDATE which failed
+		txReplayOK, // COMMIT
+	})
+	if err := runTxReplay(t, c, "UPDATE t SET v = 'b' WHERE id = 5"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(dials); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+}
+
+func TestTxReplayChangedResult(t *testing.T) {
+	c, dials := txReplayConnector(t, [][]byte{
+		textResultSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"),
+		txReplayOK,
+		txReplayInsert,
+		textResultSet("a"),
+		txReplayShutdown,
+		txReplayOK, // ROLLBACK
+	}, [][]byte{
+		textResultSet("1"),
+		txReplayOK,
+		txReplayInsert,
+		textResultSet("changed"),
+	})
+	err := runTxReplay(t, c, "UPDATE t SET v = 'b' WHERE id = 5")
+	if !errors.Is(err, &MySQLError{Number: 1053}) {
+		t.Fatalf("expected the error of the failover, got %v", err)
+	}
+	if n := atomic.LoadInt32(dials); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+}
+
+func TestTxReplayNondeterministic(t *testing.T) {
+	c, dials := txReplayConnector(t, [][]byte{
+		textResultSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"),
+		txReplayOK,
+		txReplayInsert,
+		textResultSet("a"),
+		txReplayShutdown,
+		txReplayOK, // ROLLBACK
+	})
+	err := runTxReplay(t, c, "UPDATE t SET updated = NOW() WHERE id = 5")
+	if !errors.Is(err, &MySQLError{Number: 1053}) {
+		t.Fatalf("expected the error of the failover, got %v", err)
+	}
+	if n := atomic.LoadInt32(dials); n != 1 {
+		t.Errorf("expected no new connection, got %d connections", n)
+	}
+}
+
+func TestTxReplayWithReplicas(t *testing.T) {
+	if _, err := ParseDSN("tcp(primary)/?txReplay=true&replicas=replica1"); err == nil {
+		t.Error("expected txReplay with replicas to be rejected")
+	}
+
+	cfg := NewConfig()
+	cfg.Addr = "primary"
+	cfg.TxReplay = true
+	cfg.Replicas = []string{"replica1"}
+	if _, err := NewConnector(cfg); err == nil {
+		t.Error("expected NewConnector to reject txReplay with replicas")
+	}
+}
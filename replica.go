@@ -0,0 +1,335 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"math/rand"
+	"net"
+	"strconv"
+)
+
+// replicaConn is a connection to the primary, which runs read-only
+// transactions on a connection to one of the replicas.
+type replicaConn struct {
+	*mysqlConn            // the primary
+	replica    *mysqlConn // connected on the first read-only transaction
+	tx         *mysqlConn // the replica while a transaction runs on it
+
+	// the user of the primary was changed with ChangeUser
+	userChanged bool
+}
+
+// active returns the connection on which commands run.
+func (rc *replicaConn) active() *mysqlConn {
+	if rc.tx != nil {
+		return rc.tx
+	}
+	return rc.mysqlConn
+}
+
+// connectReplica returns the connection to a replica. The replicas are tried
+// in random order. nil is returned if no replica is reachable.
+//
+// Replicas are skipped while they back off after refusing connections
+// because of their connection limits. If a replica refuses a connection, the
+// others are not tried, as they likely serve the same load.
+func (rc *replicaConn) connectReplica(ctx context.Context) *mysqlConn {
+	if rc.replica != nil {
+		return rc.replica
+	}
+	replicas := rc.connector.replicas
+	start := rand.Intn(len(replicas))
+	for i := range replicas {
+		c := replicas[(start+i)%len(replicas)]
+		if c.backingOff() {
+			continue
+		}
+		mc, err := c.connect(ctx)
+		if err == nil && rc.userChanged {
+			p := rc.mysqlConn.cfg
+			if err = mc.ChangeUser(ctx, p.User, p.Passwd, p.DBName); err != nil {
+				mc.Close()
+			}
+		}
+		if multi := rc.mysqlConn.clientFlags & clientMultiStatements; err == nil && mc.clientFlags&clientMultiStatements != multi {
+			// changed with SetMultiStatements
+			if err = mc.SetMultiStatements(ctx, multi != 0); err != nil {
+				mc.Close()
+			}
+		}
+		if err == nil {
+			rc.replica = mc
+			return mc
+		}
+		rc.log("could not connect to replica ", c.cfg.Addr, ": ", err)
+		if _, ok := err.(*TooManyConnectionsError); ok {
+			break
+		}
+	}
+	return nil
+}
+
+// replicaCaughtUp reports whether the replica has applied all transactions
+// executed on the primary within ReplicaGTIDWait.
+func (rc *replicaConn) replicaCaughtUp(ctx context.Context, replica *mysqlConn) (bool, error) {
+	if err := rc.mysqlConn.watchCancel(ctx); err != nil {
+		return false, err
+	}
+	gtid, err := rc.mysqlConn.getSystemVar("GLOBAL.gtid_executed")
+	rc.mysqlConn.finish()
+	if err != nil {
+		return false, err
+	}
+
+	// WAIT_FOR_EXECUTED_GTID_SET returns 0 on success and 1 on timeout
+	query := "SELECT WAIT_FOR_EXECUTED_GTID_SET('" + string(escapeBytesBackslash(nil, gtid)) + "', " +
+		strconv.FormatFloat(rc.cfg.ReplicaGTIDWait.Seconds(), 'f', -1, 64) + ")"
+	result, err := replica.queryValue(ctx, query)
+	if err != nil {
+		return false, err
+	}
+	return string(result) == "0", nil
+}
+
+func (rc *replicaConn) Begin() (driver.Tx, error) {
+	return rc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx runs read-only transactions on a replica. They run on the primary
+// if no replica is reachable, or if the replica did not catch up with the
+// primary within ReplicaGTIDWait.
+func (rc *replicaConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if !opts.ReadOnly {
+		return rc.mysqlConn.BeginTx(ctx, opts)
+	}
+	replica := rc.connectReplica(ctx)
+	if replica == nil {
+		return rc.mysqlConn.BeginTx(ctx, opts)
+	}
+
+	if rc.cfg.ReplicaGTIDWait > 0 {
+		ok, err := rc.replicaCaughtUp(ctx, replica)
+		if err != nil {
+			if rc.mysqlConn.closed.IsSet() {
+				return nil, driver.ErrBadConn
+			}
+			if replica.closed.IsSet() {
+				rc.replica = nil
+			}
+			rc.log("could not wait for replica: ", err)
+		}
+		if !ok {
+			return rc.mysqlConn.BeginTx(ctx, opts)
+		}
+	}
+
+	tx, err := replica.BeginTx(ctx, opts)
+	if err == driver.ErrBadConn {
+		replica.Close()
+		rc.replica = nil
+		return rc.mysqlConn.BeginTx(ctx, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc.tx = replica
+	return &replicaTx{tx: tx, rc: rc}, nil
+}
+
+func (rc *replicaConn) Prepare(query string) (driver.Stmt, error) {
+	return rc.active().Prepare(query)
+}
+
+func (rc *replicaConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return rc.active().PrepareContext(ctx, query)
+}
+
+func (rc *replicaConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return rc.active().ExecContext(ctx, query, args)
+}
+
+func (rc *replicaConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return rc.active().QueryContext(ctx, query, args)
+}
+
+func (rc *replicaConn) QueryEach(ctx context.Context, query string, args []interface{}, fn func(row RowView) error) error {
+	return rc.active().QueryEach(ctx, query, args, fn)
+}
+
+func (rc *replicaConn) QueryBatches(ctx context.Context, query string, args []interface{}, size int, fn func(batch *Batch) error) error {
+	return rc.active().QueryBatches(ctx, query, args, size, fn)
+}
+
+func (rc *replicaConn) RawCommand(ctx context.Context, command byte, arg []byte, fn func(packet []byte) (more bool, err error)) error {
+	return rc.active().RawCommand(ctx, command, arg, fn)
+}
+
+func (rc *replicaConn) Debug(ctx context.Context) error {
+	return rc.active().Debug(ctx)
+}
+
+func (rc *replicaConn) Shutdown(ctx context.Context) error {
+	return rc.active().Shutdown(ctx)
+}
+
+func (rc *replicaConn) LocalAddr() net.Addr {
+	return rc.active().LocalAddr()
+}
+
+func (rc *replicaConn) RemoteAddr() net.Addr {
+	return rc.active().RemoteAddr()
+}
+
+func (rc *replicaConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	return rc.active().TLSConnectionState()
+}
+
+// Stats returns the sum of the counters of the primary and the replica.
+func (rc *replicaConn) Stats() ConnStats {
+	stats := rc.mysqlConn.Stats()
+	if rc.replica != nil {
+		stats.add(rc.replica.Stats())
+	}
+	return stats
+}
+
+// StatementStats returns the counters of the statements of the primary and
+// the replica, summed by query. LastLatency is the one of the replica if it
+// executed the query.
+func (rc *replicaConn) StatementStats() []StmtStats {
+	if rc.replica == nil {
+		return rc.mysqlConn.StatementStats()
+	}
+	m := make(map[string]*StmtStats)
+	for _, mc := range []*mysqlConn{rc.mysqlConn, rc.replica} {
+		for query, s := range mc.stmtStats {
+			sum := m[query]
+			if sum == nil {
+				sum = &StmtStats{Query: query}
+				m[query] = sum
+			}
+			sum.Executions += s.Executions
+			sum.Rows += s.Rows
+			sum.TotalLatency += s.TotalLatency
+			if s.LastLatency != 0 {
+				sum.LastLatency = s.LastLatency
+			}
+		}
+	}
+	return sortStmtStats(m)
+}
+
+// OpenStatements returns the open statements of the primary and the replica.
+func (rc *replicaConn) OpenStatements() []OpenStatement {
+	stmts := rc.mysqlConn.OpenStatements()
+	if rc.replica != nil {
+		stmts = append(stmts, rc.replica.OpenStatements()...)
+	}
+	return stmts
+}
+
+func (rc *replicaConn) ServerVariable(ctx context.Context, name string) (string, error) {
+	return rc.active().ServerVariable(ctx, name)
+}
+
+func (rc *replicaConn) AuthInfo() AuthInfo {
+	return rc.active().AuthInfo()
+}
+
+func (rc *replicaConn) ServerVersion() ServerVersion {
+	return rc.active().ServerVersion()
+}
+
+func (rc *replicaConn) Collation() string {
+	return rc.active().Collation()
+}
+
+// SetMultiStatements changes the setting of the primary and of the replica.
+// Replicas connected later get the setting of the primary.
+func (rc *replicaConn) SetMultiStatements(ctx context.Context, enabled bool) error {
+	if err := rc.mysqlConn.SetMultiStatements(ctx, enabled); err != nil {
+		return err
+	}
+	if rc.replica != nil {
+		if err := rc.replica.SetMultiStatements(ctx, enabled); err != nil {
+			rc.log("could not change multiStatements of the replica: ", err)
+			rc.replica.Close()
+			rc.replica = nil
+		}
+	}
+	return nil
+}
+
+func (rc *replicaConn) ServerStats(ctx context.Context) (ServerStats, error) {
+	return rc.active().ServerStats(ctx)
+}
+
+// LastGTID returns the GTID of the primary, as only read-only transactions
+// run on the replica.
+func (rc *replicaConn) LastGTID() string {
+	return rc.mysqlConn.LastGTID()
+}
+
+// ChangeUser changes the user of the primary and of the replica. Replicas
+// connected later are switched to the user as well.
+func (rc *replicaConn) ChangeUser(ctx context.Context, user, passwd, dbName string) error {
+	if err := rc.mysqlConn.ChangeUser(ctx, user, passwd, dbName); err != nil {
+		return err
+	}
+	rc.userChanged = true
+	if rc.replica != nil {
+		if err := rc.replica.ChangeUser(ctx, user, passwd, dbName); err != nil {
+			rc.log("could not change the user of the replica: ", err)
+			rc.replica.Close()
+			rc.replica = nil
+		}
+	}
+	return nil
+}
+
+// ResetSession resets the primary and the replica, which switch back to the
+// user of the DSN. A replica which went bad while the connection was idle is
+// dropped and connected again when it is used.
+func (rc *replicaConn) ResetSession(ctx context.Context) error {
+	if rc.replica != nil && rc.replica.ResetSession(ctx) != nil {
+		rc.replica.Close()
+		rc.replica = nil
+	}
+	if err := rc.mysqlConn.ResetSession(ctx); err != nil {
+		return err
+	}
+	rc.userChanged = false
+	return nil
+}
+
+func (rc *replicaConn) Close() error {
+	if rc.replica != nil {
+		rc.replica.Close()
+		rc.replica = nil
+	}
+	return rc.mysqlConn.Close()
+}
+
+type replicaTx struct {
+	tx driver.Tx
+	rc *replicaConn
+}
+
+func (tx *replicaTx) Commit() error {
+	tx.rc.tx = nil
+	return tx.tx.Commit()
+}
+
+func (tx *replicaTx) Rollback() error {
+	tx.rc.tx = nil
+	return tx.tx.Rollback()
+}
@@ -0,0 +1,188 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestReplicaReadOnlyTx(t *testing.T) {
+	okPacket := []byte{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}
+	scripts := map[string][][]byte{
+		"primary:3306": {serverHandshake, serverAuthOK, textResultSet("primary")},
+		"replica:3306": {serverHandshake, serverAuthOK, okPacket, textResultSet("replica"), okPacket},
+	}
+	RegisterDialContext("replicatest", func(ctx context.Context, addr string) (net.Conn, error) {
+		replies, ok := scripts[addr]
+		if !ok {
+			return nil, errors.New("unknown address " + addr)
+		}
+		client, server := net.Pipe()
+		go serveReplies(server, replies)
+		return client, nil
+	})
+
+	db, err := sql.Open("mysql", "root@replicatest(primary:3306)/?maxAllowedPacket=4194304&replicas=replica:3306")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := tx.QueryRow("SELECT v").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != "replica" {
+		t.Errorf("read-only transaction ran on %s, expected replica", v)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.QueryRow("SELECT v").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != "primary" {
+		t.Errorf("query ran on %s, expected primary", v)
+	}
+}
+
+func TestReplicaUnreachable(t *testing.T) {
+	okPacket := []byte{7, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}
+	RegisterDialContext("replicadown", func(ctx context.Context, addr string) (net.Conn, error) {
+		if addr != "primary:3306" {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		go serveReplies(server, [][]byte{serverHandshake, serverAuthOK, okPacket, textResultSet("primary"), okPacket})
+		return client, nil
+	})
+
+	db, err := sql.Open("mysql", "root@replicadown(primary:3306)/?maxAllowedPacket=4194304&replicas=replica:3306")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := tx.QueryRow("SELECT v").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != "primary" {
+		t.Errorf("read-only transaction ran on %s, expected primary", v)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicaTooManyConnections(t *testing.T) {
+	// ER_CON_COUNT_ERROR, sent instead of the handshake
+	refusal := append([]byte{23, 0, 0, 0, 0xff, 0x10, 0x04}, "Too many connections"...)
+	dials := 0
+	RegisterDialContext("replicafull", func(ctx context.Context, addr string) (net.Conn, error) {
+		dials++
+		client, server := net.Pipe()
+		go serveReplies(server, [][]byte{refusal})
+		return client, nil
+	})
+
+	cfg := NewConfig()
+	cfg.Net = "replicafull"
+	cfg.Addr = "primary:3306"
+	cfg.Replicas = []string{"replica1:3306", "replica2:3306"}
+	rc := &replicaConn{mysqlConn: &mysqlConn{cfg: cfg, connector: newConnector(cfg)}}
+
+	// the other replica is not tried after a refusal
+	if rc.connectReplica(context.Background()) != nil {
+		t.Fatal("expected no replica connection")
+	}
+	if dials != 1 {
+		t.Errorf("expected 1 dial, got %d", dials)
+	}
+
+	// the refusing replica is skipped while it backs off
+	if rc.connectReplica(context.Background()) != nil {
+		t.Fatal("expected no replica connection")
+	}
+	if dials != 2 {
+		t.Errorf("expected 2 dials, got %d", dials)
+	}
+	if rc.connectReplica(context.Background()) != nil {
+		t.Fatal("expected no replica connection")
+	}
+	if dials != 2 {
+		t.Errorf("expected no dial while the replicas back off, got %d dials", dials)
+	}
+}
+
+func TestReplicaSetMultiStatements(t *testing.T) {
+	primaryConn, primary := newRWMockConn(0)
+	replicaMock, replica := newRWMockConn(0)
+	for _, conn := range []*mockConn{primaryConn, replicaMock} {
+		conn.queuedReplies = [][]byte{{5, 0, 0, 1, iEOF, 0, 0, 2, 0}}
+	}
+	rc := &replicaConn{mysqlConn: primary, replica: replica}
+	if err := rc.SetMultiStatements(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	for _, mc := range []*mysqlConn{primary, replica} {
+		if mc.clientFlags&clientMultiStatements == 0 {
+			t.Error("expected multi statements to be enabled on the primary and the replica")
+		}
+	}
+}
+
+func TestReplicaChangeUserReset(t *testing.T) {
+	primaryConn, primary := newRWMockConn(0)
+	replicaMock, replica := newRWMockConn(0)
+	for _, mc := range []*mysqlConn{primary, replica} {
+		mc.cfg.User = "app"
+		mc.cfg.CheckConnLiveness = false
+		mc.flags = clientProtocol41 | clientSecureConn | clientPluginAuth
+		mc.clientFlags = mc.flags
+		mc.scramble = make([]byte, 20)
+		mc.authPlugin = "mysql_native_password"
+	}
+	for _, conn := range []*mockConn{primaryConn, replicaMock} {
+		conn.queuedReplies = [][]byte{
+			{7, 0, 0, 1, iOK, 0, 0, 2, 0, 0, 0},
+			{7, 0, 0, 1, iOK, 0, 0, 2, 0, 0, 0},
+		}
+	}
+	rc := &replicaConn{mysqlConn: primary, replica: replica}
+	if err := rc.ChangeUser(context.Background(), "tenant", "secret", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.ResetSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, mc := range []*mysqlConn{primary, replica} {
+		if mc.cfg.User != "app" || mc.dsnCfg != nil {
+			t.Errorf("expected the user of the DSN, got %q", mc.cfg.User)
+		}
+	}
+	if rc.userChanged {
+		t.Error("expected replicas connected later to keep the user of the DSN")
+	}
+}
@@ -8,9 +8,72 @@
 
 package mysql
 
+import (
+	"database/sql/driver"
+	"errors"
+)
+
+// ErrUnknownAutoIncrementIncrement is returned by Result.InsertIDs for
+// statements which inserted several rows if the auto_increment_increment of
+// the session is not known.
+var ErrUnknownAutoIncrementIncrement = errors.New("auto_increment_increment of the session is unknown; set it in the DSN, read it with ServerVariable or add it to session_track_system_variables")
+
+// Result is the driver.Result of Exec. database/sql hides it, so it is only
+// available if Exec of the driver connection is called through sql.Conn.Raw:
+//
+//	err := conn.Raw(func(driverConn interface{}) error {
+//		ctx := mysql.WithReturning(ctx, 100)
+//		res, err := driverConn.(driver.ExecerContext).ExecContext(ctx, query, args)
+//		if err != nil {
+//			return err
+//		}
+//		columns, rows := res.(mysql.Result).Returning()
+//		...
+//	})
+//
+// Alternatively, statements which return rows can be run with Query.
+type Result interface {
+	driver.Result
+
+	// Returning returns the result set of a statement which returned rows
+	// instead of only an OK packet, like INSERT ... RETURNING and
+	// DELETE ... RETURNING of MariaDB. The rows are only kept if Exec was
+	// run with a context from WithReturning, up to the number of rows it
+	// allows, and only for the first result set. columns and rows are nil if
+	// the statement returned no rows or they were discarded.
+	Returning() (columns []string, rows [][]driver.Value)
+
+	// InsertIDs returns the AUTO_INCREMENT IDs generated for the rows
+	// inserted by the statement. The server only reports the first ID, the
+	// others are derived from it, the number of affected rows and the
+	// auto_increment_increment of the session. This is exact for INSERTs
+	// with a known number of rows, which InnoDB assigns consecutive IDs in
+	// every innodb_autoinc_lock_mode, but not for INSERT ... SELECT,
+	// INSERT IGNORE or ON DUPLICATE KEY UPDATE statements.
+	//
+	// The driver does not query auto_increment_increment itself. It is
+	// known if it is set in the DSN, was read with ServerVariable of the
+	// connection, or is reported by the session tracking of the server
+	// after it is added to session_track_system_variables. Otherwise
+	// InsertIDs returns ErrUnknownAutoIncrementIncrement for statements
+	// which inserted several rows. SET and CALL statements and multiple
+	// statements make it unknown again, unless the server reports it.
+	InsertIDs() ([]int64, error)
+}
+
 type mysqlResult struct {
 	affectedRows int64
 	insertId     int64
+	returning    *returningRows
+	increment    int64 // auto_increment_increment, 0 if unknown
+}
+
+var _ Result = &mysqlResult{}
+
+// returningRows is a result set returned by Exec.
+type returningRows struct {
+	columns []string
+	rows    [][]driver.Value
 }
 
 func (res *mysqlResult) LastInsertId() (int64, error) {
@@ -20,3 +83,27 @@ func (res *mysqlResult) LastInsertId() (int64, error) {
 func (res *mysqlResult) RowsAffected() (int64, error) {
 	return res.affectedRows, nil
 }
+
+func (res *mysqlResult) Returning() ([]string, [][]driver.Value) {
+	if res.returning == nil {
+		return nil, nil
+	}
+	return res.returning.columns, res.returning.rows
+}
+
+func (res *mysqlResult) InsertIDs() ([]int64, error) {
+	if res.insertId == 0 {
+		return nil, nil
+	}
+	if res.affectedRows <= 1 {
+		return []int64{res.insertId}, nil
+	}
+	if res.increment == 0 {
+		return nil, ErrUnknownAutoIncrementIncrement
+	}
+	ids := make([]int64, res.affectedRows)
+	for i := range ids {
+		ids[i] = res.insertId + int64(i)*res.increment
+	}
+	return ids, nil
+}
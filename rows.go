@@ -13,18 +13,62 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"time"
 )
 
 type resultSet struct {
 	columns     []mysqlField
 	columnNames []string
 	done        bool
+	numRows     int64
 }
 
 type mysqlRows struct {
-	mc     *mysqlConn
-	rs     resultSet
-	finish func()
+	mc      *mysqlConn
+	rs      resultSet
+	finish  func()
+	maxRows int64 // set by WithMaxRows
+	tooMany bool  // the result set exceeded maxRows
+	status  ResultStatus
+
+	stmtStats *StmtStats // of the prepared statement, for binary rows
+
+	rowTimeout time.Duration // set by WithRowTimeout
+}
+
+// ResultStatus is the status the server reports in the OK packet which ends
+// the results of a statement.
+type ResultStatus struct {
+	AffectedRows int64
+	LastInsertID int64
+	Warnings     uint16
+}
+
+// Rows is implemented by the driver.Rows returned by Query and QueryContext.
+// database/sql does not expose the driver rows, the methods are available
+// when the queries are sent through sql.Conn.Raw.
+type Rows interface {
+	driver.RowsNextResultSet
+
+	// Status returns the status of the final OK packet, e.g. the affected
+	// rows of the last statement of a stored procedure called with CALL.
+	// It is set once NextResultSet returns io.EOF or the rows are closed.
+	// With WithEmptyResultSets, it is also set for each result set without
+	// columns, i.e. the OK packet of a statement which returns no rows.
+	Status() ResultStatus
+}
+
+func (rows *mysqlRows) Status() ResultStatus {
+	return rows.status
+}
+
+// saveStatus records the status of the last OK packet read by mc.
+func (rows *mysqlRows) saveStatus(mc *mysqlConn) {
+	rows.status = ResultStatus{
+		AffectedRows: int64(mc.affectedRows),
+		LastInsertID: int64(mc.insertId),
+		Warnings:     mc.warnings,
+	}
 }
 
 type binaryRows struct {
@@ -119,19 +163,33 @@ func (rows *mysqlRows) Close() (err error) {
 	mc.buf.flip()
 
 	// Remove unread packets from stream
-	if !rows.rs.done {
-		err = mc.readUntilEOF()
+	if !rows.rs.done && rows.tooMany {
+		err = mc.discardRows()
+	} else if !rows.rs.done {
+		err = mc.drainRows()
 	}
 	if err == nil {
 		if err = mc.discardResults(); err != nil {
 			return err
 		}
+		rows.saveStatus(mc)
 	}
 
 	rows.mc = nil
 	return err
 }
 
+// countRow enforces the limit set by WithMaxRows. Close kills the query, so
+// the server stops sending the rows which were not read.
+func (rows *mysqlRows) countRow() error {
+	rows.rs.numRows++
+	if rows.maxRows > 0 && rows.rs.numRows > rows.maxRows {
+		rows.tooMany = true
+		return &MaxRowsError{Max: rows.maxRows}
+	}
+	return nil
+}
+
 func (rows *mysqlRows) HasNextResultSet() (b bool) {
 	if rows.mc == nil {
 		return false
@@ -156,6 +214,7 @@ func (rows *mysqlRows) nextResultSet() (int, error) {
 	}
 
 	if !rows.HasNextResultSet() {
+		rows.saveStatus(rows.mc)
 		rows.mc = nil
 		return 0, io.EOF
 	}
@@ -175,6 +234,10 @@ func (rows *mysqlRows) nextNotEmptyResultSet() (int, error) {
 		}
 
 		rows.rs.done = true
+		if rows.mc.emptyResults {
+			rows.saveStatus(rows.mc)
+			return 0, nil
+		}
 	}
 }
 
@@ -183,11 +246,47 @@ func (rows *binaryRows) NextResultSet() error {
 	if err != nil {
 		return err
 	}
+	if resLen == 0 {
+		rows.rs.columns = nil
+		return nil
+	}
 
 	rows.rs.columns, err = rows.mc.readColumns(resLen)
 	return err
 }
 
+// startRowTimeout sets the read deadline for the next row if the rows were
+// queried with WithRowTimeout. The read timeout of the connection is
+// suspended meanwhile, as it moves the deadline with every read.
+func (rows *mysqlRows) startRowTimeout() time.Time {
+	if rows.rowTimeout <= 0 {
+		return time.Time{}
+	}
+	deadline := time.Now().Add(rows.rowTimeout)
+	rows.mc.buf.timeout = 0
+	// if this fails, so does the read
+	rows.mc.netConn.SetReadDeadline(deadline)
+	return deadline
+}
+
+// endRowTimeout restores the read timeout of mc after a row has been read
+// with the deadline returned by startRowTimeout, and reports the expired
+// deadline as a *RowTimeoutError.
+func (rows *mysqlRows) endRowTimeout(mc *mysqlConn, deadline time.Time, err error) error {
+	if deadline.IsZero() {
+		return err
+	}
+	mc.buf.timeout = mc.cfg.ReadTimeout
+	if err == ErrInvalidConn && mc.canceled.Value() == nil && !time.Now().Before(deadline) {
+		return &RowTimeoutError{Timeout: rows.rowTimeout}
+	}
+	if mc.buf.timeout == 0 && !mc.closed.IsSet() {
+		// otherwise the next read sets its own deadline
+		mc.netConn.SetReadDeadline(time.Time{})
+	}
+	return err
+}
+
 func (rows *binaryRows) Next(dest []driver.Value) error {
 	if mc := rows.mc; mc != nil {
 		if err := mc.error(); err != nil {
@@ -195,7 +294,12 @@ func (rows *binaryRows) Next(dest []driver.Value) error {
 		}
 
 		// Fetch next row from stream
-		return rows.readRow(dest)
+		deadline := rows.startRowTimeout()
+		err := rows.readRow(dest)
+		if err = rows.endRowTimeout(mc, deadline, err); err != nil {
+			return err
+		}
+		return rows.countRow()
 	}
 	return io.EOF
 }
@@ -205,6 +309,10 @@ func (rows *textRows) NextResultSet() (err error) {
 	if err != nil {
 		return err
 	}
+	if resLen == 0 {
+		rows.rs.columns = nil
+		return nil
+	}
 
 	rows.rs.columns, err = rows.mc.readColumns(resLen)
 	return err
@@ -217,7 +325,12 @@ func (rows *textRows) Next(dest []driver.Value) error {
 		}
 
 		// Fetch next row from stream
-		return rows.readRow(dest)
+		deadline := rows.startRowTimeout()
+		err := rows.readRow(dest)
+		if err = rows.endRowTimeout(mc, deadline, err); err != nil {
+			return err
+		}
+		return rows.countRow()
 	}
 	return io.EOF
 }
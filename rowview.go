@@ -0,0 +1,141 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RowView is a row of a result set passed to the callback of Conn.QueryEach.
+// The values refer to the read buffer of the connection and are only valid
+// until the callback returns. Copy them to keep them longer.
+type RowView struct {
+	columns []mysqlField
+	values  [][]byte
+}
+
+// NumColumns returns the number of columns.
+func (r RowView) NumColumns() int {
+	return len(r.values)
+}
+
+// ColumnName returns the name of the i-th column.
+func (r RowView) ColumnName(i int) string {
+	return r.columns[i].name
+}
+
+// IsNull reports whether the value of the i-th column is NULL.
+func (r RowView) IsNull(i int) bool {
+	return r.values[i] == nil
+}
+
+// Bytes returns the value of the i-th column in its text form, or nil if it
+// is NULL. The slice is only valid until the callback returns.
+func (r RowView) Bytes(i int) []byte {
+	return r.values[i]
+}
+
+// String returns the value of the i-th column in its text form, or "" if it
+// is NULL.
+func (r RowView) String(i int) string {
+	return string(r.values[i])
+}
+
+// Int64 returns the value of the i-th column as an int64.
+func (r RowView) Int64(i int) (int64, error) {
+	if r.values[i] == nil {
+		return 0, r.nullError(i)
+	}
+	return strconv.ParseInt(string(r.values[i]), 10, 64)
+}
+
+// Uint64 returns the value of the i-th column as an uint64.
+func (r RowView) Uint64(i int) (uint64, error) {
+	if r.values[i] == nil {
+		return 0, r.nullError(i)
+	}
+	return strconv.ParseUint(string(r.values[i]), 10, 64)
+}
+
+// Float64 returns the value of the i-th column as a float64.
+func (r RowView) Float64(i int) (float64, error) {
+	if r.values[i] == nil {
+		return 0, r.nullError(i)
+	}
+	return strconv.ParseFloat(string(r.values[i]), 64)
+}
+
+func (r RowView) nullError(i int) error {
+	return fmt.Errorf("column %d (%s) is NULL", i, r.columns[i].name)
+}
+
+// QueryEach implements Conn interface.
+// The query is sent with the text protocol, so the rows are read into the
+// buffer of the connection and passed to fn without converting them.
+func (mc *mysqlConn) QueryEach(ctx context.Context, query string, args []interface{}, fn func(row RowView) error) error {
+	dargs := make([]driver.Value, len(args))
+	for i, arg := range args {
+		v, err := converter{}.ConvertValue(arg)
+		if err != nil {
+			return err
+		}
+		dargs[i] = v
+	}
+	query, err := mc.rewriteQuery(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if err := mc.watchCancel(ctx); err != nil {
+		return err
+	}
+	defer mc.finish()
+
+	if mc.closed.IsSet() {
+		mc.log(ErrInvalidConn)
+		return driver.ErrBadConn
+	}
+	if len(dargs) != 0 {
+		prepared, err := mc.interpolateParams(query, dargs)
+		if err == driver.ErrSkip {
+			return errors.New("the arguments can not be interpolated into the query")
+		}
+		if err != nil {
+			return err
+		}
+		query = prepared
+	}
+
+	rows, err := mc.query(query, nil)
+	if err != nil {
+		return err
+	}
+
+	row := RowView{
+		columns: rows.rs.columns,
+		values:  make([][]byte, len(rows.rs.columns)),
+	}
+	for {
+		if err = rows.readRowView(row.values); err != nil {
+			break
+		}
+		if err = fn(row); err != nil {
+			break
+		}
+	}
+	if cerr := rows.Close(); err == io.EOF {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScanStruct copies the columns of the current row into the fields of the
+// struct pointed to by dest.
+//
+// A column is stored in the field with a matching `mysql:"name"` tag, or else
+// in the field with the same name, compared case-insensitively. Fields of
+// embedded structs are matched as well. Fields tagged with `mysql:"-"` and
+// unexported fields are ignored, and so are columns without a field.
+//
+// The values are converted like Rows.Scan does. In addition, DATETIME, DATE
+// and TIMESTAMP values can be stored in time.Time and *time.Time fields even
+// if parseTime is not enabled. Such values are interpreted as UTC.
+//
+//	type User struct {
+//		ID      uint64 `mysql:"id"`
+//		Name    string
+//		Created time.Time `mysql:"created_at"`
+//	}
+//	for rows.Next() {
+//		var u User
+//		if err := mysql.ScanStruct(rows, &u); err != nil {
+//			return err
+//		}
+//	}
+func ScanStruct(rows *sql.Rows, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected a non-nil pointer to a struct, got %T", dest)
+	}
+	v = v.Elem()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	fields := structFields(v.Type())
+
+	args := make([]interface{}, len(columns))
+	for i, name := range columns {
+		index, ok := fields[strings.ToLower(name)]
+		if !ok {
+			args[i] = new(sql.RawBytes)
+			continue
+		}
+		field := v.FieldByIndex(index)
+		switch field.Type() {
+		case timeType, timePtrType:
+			args[i] = timeScanner{field}
+		default:
+			args[i] = field.Addr().Interface()
+		}
+	}
+	return rows.Scan(args...)
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	timePtrType = reflect.TypeOf((*time.Time)(nil))
+
+	// map[reflect.Type]map[string][]int
+	structFieldsCache sync.Map
+)
+
+// structFields returns the index of the fields of the struct type t by their
+// lower case column name.
+func structFields(t reflect.Type) map[string][]int {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	addStructFields(fields, t, nil)
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func addStructFields(fields map[string][]int, t reflect.Type, index []int) {
+	// Fields of embedded structs are added last, so that they cannot shadow
+	// the fields of the outer struct.
+	var embedded []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("mysql")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, i)
+			continue
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = f.Name
+		}
+		name = strings.ToLower(name)
+		if _, ok := fields[name]; !ok {
+			fields[name] = append(index[:len(index):len(index)], i)
+		}
+	}
+	for _, i := range embedded {
+		addStructFields(fields, t.Field(i).Type, append(index[:len(index):len(index)], i))
+	}
+}
+
+// timeScanner scans date and time values into a time.Time or *time.Time
+// field.
+type timeScanner struct {
+	field reflect.Value
+}
+
+func (ts timeScanner) Scan(src interface{}) error {
+	var nt NullTime
+	if err := nt.Scan(src); err != nil {
+		return err
+	}
+	switch {
+	case ts.field.Type() == timeType && !nt.Valid:
+		return errors.New("converting NULL to time.Time is unsupported")
+	case ts.field.Type() == timeType:
+		ts.field.Set(reflect.ValueOf(nt.Time))
+	case !nt.Valid:
+		ts.field.Set(reflect.Zero(timePtrType))
+	default:
+		t := nt.Time
+		ts.field.Set(reflect.ValueOf(&t))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+	"time"
+)
+
+// textResultSetColumns returns the response to COM_QUERY with a result set of
+// VARCHAR columns. A nil value is sent as NULL.
+func textResultSetColumns(columns []string, rows ...[]interface{}) []byte {
+	var fields []testColumn
+	for _, name := range columns {
+		fields = append(fields, testColumn{name: name, fieldType: fieldTypeVarString})
+	}
+	return textResultSetFields(fields, rows...)
+}
+
+// textResultSetFields is like textResultSetColumns, with the types of the
+// columns.
+func textResultSetFields(columns []testColumn, rows ...[]interface{}) []byte {
+	reply := appendTestPacket(nil, 1, byte(len(columns))) // column count
+	reply = appendColumns(reply, 2, columns...)
+	seq := byte(3 + len(columns))
+	for _, row := range rows {
+		var data []byte
+		for _, v := range row {
+			if v == nil {
+				data = append(data, 0xfb)
+			} else {
+				data = appendLengthEncodedString(data, v.(string))
+			}
+		}
+		reply = appendTestPacket(reply, seq, data...)
+		seq++
+	}
+	return appendTestPacket(reply, seq, iEOF, 0x00, 0x00, 0x02, 0x00)
+}
+
+type scanBase struct {
+	ID   uint64 `mysql:"id"`
+	Name string
+}
+
+type scanUser struct {
+	scanBase
+	Name    string     // shadows scanBase.Name
+	Created time.Time  `mysql:"created_at"`
+	Deleted *time.Time `mysql:"deleted_at"`
+	Score   *int
+	Ignored string `mysql:"-"`
+	secret  string
+}
+
+func TestScanStruct(t *testing.T) {
+	reply := textResultSetColumns(
+		[]string{"id", "NAME", "created_at", "deleted_at", "score", "ignored", "secret", "extra"},
+		[]interface{}{"18446744073709551615", "gopher", "2021-02-03 04:05:06", nil, "42", "x", "y", "z"},
+		[]interface{}{"2", "mole", "2021-02-03", "2021-03-04 05:06:07", nil, "x", "y", "z"},
+	)
+	RegisterDialContext("scanstruct", func(ctx context.Context, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveReplies(server, [][]byte{serverHandshake, serverAuthOK, reply})
+		return client, nil
+	})
+	db, err := sql.Open("mysql", "root@scanstruct(localhost:3306)/?maxAllowedPacket=4194304")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var users []scanUser
+	for rows.Next() {
+		var u scanUser
+		if err := ScanStruct(rows, &u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(users))
+	}
+
+	u := users[0]
+	if u.ID != 18446744073709551615 || u.Name != "gopher" || u.scanBase.Name != "" {
+		t.Errorf("unexpected user: %+v", u)
+	}
+	if !u.Created.Equal(time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)) || u.Deleted != nil {
+		t.Errorf("unexpected times: %v, %v", u.Created, u.Deleted)
+	}
+	if u.Score == nil || *u.Score != 42 || u.Ignored != "" || u.secret != "" {
+		t.Errorf("unexpected user: %+v", u)
+	}
+
+	u = users[1]
+	if u.ID != 2 || u.Score != nil {
+		t.Errorf("unexpected user: %+v", u)
+	}
+	if u.Deleted == nil || !u.Deleted.Equal(time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)) {
+		t.Errorf("unexpected deleted_at: %v", u.Deleted)
+	}
+}
+
+func TestScanStructInvalidDest(t *testing.T) {
+	var u scanUser
+	for _, dest := range []interface{}{u, (*scanUser)(nil), new(int)} {
+		if err := ScanStruct(nil, dest); err == nil {
+			t.Errorf("%T: expected an error", dest)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SessionTrackType is the type of a change of the session state, which the
+// server reports in OK packets.
+// https://dev.mysql.com/doc/dev/mysql-server/latest/mysql__com_8h.html
+type SessionTrackType uint8
+
+const (
+	SessionTrackSystemVariables SessionTrackType = iota
+	SessionTrackSchema
+	SessionTrackStateChange
+	SessionTrackGTIDs
+	SessionTrackTransactionCharacteristics
+	SessionTrackTransactionState
+)
+
+// SessionStateChange is a change of the session state reported by the
+// server, which is passed to Config.SessionStateChanged.
+type SessionStateChange struct {
+	Type SessionTrackType
+
+	// Name is the name of the system variable of
+	// SessionTrackSystemVariables, and empty for the other types.
+	Name string
+
+	// Value is the new value of the system variable, the new default
+	// schema, "1" if SessionTrackStateChange reports a change, the GTIDs
+	// of the last transaction, the SQL statements which restore the
+	// characteristics of the transaction or the state of the transaction,
+	// e.g. "T_______". It is the raw data of unknown types.
+	Value string
+}
+
+// sqlMode are the modes of the session which change how strings and
+// identifiers are quoted in queries.
+type sqlMode uint8
+
+const (
+	modeNoBackslashEscapes sqlMode = 1 << iota
+	modeANSIQuotes
+)
+
+// sqlMode returns the quoting modes of the session. NO_BACKSLASH_ESCAPES is
+// reported in the status of every reply. ANSI_QUOTES is known if sql_mode
+// is set in the DSN, reported by the session tracking of the server, or
+// queried with ServerVariable.
+func (mc *mysqlConn) sqlMode() sqlMode {
+	var mode sqlMode
+	if mc.status&statusNoBackslashEscapes != 0 {
+		mode |= modeNoBackslashEscapes
+	}
+	if mc.ansiQuotes {
+		mode |= modeANSIQuotes
+	}
+	return mode
+}
+
+// hasANSIQuotes reports whether the value of sql_mode enables ANSI_QUOTES,
+// also as part of the ANSI mode.
+func hasANSIQuotes(sqlMode string) bool {
+	for _, mode := range strings.Split(strings.Trim(sqlMode, "'\""), ",") {
+		switch strings.ToUpper(strings.TrimSpace(mode)) {
+		case "ANSI_QUOTES", "ANSI":
+			return true
+		}
+	}
+	return false
+}
+
+// sessionVarChanged is called with the new value of a system variable of the
+// session.
+func (mc *mysqlConn) sessionVarChanged(name, value string) {
+	switch name {
+	case "sql_mode":
+		mc.ansiQuotes = hasANSIQuotes(value)
+	case "auto_increment_increment":
+		// 0, i.e. unknown, if the value is invalid
+		mc.autoIncIncrement, _ = strconv.ParseInt(strings.Trim(value, "'\""), 10, 64)
+	}
+	if _, ok := mc.sessionVars[name]; ok {
+		mc.sessionVars[name] = value
+	}
+}
+
+func (mc *mysqlConn) ServerVariable(ctx context.Context, name string) (string, error) {
+	name = strings.ToLower(name)
+	if v, ok := mc.sessionVars[name]; ok {
+		return v, nil
+	}
+	if !isVariableName(name) {
+		return "", fmt.Errorf("invalid system variable name %q", name)
+	}
+
+	if mc.closed.IsSet() {
+		mc.log(ErrInvalidConn)
+		return "", driver.ErrBadConn
+	}
+	if err := mc.watchCancel(ctx); err != nil {
+		return "", err
+	}
+	defer mc.finish()
+
+	v, err := mc.getSystemVar(name)
+	if err != nil {
+		return "", err
+	}
+	if mc.sessionVars == nil {
+		mc.sessionVars = make(map[string]string)
+	}
+	mc.sessionVars[name] = string(v)
+	mc.sessionVarChanged(name, string(v))
+	return string(v), nil
+}
+
+func (mc *mysqlConn) LastGTID() string {
+	return mc.lastGTID
+}
+
+// isVariableName reports whether name can be used in SELECT @@name.
+func isVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// readSessionState returns the session state changes following the info of
+// an OK packet.
+func readSessionState(b []byte) ([]byte, error) {
+	n, err := skipLengthEncodedString(b)
+	if err != nil {
+		return nil, err
+	}
+	state, _, _, err := readLengthEncodedString(b[n:])
+	return state, err
+}
+
+// parseSessionStateChange parses the data of a change of the session state.
+func parseSessionStateChange(typ SessionTrackType, data []byte) (SessionStateChange, error) {
+	change := SessionStateChange{Type: typ}
+	switch typ {
+	case SessionTrackSystemVariables:
+		name, _, n, err := readLengthEncodedString(data)
+		if err != nil {
+			return change, err
+		}
+		value, _, _, err := readLengthEncodedString(data[n:])
+		if err != nil {
+			return change, err
+		}
+		change.Name = strings.ToLower(string(name))
+		change.Value = string(value)
+	case SessionTrackGTIDs:
+		// encoding specification [1 byte], always 0
+		if len(data) == 0 {
+			return change, ErrMalformPkt
+		}
+		data = data[1:]
+		fallthrough
+	case SessionTrackSchema, SessionTrackStateChange,
+		SessionTrackTransactionCharacteristics, SessionTrackTransactionState:
+		value, _, _, err := readLengthEncodedString(data)
+		if err != nil {
+			return change, err
+		}
+		change.Value = string(value)
+	default:
+		change.Value = string(data)
+	}
+	return change, nil
+}
+
+// handleSessionState updates the cached variables of the session with the
+// changes the server reported in an OK packet and passes them to the
+// SessionStateChanged callback.
+func (mc *mysqlConn) handleSessionState(state []byte) {
+	for len(state) > 0 {
+		typ := SessionTrackType(state[0])
+		data, _, n, err := readLengthEncodedString(state[1:])
+		if err != nil {
+			// malformed, the cache can't be trusted anymore
+			mc.sessionVars = nil
+			return
+		}
+		state = state[1+n:]
+
+		change, err := parseSessionStateChange(typ, data)
+		if err != nil {
+			mc.sessionVars = nil
+			return
+		}
+		switch typ {
+		case SessionTrackSystemVariables:
+			mc.sessionVarChanged(change.Name, change.Value)
+		case SessionTrackGTIDs:
+			mc.lastGTID = change.Value
+		}
+		if mc.cfg.SessionStateChanged != nil {
+			mc.cfg.SessionStateChanged(change)
+		}
+	}
+}
+
+// invalidateSessionVars empties the cached variables of the session if the
+// query of the user may change variables the server doesn't track.
+func (mc *mysqlConn) invalidateSessionVars(query string) {
+	if len(mc.sessionVars) == 0 && mc.autoIncIncrement == 0 {
+		return
+	}
+	stmt := parseStatement(query, mc.sqlMode())
+	switch {
+	case stmt.Multi, stmt.Keyword == "SET", stmt.Keyword == "CALL":
+		mc.sessionVars = nil
+		mc.autoIncIncrement = 0
+	}
+}
@@ -0,0 +1,149 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"testing"
+)
+
+func TestServerVariable(t *testing.T) {
+	conn, mc := newRWMockConn(0)
+	mc.clientFlags = clientSessionTrack
+	conn.queuedReplies = [][]byte{textResultSet("STRICT_TRANS_TABLES"), textResultSet("8.0.26")}
+
+	for i := 0; i < 2; i++ {
+		v, err := mc.ServerVariable(context.Background(), "SQL_MODE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != "STRICT_TRANS_TABLES" {
+			t.Errorf("unexpected value %q", v)
+		}
+	}
+	if conn.writes != 1 {
+		t.Errorf("expected the value to be cached, got %d queries", conn.writes)
+	}
+
+	// a change reported by the session tracking updates the cache
+	var sysvar []byte
+	sysvar = appendLengthEncodedString(sysvar, "sql_mode")
+	sysvar = appendLengthEncodedString(sysvar, "ANSI")
+	state := appendLengthEncodedString([]byte{byte(SessionTrackSystemVariables)}, string(sysvar))
+	ok := appendLengthEncodedString([]byte{iOK, 0, 0, 0x02, 0x40, 0, 0, 0}, string(state))
+	if err := mc.handleOkPacket(ok); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mc.ServerVariable(context.Background(), "sql_mode"); v != "ANSI" {
+		t.Errorf("expected the tracked value, got %q", v)
+	}
+	if mc.sqlMode() != modeANSIQuotes {
+		t.Errorf("expected ANSI_QUOTES to be tracked, got mode %d", mc.sqlMode())
+	}
+
+	// SET statements empty the cache
+	mc.invalidateSessionVars("SELECT 1")
+	if len(mc.sessionVars) != 1 {
+		t.Error("expected the cache to be kept")
+	}
+	mc.invalidateSessionVars("/* set */ SET @@session.sql_mode = ''")
+	if len(mc.sessionVars) != 0 {
+		t.Error("expected the cache to be emptied")
+	}
+	if v, err := mc.ServerVariable(context.Background(), "version"); err != nil || v != "8.0.26" {
+		t.Errorf("unexpected value %q, %v", v, err)
+	}
+
+	if _, err := mc.ServerVariable(context.Background(), "version; DROP TABLE t"); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+}
+
+func TestSessionStateChanged(t *testing.T) {
+	_, mc := newRWMockConn(0)
+	mc.clientFlags = clientSessionTrack
+	var changes []SessionStateChange
+	mc.cfg.SessionStateChanged = func(change SessionStateChange) {
+		changes = append(changes, change)
+	}
+
+	var sysvar []byte
+	sysvar = appendLengthEncodedString(sysvar, "AUTOCOMMIT")
+	sysvar = appendLengthEncodedString(sysvar, "OFF")
+	var state []byte
+	state = appendLengthEncodedString(append(state, byte(SessionTrackSystemVariables)), string(sysvar))
+	state = appendLengthEncodedString(append(state, byte(SessionTrackSchema)), string(appendLengthEncodedString(nil, "test")))
+	gtids := appendLengthEncodedString([]byte{0}, "3e11fa47-71ca-11e1-9e33-c80aa9429562:23")
+	state = appendLengthEncodedString(append(state, byte(SessionTrackGTIDs)), string(gtids))
+	state = appendLengthEncodedString(append(state, byte(SessionTrackTransactionState)), string(appendLengthEncodedString(nil, "T_______")))
+	ok := appendLengthEncodedString([]byte{iOK, 0, 0, 0x02, 0x40, 0, 0, 0}, string(state))
+	if err := mc.handleOkPacket(ok); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SessionStateChange{
+		{Type: SessionTrackSystemVariables, Name: "autocommit", Value: "OFF"},
+		{Type: SessionTrackSchema, Value: "test"},
+		{Type: SessionTrackGTIDs, Value: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"},
+		{Type: SessionTrackTransactionState, Value: "T_______"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("expected %d changes, got %v", len(want), changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d: expected %+v, got %+v", i, want[i], changes[i])
+		}
+	}
+}
+
+func TestLastGTID(t *testing.T) {
+	_, mc := newRWMockConn(0)
+	mc.clientFlags = clientSessionTrack
+	if gtid := mc.LastGTID(); gtid != "" {
+		t.Errorf("expected no GTID, got %q", gtid)
+	}
+
+	gtids := appendLengthEncodedString([]byte{0}, "3e11fa47-71ca-11e1-9e33-c80aa9429562:23")
+	state := appendLengthEncodedString([]byte{byte(SessionTrackGTIDs)}, string(gtids))
+	ok := appendLengthEncodedString([]byte{iOK, 0, 0, 0x02, 0x40, 0, 0, 0}, string(state))
+	if err := mc.handleOkPacket(ok); err != nil {
+		t.Fatal(err)
+	}
+	if gtid := mc.LastGTID(); gtid != "3e11fa47-71ca-11e1-9e33-c80aa9429562:23" {
+		t.Errorf("unexpected GTID %q", gtid)
+	}
+
+	// replies without GTIDs keep the last one
+	if err := mc.handleOkPacket([]byte{iOK, 0, 0, 0x02, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if gtid := mc.LastGTID(); gtid != "3e11fa47-71ca-11e1-9e33-c80aa9429562:23" {
+		t.Errorf("expected the GTID to be kept, got %q", gtid)
+	}
+}
+
+func TestSessionStateTruncatedOK(t *testing.T) {
+	_, mc := newRWMockConn(0)
+	mc.clientFlags = clientSessionTrack
+	mc.sessionVars = map[string]string{"time_zone": "UTC"}
+
+	// SERVER_SESSION_STATE_CHANGED is set, but the packet ends after it
+	for _, ok := range [][]byte{
+		{iOK, 0, 0, 0, 0x40},
+		{iOK, 0, 0, 0, 0x40, 0},
+	} {
+		if err := mc.handleOkPacket(ok); err != nil {
+			t.Errorf("OK %x: unexpected error %v", ok, err)
+		}
+	}
+	if mc.sessionVars != nil {
+		t.Error("expected the cached session variables to be dropped")
+	}
+}
@@ -0,0 +1,213 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+)
+
+// ShardFunc returns the index of the shard for a shard key, which was added
+// to the context of an operation with WithShardKey.
+type ShardFunc func(key string) (int, error)
+
+// NewShardedConnector returns a driver.Connector which routes every
+// operation to one of the shards, picked by the shard key in the context of
+// the operation. This lets sharded deployments use a single *sql.DB:
+//
+//	connector, err := mysql.NewShardedConnector(shards, func(key string) (int, error) {
+//		return int(crc32.ChecksumIEEE([]byte(key)) % uint32(len(shards))), nil
+//	})
+//	db := sql.OpenDB(connector)
+//	ctx := mysql.WithShardKey(ctx, userID)
+//	rows, err := db.QueryContext(ctx, "SELECT * FROM orders WHERE user_id = ?", userID)
+//
+// A connection of the returned connector connects to each shard when it is
+// used for the first time. Prepared statements stay on the shard they were
+// prepared on, and transactions run on the shard they were started on.
+// Operations without a shard key fail with ErrNoShardKey. The Replicas of
+// the shards are not used.
+func NewShardedConnector(shards []*Config, shard ShardFunc) (driver.Connector, error) {
+	c := &shardedConnector{
+		shards: make([]*connector, len(shards)),
+		shard:  shard,
+	}
+	for i, cfg := range shards {
+		cfg = cfg.Clone()
+		if err := cfg.normalize(); err != nil {
+			return nil, err
+		}
+		c.shards[i] = newConnector(cfg)
+	}
+	return c, nil
+}
+
+type shardedConnector struct {
+	shards []*connector
+	shard  ShardFunc
+}
+
+// Connect implements driver.Connector interface.
+// Connect returns a connection which connects to the shards on demand.
+func (c *shardedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &shardedConn{
+		connector: c,
+		conns:     make([]*mysqlConn, len(c.shards)),
+		tx:        -1,
+	}, nil
+}
+
+// Driver implements driver.Connector interface.
+// Driver returns &MySQLDriver{}.
+func (c *shardedConnector) Driver() driver.Driver {
+	return &MySQLDriver{}
+}
+
+type shardedConn struct {
+	connector *shardedConnector
+	conns     []*mysqlConn // connections to the shards, nil if not connected yet
+	tx        int          // shard of the running transaction, or -1
+}
+
+// conn returns the connection to the shard for ctx and the index of the
+// shard.
+func (sc *shardedConn) conn(ctx context.Context) (*mysqlConn, int, error) {
+	if sc.tx >= 0 {
+		return sc.conns[sc.tx], sc.tx, nil
+	}
+
+	key, ok := ShardKeyFromContext(ctx)
+	if !ok {
+		return nil, 0, ErrNoShardKey
+	}
+	i, err := sc.connector.shard(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if i < 0 || i >= len(sc.conns) {
+		return nil, 0, fmt.Errorf("shard %d for key %q out of range [0, %d)", i, key, len(sc.conns))
+	}
+
+	if sc.conns[i] == nil {
+		mc, err := sc.connector.shards[i].connect(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		sc.conns[i] = mc
+	}
+	return sc.conns[i], i, nil
+}
+
+func (sc *shardedConn) Prepare(query string) (driver.Stmt, error) {
+	return sc.PrepareContext(context.Background(), query)
+}
+
+func (sc *shardedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	mc, _, err := sc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mc.PrepareContext(ctx, query)
+}
+
+func (sc *shardedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	mc, _, err := sc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mc.ExecContext(ctx, query, args)
+}
+
+func (sc *shardedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	mc, _, err := sc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mc.QueryContext(ctx, query, args)
+}
+
+func (sc *shardedConn) Begin() (driver.Tx, error) {
+	return sc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (sc *shardedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	mc, i, err := sc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := mc.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	sc.tx = i
+	return &shardedTx{tx: tx, sc: sc}, nil
+}
+
+// Ping pings the shards which are connected.
+func (sc *shardedConn) Ping(ctx context.Context) error {
+	for _, mc := range sc.conns {
+		if mc == nil {
+			continue
+		}
+		if err := mc.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetSession drops the connections to shards which went bad while the
+// connection was idle. They are connected again when they are used.
+func (sc *shardedConn) ResetSession(ctx context.Context) error {
+	for i, mc := range sc.conns {
+		if mc == nil {
+			continue
+		}
+		if err := mc.ResetSession(ctx); err != nil {
+			mc.Close()
+			sc.conns[i] = nil
+		}
+	}
+	return nil
+}
+
+func (sc *shardedConn) CheckNamedValue(nv *driver.NamedValue) (err error) {
+	nv.Value, err = converter{}.ConvertValue(nv.Value)
+	return
+}
+
+func (sc *shardedConn) Close() error {
+	var err error
+	for i, mc := range sc.conns {
+		if mc == nil {
+			continue
+		}
+		if cerr := mc.Close(); err == nil {
+			err = cerr
+		}
+		sc.conns[i] = nil
+	}
+	return err
+}
+
+type shardedTx struct {
+	tx driver.Tx
+	sc *shardedConn
+}
+
+func (tx *shardedTx) Commit() error {
+	tx.sc.tx = -1
+	return tx.tx.Commit()
+}
+
+func (tx *shardedTx) Rollback() error {
+	tx.sc.tx = -1
+	return tx.tx.Rollback()
+}
@@ -0,0 +1,69 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestShardedConnector(t *testing.T) {
+	var shards []*Config
+	for _, name := range []string{"shard0", "shard1"} {
+		name := name
+		RegisterDialContext("sharded"+name, func(ctx context.Context, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go serveReplies(server, [][]byte{serverHandshake, serverAuthOK, textResultSet(name)})
+			return client, nil
+		})
+		cfg := NewConfig()
+		cfg.User = "root"
+		cfg.Net = "sharded" + name
+		cfg.Addr = "localhost:3306"
+		cfg.MaxAllowedPacket = 4194304
+		shards = append(shards, cfg)
+	}
+
+	connector, err := NewShardedConnector(shards, func(key string) (int, error) {
+		switch key {
+		case "a":
+			return 0, nil
+		case "b":
+			return 1, nil
+		}
+		return 0, errors.New("unknown shard key " + key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for key, expected := range map[string]string{"b": "shard1", "a": "shard0"} {
+		var v string
+		ctx := WithShardKey(context.Background(), key)
+		if err := db.QueryRowContext(ctx, "SELECT v").Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v != expected {
+			t.Errorf("key %s: expected %s, got %s", key, expected, v)
+		}
+	}
+
+	if _, err := db.ExecContext(context.Background(), "DO 1"); err != ErrNoShardKey {
+		t.Errorf("expected ErrNoShardKey, got %v", err)
+	}
+	if _, err := db.ExecContext(WithShardKey(context.Background(), "c"), "DO 1"); err == nil || err.Error() != "unknown shard key c" {
+		t.Errorf("expected the error of the ShardFunc, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// spoolChunkSize is the size of the chunks in which packets are copied to
+// the spool.
+const spoolChunkSize = 32 * 1024
+
+// spoolWriter keeps spooled packets in memory up to limit bytes and writes
+// everything beyond to a temporary file.
+type spoolWriter struct {
+	mem   bytes.Buffer
+	file  *os.File
+	fw    *bufio.Writer
+	limit int64
+	size  int64
+
+	closeOnce sync.Once
+}
+
+func (w *spoolWriter) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	if w.file == nil {
+		if int64(w.mem.Len()+len(p)) <= w.limit {
+			return w.mem.Write(p)
+		}
+		f, err := ioutil.TempFile("", "mysql-spool-")
+		if err != nil {
+			return 0, err
+		}
+		// Unlink the file right away where the OS allows it, so that it
+		// doesn't outlive the process.
+		os.Remove(f.Name())
+		w.file = f
+		w.fw = bufio.NewWriterSize(f, spoolChunkSize)
+	}
+	return w.fw.Write(p)
+}
+
+// reader returns a reader for the spooled data.
+func (w *spoolWriter) reader() (io.Reader, error) {
+	mem := bytes.NewReader(w.mem.Bytes())
+	if w.file == nil {
+		return mem, nil
+	}
+	if err := w.fw.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(mem, w.file), nil
+}
+
+// close removes the temporary file. It may be called concurrently with
+// reads, when the connection is closed.
+func (w *spoolWriter) close() {
+	w.closeOnce.Do(func() {
+		if w.file != nil {
+			w.file.Close()
+			os.Remove(w.file.Name())
+		}
+	})
+}
+
+// spoolConn serves the spooled packets to the connection buffer. Once they
+// are consumed, the buffer reads from the network connection again.
+type spoolConn struct {
+	net.Conn  // network connection
+	buf       *buffer
+	r         io.Reader
+	remaining int64
+	w         *spoolWriter
+	active    *atomic.Value // mysqlConn.spool
+}
+
+func (c *spoolConn) Read(p []byte) (int, error) {
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 {
+		c.w.close()
+		c.active.Store((*spoolWriter)(nil))
+		c.buf.nc = c.Conn
+		err = nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *spoolConn) Close() error {
+	c.w.close()
+	return c.Conn.Close()
+}
+
+// spoolRows reads the remaining rows of the current result set from the
+// network right away, so that the server is done with the query. The rows
+// are kept in memory up to memoryLimit bytes, beyond that they are written to
+// a temporary file. Following reads of the connection are served from the
+// spool until it is consumed.
+func (mc *mysqlConn) spoolRows(memoryLimit int64) error {
+	w := &spoolWriter{limit: memoryLimit}
+	if err := mc.spoolPackets(w); err != nil {
+		w.close()
+		if cerr := mc.canceled.Value(); cerr != nil {
+			return cerr
+		}
+		mc.log(err)
+		mc.Close()
+		return ErrInvalidConn
+	}
+
+	// Data read ahead from the network follows the spooled packets.
+	if n := mc.buf.length; n > 0 {
+		ahead := make([]byte, n)
+		mc.buf.readFull(ahead)
+		if _, err := w.Write(ahead); err != nil {
+			w.close()
+			mc.log(err)
+			mc.Close()
+			return ErrInvalidConn
+		}
+	}
+
+	r, err := w.reader()
+	if err != nil {
+		w.close()
+		mc.log(err)
+		mc.Close()
+		return ErrInvalidConn
+	}
+	if w.size == 0 {
+		return nil
+	}
+	mc.spool.Store(w)
+	mc.buf.nc = &spoolConn{
+		Conn:      mc.buf.nc,
+		buf:       &mc.buf,
+		r:         r,
+		remaining: w.size,
+		w:         w,
+		active:    &mc.spool,
+	}
+	return nil
+}
+
+// spoolPackets copies the raw packets up to and including the EOF or ERR
+// packet terminating the current result set to w.
+func (mc *mysqlConn) spoolPackets(w io.Writer) error {
+	var header [4]byte
+	chunk := make([]byte, spoolChunkSize)
+	continued := false
+	for {
+		if err := mc.buf.readFull(header[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		pktLen := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+
+		last := false
+		for remaining, first := pktLen, true; remaining > 0; first = false {
+			n := remaining
+			if n > len(chunk) {
+				n = len(chunk)
+			}
+			if err := mc.buf.readFull(chunk[:n]); err != nil {
+				return err
+			}
+			if first && !continued {
+				switch chunk[0] {
+				case iERR:
+					last = true
+				case iEOF:
+					last = pktLen < 9 || mc.clientFlags&clientDeprecateEOF != 0 && pktLen < maxPacketSize
+				}
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return err
+			}
+			remaining -= n
+		}
+
+		continued = pktLen == maxPacketSize
+		if last && !continued {
+			return nil
+		}
+	}
+}
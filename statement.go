@@ -10,16 +10,64 @@ package mysql
 
 import (
 	"database/sql/driver"
+	"encoding"
 	"encoding/json"
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
+	"sync"
+	"time"
 )
 
 type mysqlStmt struct {
 	mc         *mysqlConn
 	id         uint32
 	paramCount int
+	columns    []mysqlField // metadata of the last result set, reused by the next execution
+	stats      *StmtStats
+	queryText  string
+	created    time.Time
+	stack      []byte // stack of Prepare, recorded with StmtStackTraces
+}
+
+// OpenStatement is a prepared statement of a connection which was not closed
+// yet, see Conn.OpenStatements.
+type OpenStatement struct {
+	ID      uint32 // id of the statement on the server
+	Query   string
+	Created time.Time
+	Stack   string // stack of Prepare, recorded with StmtStackTraces
+}
+
+func (mc *mysqlConn) OpenStatements() []OpenStatement {
+	stmts := make([]OpenStatement, 0, len(mc.openStmts))
+	for _, stmt := range mc.openStmts {
+		stmts = append(stmts, OpenStatement{
+			ID:      stmt.id,
+			Query:   stmt.queryText,
+			Created: stmt.created,
+			Stack:   string(stmt.stack),
+		})
+	}
+	sort.Slice(stmts, func(i, j int) bool { return stmts[i].ID < stmts[j].ID })
+	return stmts
+}
+
+// logOpenStatements logs the statements which were not closed before the
+// connection was closed.
+func (mc *mysqlConn) logOpenStatements() {
+	if len(mc.openStmts) == 0 {
+		return
+	}
+	mc.log("closing connection with ", len(mc.openStmts), " statements which were not closed")
+	for _, stmt := range mc.OpenStatements() {
+		if stmt.Stack != "" {
+			mc.log("statement ", stmt.ID, " prepared at ", stmt.Created.Format(time.RFC3339), ": ", stmt.Query, "\n", stmt.Stack)
+		} else {
+			mc.log("statement ", stmt.ID, " prepared at ", stmt.Created.Format(time.RFC3339), ": ", stmt.Query)
+		}
+	}
 }
 
 func (stmt *mysqlStmt) Close() error {
@@ -31,6 +79,7 @@ func (stmt *mysqlStmt) Close() error {
 		return driver.ErrBadConn
 	}
 
+	delete(stmt.mc.openStmts, stmt.id)
 	err := stmt.mc.writeCommandPacketUint32(comStmtClose, stmt.id)
 	stmt.mc = nil
 	return err
@@ -51,9 +100,13 @@ func (stmt *mysqlStmt) CheckNamedValue(nv *driver.NamedValue) (err error) {
 
 func (stmt *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
 	if stmt.mc.closed.IsSet() {
-		errLog.Print(ErrInvalidConn)
+		stmt.mc.log(ErrInvalidConn)
 		return nil, driver.ErrBadConn
 	}
+	if err := stmt.mc.checkQuerySize(argsSize(args)); err != nil {
+		return nil, err
+	}
+	start := time.Now()
 	// Send command
 	err := stmt.writeExecutePacket(args)
 	if err != nil {
@@ -64,16 +117,29 @@ func (stmt *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
 
 	mc.affectedRows = 0
 	mc.insertId = 0
+	mc.warnings = 0
 
 	// Read Result
 	resLen, err := mc.readResultSetHeaderPacket()
 	if err != nil {
 		return nil, err
 	}
+	if stmt.stats != nil {
+		stmt.stats.observe(start)
+	}
 
-	if resLen > 0 {
+	var returning *returningRows
+	if resLen > 0 && mc.maxReturning > 0 {
+		rows := &binaryRows{mysqlRows{mc: mc}}
+		if rows.rs.columns, err = stmt.readColumns(resLen); err != nil {
+			return nil, err
+		}
+		if returning, err = readReturning(rows, mc.maxReturning); err != nil {
+			return nil, err
+		}
+	} else if resLen > 0 {
 		// Columns
-		if err = mc.readUntilEOF(); err != nil {
+		if err := mc.skipColumns(resLen); err != nil {
 			return nil, err
 		}
 
@@ -87,10 +153,7 @@ func (stmt *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
 		return nil, err
 	}
 
-	return &mysqlResult{
-		affectedRows: int64(mc.affectedRows),
-		insertId:     int64(mc.insertId),
-	}, nil
+	return mc.newResult(returning), nil
 }
 
 func (stmt *mysqlStmt) Query(args []driver.Value) (driver.Rows, error) {
@@ -99,9 +162,13 @@ func (stmt *mysqlStmt) Query(args []driver.Value) (driver.Rows, error) {
 
 func (stmt *mysqlStmt) query(args []driver.Value) (*binaryRows, error) {
 	if stmt.mc.closed.IsSet() {
-		errLog.Print(ErrInvalidConn)
+		stmt.mc.log(ErrInvalidConn)
 		return nil, driver.ErrBadConn
 	}
+	if err := stmt.mc.checkQuerySize(argsSize(args)); err != nil {
+		return nil, err
+	}
+	start := time.Now()
 	// Send command
 	err := stmt.writeExecutePacket(args)
 	if err != nil {
@@ -110,6 +177,10 @@ func (stmt *mysqlStmt) query(args []driver.Value) (*binaryRows, error) {
 
 	mc := stmt.mc
 
+	mc.affectedRows = 0
+	mc.insertId = 0
+	mc.warnings = 0
+
 	// Read Result
 	resLen, err := mc.readResultSetHeaderPacket()
 	if err != nil {
@@ -117,12 +188,24 @@ func (stmt *mysqlStmt) query(args []driver.Value) (*binaryRows, error) {
 	}
 
 	rows := new(binaryRows)
+	rows.stmtStats = stmt.stats
 
 	if resLen > 0 {
 		rows.mc = mc
-		rows.rs.columns, err = mc.readColumns(resLen)
+		rows.rs.columns, err = stmt.readColumns(resLen)
+		if err == nil && stmt.stats != nil {
+			stmt.stats.observe(start)
+		}
 	} else {
+		if stmt.stats != nil {
+			stmt.stats.observe(start)
+		}
 		rows.rs.done = true
+		if mc.emptyResults {
+			rows.mc = mc
+			rows.saveStatus(mc)
+			return rows, nil
+		}
 
 		switch err := rows.NextResultSet(); err {
 		case nil, io.EOF:
@@ -135,6 +218,63 @@ func (stmt *mysqlStmt) query(args []driver.Value) (*binaryRows, error) {
 	return rows, err
 }
 
+// readColumns reads the column definitions of a result set of the
+// statement. They are kept on the statement, as every execution returns the
+// same columns, unlike the queries sharing the buffer of the connection.
+// MariaDB skips the columns if they did not change since they were read.
+func (stmt *mysqlStmt) readColumns(count int) ([]mysqlField, error) {
+	if stmt.mc.skipMetadata {
+		if len(stmt.columns) < count {
+			return nil, stmt.mc.malformed(nil)
+		}
+		return stmt.columns[:count], nil
+	}
+	if cap(stmt.columns) < count {
+		stmt.columns = make([]mysqlField, count)
+	}
+	return stmt.mc.readColumnsInto(stmt.columns[:count])
+}
+
+// ConverterFunc converts a query argument of a registered type to a
+// driver.Value. It must return a type accepted by driver.IsValue or a uint64.
+// Custom converters must be registered with RegisterConverter
+type ConverterFunc func(v interface{}) (driver.Value, error)
+
+var (
+	convertersLock sync.RWMutex
+	converters     map[reflect.Type]ConverterFunc
+)
+
+// RegisterConverter registers a converter for query arguments of the type t.
+// It is consulted before the built-in conversions, including driver.Valuer,
+// so applications can bind types they do not own without converting them
+// at every call site.
+//
+//	mysql.RegisterConverter(reflect.TypeOf(Money{}), func(v interface{}) (driver.Value, error) {
+//	    return v.(Money).String(), nil
+//	})
+func RegisterConverter(t reflect.Type, conv ConverterFunc) {
+	convertersLock.Lock()
+	defer convertersLock.Unlock()
+	if converters == nil {
+		converters = make(map[reflect.Type]ConverterFunc)
+	}
+	converters[t] = conv
+}
+
+// DeregisterConverter removes the converter for the type t.
+func DeregisterConverter(t reflect.Type) {
+	convertersLock.Lock()
+	delete(converters, t)
+	convertersLock.Unlock()
+}
+
+func getConverter(t reflect.Type) ConverterFunc {
+	convertersLock.RLock()
+	defer convertersLock.RUnlock()
+	return converters[t]
+}
+
 var jsonType = reflect.TypeOf(json.RawMessage{})
 
 type converter struct{}
@@ -145,6 +285,17 @@ type converter struct{}
 // database/sql/driver defaultConverter.ConvertValue() except for that
 // deliberate difference.
 func (c converter) ConvertValue(v interface{}) (driver.Value, error) {
+	if conv := getConverter(reflect.TypeOf(v)); conv != nil {
+		sv, err := conv(v)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sv.(uint64); ok || driver.IsValue(sv) {
+			return sv, nil
+		}
+		return nil, fmt.Errorf("non-Value type %T returned from the converter for %T", sv, v)
+	}
+
 	if driver.IsValue(v) {
 		return v, nil
 	}
@@ -189,14 +340,38 @@ func (c converter) ConvertValue(v interface{}) (driver.Value, error) {
 		case t.Elem().Kind() == reflect.Uint8:
 			return rv.Bytes(), nil
 		default:
+			if mv, ok, err := marshalValue(v); ok {
+				return mv, err
+			}
 			return nil, fmt.Errorf("unsupported type %T, a slice of %s", v, t.Elem().Kind())
 		}
 	case reflect.String:
 		return rv.String(), nil
 	}
+
+	if mv, ok, err := marshalValue(v); ok {
+		return mv, err
+	}
 	return nil, fmt.Errorf("unsupported type %T, a %s", v, rv.Kind())
 }
 
+// marshalValue converts types which are not handled otherwise to their
+// marshaled form. Text is sent as a string, binary data as a blob.
+func marshalValue(v interface{}) (driver.Value, bool, error) {
+	switch m := v.(type) {
+	case encoding.TextMarshaler:
+		b, err := m.MarshalText()
+		if err != nil {
+			return nil, true, err
+		}
+		return string(b), true, nil
+	case encoding.BinaryMarshaler:
+		b, err := m.MarshalBinary()
+		return b, true, err
+	}
+	return nil, false, nil
+}
+
 var valuerReflectType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
 
 // callValuerValue returns vr.Value(), with one exception:
@@ -218,3 +393,23 @@ func callValuerValue(vr driver.Valuer) (v driver.Value, err error) {
 	}
 	return vr.Value()
 }
+
+// argsSize returns the number of bytes the arguments take in a
+// COM_STMT_EXECUTE packet, not counting their headers.
+func argsSize(args []driver.Value) int {
+	size := 0
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case nil, bool:
+		case []byte:
+			size += len(v)
+		case string:
+			size += len(v)
+		case time.Time:
+			size += len("2006-01-02 15:04:05.999999")
+		default:
+			size += 8
+		}
+	}
+	return size
+}
@@ -12,7 +12,14 @@ import (
 	"bytes"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConvertDerivedString(t *testing.T) {
@@ -149,3 +156,238 @@ func TestConvertJSON(t *testing.T) {
 		t.Fatalf("json.RawMessage converted, got %#v %T", out, out)
 	}
 }
+
+func TestConvertRegisteredConverter(t *testing.T) {
+	type money struct {
+		cents int64
+	}
+	type invalid struct{}
+	RegisterConverter(reflect.TypeOf(money{}), func(v interface{}) (driver.Value, error) {
+		m := v.(money)
+		return fmt.Sprintf("%d.%02d", m.cents/100, m.cents%100), nil
+	})
+	defer DeregisterConverter(reflect.TypeOf(money{}))
+	RegisterConverter(reflect.TypeOf(invalid{}), func(v interface{}) (driver.Value, error) {
+		return v, nil
+	})
+	defer DeregisterConverter(reflect.TypeOf(invalid{}))
+
+	out, err := converter{}.ConvertValue(money{1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "12.34" {
+		t.Fatalf("money not converted, got %#v %T", out, out)
+	}
+
+	// pointers are indirected before the registered converter is used
+	out, err = converter{}.ConvertValue(&money{500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "5.00" {
+		t.Fatalf("*money not converted, got %#v %T", out, out)
+	}
+
+	if _, err = (converter{}).ConvertValue(invalid{}); err == nil {
+		t.Fatal("non-Value returned from a converter was accepted")
+	}
+
+	DeregisterConverter(reflect.TypeOf(money{}))
+	if _, err = (converter{}).ConvertValue(money{1234}); err == nil {
+		t.Fatal("money was converted after the converter was removed")
+	}
+}
+
+type textMarshaler [2]int
+
+func (m textMarshaler) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d-%d", m[0], m[1])), nil
+}
+
+type binaryMarshaler struct {
+	v uint16
+}
+
+func (m binaryMarshaler) MarshalBinary() ([]byte, error) {
+	return []byte{byte(m.v >> 8), byte(m.v)}, nil
+}
+
+type failingMarshaler []int
+
+func (m failingMarshaler) MarshalText() ([]byte, error) {
+	return nil, errors.New("marshal failed")
+}
+
+func TestConvertMarshaler(t *testing.T) {
+	out, err := converter{}.ConvertValue(textMarshaler{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "1-2" {
+		t.Fatalf("TextMarshaler not converted, got %#v %T", out, out)
+	}
+
+	out, err = converter{}.ConvertValue(&binaryMarshaler{0x1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.([]byte), []byte{0x12, 0x34}) {
+		t.Fatalf("BinaryMarshaler not converted, got %#v %T", out, out)
+	}
+
+	if _, err = (converter{}).ConvertValue(failingMarshaler{1}); err == nil || err.Error() != "marshal failed" {
+		t.Fatalf("expected the marshal error, got %v", err)
+	}
+}
+
+func TestStatementStats(t *testing.T) {
+	// binary result set with a VARCHAR column and two rows
+	reply := appendTestPacket(nil, 1, 1)
+	reply = appendColumns(reply, 2, testColumn{name: "v", fieldType: fieldTypeVarString})
+	reply = appendTestPacket(reply, 4, iOK, 0x00, 0x01, 'a')
+	reply = appendTestPacket(reply, 5, iOK, 0x00, 0x01, 'b')
+	reply = appendTestPacket(reply, 6, iEOF, 0x00, 0x00, 0x02, 0x00)
+
+	conn, mc := newRWMockConn(0)
+	conn.queuedReplies = [][]byte{
+		reply,
+		{7, 0, 0, 1, 0, 1, 0, 2, 0, 0, 0},
+	}
+	query, exec := &mysqlStmt{mc: mc, stats: mc.statementStats("SELECT v FROM t")},
+		&mysqlStmt{mc: mc, stats: mc.statementStats("DELETE FROM t")}
+
+	rows, err := query.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]driver.Value, 1)
+	for rows.Next(dest) == nil {
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Exec(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := mc.StatementStats()
+	if len(stats) != 2 {
+		t.Fatalf("expected the stats of 2 queries, got %+v", stats)
+	}
+	if stats[0].TotalLatency < stats[1].TotalLatency {
+		t.Errorf("stats are not ordered by total latency: %+v", stats)
+	}
+	for _, s := range stats {
+		rows := uint64(0)
+		if s.Query == "SELECT v FROM t" {
+			rows = 2
+		}
+		if s.Executions != 1 || s.Rows != rows || s.LastLatency != s.TotalLatency {
+			t.Errorf("unexpected stats %+v", s)
+		}
+	}
+}
+
+func TestOpenStatements(t *testing.T) {
+	previous := errLog
+	defer func() {
+		errLog = previous
+	}()
+	var logged bytes.Buffer
+	errLog = log.New(&logged, "", 0)
+
+	conn, mc := newRWMockConn(0)
+	mc.cfg.StmtStackTraces = true
+	prepareOK := func(id byte) []byte {
+		return []byte{12, 0, 0, 1, iOK, id, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	}
+	conn.queuedReplies = [][]byte{prepareOK(1), prepareOK(2)}
+
+	stmt1, err := mc.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.Prepare("SELECT 2"); err != nil {
+		t.Fatal(err)
+	}
+	stmts := mc.OpenStatements()
+	if len(stmts) != 2 || stmts[0].ID != 1 || stmts[0].Query != "SELECT 1" || stmts[1].Query != "SELECT 2" {
+		t.Fatalf("unexpected open statements %+v", stmts)
+	}
+	if !strings.Contains(stmts[0].Stack, "TestOpenStatements") {
+		t.Errorf("expected the stack of Prepare, got %q", stmts[0].Stack)
+	}
+
+	if err := stmt1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stmts := mc.OpenStatements(); len(stmts) != 1 || stmts[0].ID != 2 {
+		t.Fatalf("unexpected open statements %+v", stmts)
+	}
+
+	mc.Close()
+	if out := logged.String(); !strings.Contains(out, "closing connection with 1 statements") || !strings.Contains(out, "SELECT 2") {
+		t.Errorf("expected the open statement to be logged, got %q", out)
+	}
+}
+
+func TestStmtCachedMetadata(t *testing.T) {
+	// column definition and EOF packets with the sequence ids 2 and 3
+	resultSet := textResultSetColumns([]string{"v"})
+	columns := resultSet[5 : len(resultSet)-9]
+
+	prepare := append([]byte{12, 0, 0, 1, iOK, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}, columns...)
+	// column count with "metadata follows" 0, a row and EOF
+	skipped := []byte{2, 0, 0, 1, 1, 0,
+		4, 0, 0, 2, iOK, 0x00, 0x01, 'a',
+		5, 0, 0, 3, iEOF, 0x00, 0x00, 0x02, 0x00}
+	// column count with "metadata follows" 1, the columns, a row and EOF
+	sent := append([]byte{2, 0, 0, 1, 1, 1}, columns...)
+	sent = append(sent,
+		4, 0, 0, 4, iOK, 0x00, 0x01, 'b',
+		5, 0, 0, 5, iEOF, 0x00, 0x00, 0x02, 0x00)
+
+	conn, mc := newRWMockConn(0)
+	mc.mariadbFlags = mariadbClientCacheMetadata
+	conn.queuedReplies = [][]byte{prepare, skipped, sent}
+
+	stmt, err := mc.Prepare("SELECT v FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a", "b"} {
+		rows, err := stmt.Query(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cols := rows.Columns(); len(cols) != 1 || cols[0] != "v" {
+			t.Errorf("unexpected columns %v", cols)
+		}
+		dest := make([]driver.Value, 1)
+		if err := rows.Next(dest); err != nil {
+			t.Fatal(err)
+		}
+		if string(dest[0].([]byte)) != want {
+			t.Errorf("expected %q, got %q", want, dest[0])
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStatementStatsLimit(t *testing.T) {
+	_, mc := newRWMockConn(0)
+	hot := mc.statementStats("SELECT v FROM t WHERE id = ?")
+	hot.observe(time.Now())
+	for i := 0; i < 2*maxStmtStats; i++ {
+		mc.statementStats("SELECT v FROM t WHERE id = " + strconv.Itoa(i))
+	}
+	if n := len(mc.stmtStats); n != maxStmtStats {
+		t.Errorf("expected the counters of %d queries, got %d", maxStmtStats, n)
+	}
+	if mc.stmtStats[hot.Query] != hot {
+		t.Error("expected the counters of the executed query to be kept")
+	}
+}
@@ -0,0 +1,195 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var driverStats struct {
+	connections int64
+	goroutines  int64
+}
+
+// DriverStats is a snapshot of the resources held by the driver.
+type DriverStats struct {
+	// Connections is the number of connections which are established and
+	// not closed yet.
+	Connections int
+
+	// Goroutines is the number of running background goroutines, one per
+	// connection, which wait for the cancellation of contexts passed to
+	// its commands.
+	// They are only used when built with Go older than 1.21.
+	Goroutines int
+}
+
+// Stats returns the number of live connections and background goroutines of
+// the driver. It is intended for leak detection in tests.
+func Stats() DriverStats {
+	return DriverStats{
+		Connections: int(atomic.LoadInt64(&driverStats.connections)),
+		Goroutines:  int(atomic.LoadInt64(&driverStats.goroutines)),
+	}
+}
+
+// ConnStats are the counters of a single connection, see Conn.Stats.
+type ConnStats struct {
+	BytesRead      uint64 // bytes of the packets read, including their headers
+	BytesWritten   uint64 // bytes of the packets written, including their headers
+	PacketsRead    uint64
+	PacketsWritten uint64
+	Commands       uint64 // commands sent, e.g. queries and executions of statements
+	Rows           uint64 // rows of result sets read by the application
+}
+
+func (mc *mysqlConn) Stats() ConnStats {
+	return mc.stats
+}
+
+func (s *ConnStats) add(o ConnStats) {
+	s.BytesRead += o.BytesRead
+	s.BytesWritten += o.BytesWritten
+	s.PacketsRead += o.PacketsRead
+	s.PacketsWritten += o.PacketsWritten
+	s.Commands += o.Commands
+	s.Rows += o.Rows
+}
+
+// StmtStats are the counters of the prepared statements of a connection with
+// the same query, see Conn.StatementStats.
+type StmtStats struct {
+	Query      string
+	Executions uint64
+	Rows       uint64 // rows of result sets read by the application
+
+	// The latency of an execution is the time until the server answered,
+	// i.e. until the OK packet or the column definitions of the result set
+	// are read. It doesn't include reading the rows.
+	TotalLatency time.Duration
+	LastLatency  time.Duration
+}
+
+func (s *StmtStats) observe(start time.Time) {
+	d := time.Since(start)
+	s.Executions++
+	s.LastLatency = d
+	s.TotalLatency += d
+}
+
+// maxStmtStats is the number of queries whose counters are kept per
+// connection.
+const maxStmtStats = 1000
+
+// statementStats returns the counters of the prepared statements of query.
+// If the counters of maxStmtStats queries are kept already, the ones of the
+// least executed query are dropped.
+func (mc *mysqlConn) statementStats(query string) *StmtStats {
+	s := mc.stmtStats[query]
+	if s == nil {
+		if mc.stmtStats == nil {
+			mc.stmtStats = make(map[string]*StmtStats)
+		}
+		if len(mc.stmtStats) >= maxStmtStats {
+			mc.dropLeastExecutedStmtStats()
+		}
+		s = &StmtStats{Query: query}
+		mc.stmtStats[query] = s
+	}
+	return s
+}
+
+// dropLeastExecutedStmtStats drops the counters of the query with the fewest
+// executions, and the lowest total latency among them.
+func (mc *mysqlConn) dropLeastExecutedStmtStats() {
+	var least *StmtStats
+	for _, s := range mc.stmtStats {
+		if least == nil || s.Executions < least.Executions ||
+			s.Executions == least.Executions && s.TotalLatency < least.TotalLatency {
+			least = s
+		}
+	}
+	if least != nil {
+		delete(mc.stmtStats, least.Query)
+	}
+}
+
+func (mc *mysqlConn) StatementStats() []StmtStats {
+	return sortStmtStats(mc.stmtStats)
+}
+
+// sortStmtStats returns copies of the counters, by descending total latency.
+func sortStmtStats(m map[string]*StmtStats) []StmtStats {
+	stats := make([]StmtStats, 0, len(m))
+	for _, s := range m {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].TotalLatency != stats[j].TotalLatency {
+			return stats[i].TotalLatency > stats[j].TotalLatency
+		}
+		return stats[i].Query < stats[j].Query
+	})
+	return stats
+}
+
+// ServerStats are the counters of the server reported by COM_STATISTICS, see
+// Conn.ServerStats.
+type ServerStats struct {
+	Uptime           time.Duration
+	Threads          int64 // connected clients
+	Questions        int64 // statements executed since the server started
+	SlowQueries      int64
+	Opens            int64 // tables opened
+	FlushTables      int64
+	OpenTables       int64
+	QueriesPerSecond float64 // average since the server started
+
+	// Raw is the text the server returned.
+	Raw string
+}
+
+// parseServerStats parses the response of COM_STATISTICS, e.g.
+// "Uptime: 42  Threads: 1  Questions: 10  Slow queries: 0  Opens: 12  Flush
+// tables: 1  Open tables: 5  Queries per second avg: 0.238". Unknown
+// counters are ignored.
+func parseServerStats(raw string) ServerStats {
+	stats := ServerStats{Raw: raw}
+	for _, field := range strings.Split(raw, "  ") {
+		sep := strings.Index(field, ": ")
+		if sep < 0 {
+			continue
+		}
+		value := strings.TrimSpace(field[sep+2:])
+		switch strings.TrimSpace(field[:sep]) {
+		case "Uptime":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			stats.Uptime = time.Duration(n) * time.Second
+		case "Threads":
+			stats.Threads, _ = strconv.ParseInt(value, 10, 64)
+		case "Questions":
+			stats.Questions, _ = strconv.ParseInt(value, 10, 64)
+		case "Slow queries":
+			stats.SlowQueries, _ = strconv.ParseInt(value, 10, 64)
+		case "Opens":
+			stats.Opens, _ = strconv.ParseInt(value, 10, 64)
+		case "Flush tables":
+			stats.FlushTables, _ = strconv.ParseInt(value, 10, 64)
+		case "Open tables":
+			stats.OpenTables, _ = strconv.ParseInt(value, 10, 64)
+		case "Queries per second avg":
+			stats.QueriesPerSecond, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+	return stats
+}
@@ -9,13 +9,16 @@
 package mysql
 
 import (
+	"crypto"
 	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,8 +28,9 @@ import (
 
 // Registry for custom tls.Configs
 var (
-	tlsConfigLock     sync.RWMutex
-	tlsConfigRegistry map[string]*tls.Config
+	tlsConfigLock         sync.RWMutex
+	tlsConfigRegistry     map[string]*tls.Config
+	tlsHostConfigRegistry map[string]map[string]*tls.Config
 )
 
 // RegisterTLSConfig registers a custom tls.Config to be used with sql.Open.
@@ -55,10 +59,25 @@ var (
 //  })
 //  db, err := sql.Open("mysql", "user@tcp(localhost:3306)/test?tls=custom")
 //
+// The private key of a client certificate does not have to be loaded from a
+// PEM file. Any crypto.Signer, e.g. a key held by a PKCS#11 token, a TPM or a
+// cloud KMS, can be used as the PrivateKey of a pre-built tls.Certificate:
+//
+//  mysql.RegisterTLSConfig("hsm", &tls.Config{
+//      RootCAs: rootCertPool,
+//      Certificates: []tls.Certificate{{
+//          Certificate: [][]byte{leafDER},
+//          PrivateKey:  hsmSigner, // implements crypto.Signer
+//      }},
+//  })
+//
 func RegisterTLSConfig(key string, config *tls.Config) error {
-	if _, isBool := readBool(key); isBool || strings.ToLower(key) == "skip-verify" || strings.ToLower(key) == "preferred" {
+	if _, isBool := readBool(key); isBool || isReservedTLSConfigName(strings.ToLower(key)) {
 		return fmt.Errorf("key '%s' is reserved", key)
 	}
+	if err := checkTLSCertificates(config); err != nil {
+		return err
+	}
 
 	tlsConfigLock.Lock()
 	if tlsConfigRegistry == nil {
@@ -70,24 +89,150 @@ func RegisterTLSConfig(key string, config *tls.Config) error {
 	return nil
 }
 
-// DeregisterTLSConfig removes the tls.Config associated with key.
+// RegisterTLSConfigForHost registers a custom tls.Config under key, which is
+// used instead of the one registered with RegisterTLSConfig for connections
+// to host. host is either a host name or IP address, or a host:port address
+// which takes precedence over the host alone. This way a connector with
+// replicas can use a different CA or ServerName for every server:
+//
+//  mysql.RegisterTLSConfig("custom", primaryConfig)
+//  mysql.RegisterTLSConfigForHost("custom", "replica1.example.com", replicaConfig)
+//  db, err := sql.Open("mysql", "user@tcp(primary.example.com)/test?tls=custom&replicas=replica1.example.com")
+//
+// Connections to hosts without a config of their own use the config
+// registered with RegisterTLSConfig, and fail if there is none.
+func RegisterTLSConfigForHost(key, host string, config *tls.Config) error {
+	if _, isBool := readBool(key); isBool || isReservedTLSConfigName(strings.ToLower(key)) {
+		return fmt.Errorf("key '%s' is reserved", key)
+	}
+	if err := checkTLSCertificates(config); err != nil {
+		return err
+	}
+
+	tlsConfigLock.Lock()
+	if tlsHostConfigRegistry == nil {
+		tlsHostConfigRegistry = make(map[string]map[string]*tls.Config)
+	}
+	if tlsHostConfigRegistry[key] == nil {
+		tlsHostConfigRegistry[key] = make(map[string]*tls.Config)
+	}
+
+	tlsHostConfigRegistry[key][host] = config
+	tlsConfigLock.Unlock()
+	return nil
+}
+
+// DeregisterTLSConfig removes the tls.Config associated with key, including
+// the ones registered for single hosts.
 func DeregisterTLSConfig(key string) {
 	tlsConfigLock.Lock()
 	if tlsConfigRegistry != nil {
 		delete(tlsConfigRegistry, key)
 	}
+	if tlsHostConfigRegistry != nil {
+		delete(tlsHostConfigRegistry, key)
+	}
 	tlsConfigLock.Unlock()
 }
 
-func getTLSConfigClone(key string) (config *tls.Config) {
+// getTLSConfigClone returns a copy of the config registered under key for
+// the address addr.
+func getTLSConfigClone(key, addr string) (config *tls.Config) {
 	tlsConfigLock.RLock()
+	defer tlsConfigLock.RUnlock()
+
+	if hosts := tlsHostConfigRegistry[key]; hosts != nil {
+		if v, ok := hosts[addr]; ok {
+			return v.Clone()
+		}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if v, ok := hosts[host]; ok {
+				return v.Clone()
+			}
+		}
+	}
 	if v, ok := tlsConfigRegistry[key]; ok {
 		config = v.Clone()
 	}
-	tlsConfigLock.RUnlock()
 	return
 }
 
+// collationName returns the name of the collation with the id, or an empty
+// string if it is not in the collations map.
+func collationName(id byte) string {
+	for name, cid := range collations {
+		if cid == id {
+			return name
+		}
+	}
+	return ""
+}
+
+// parseDecimal decodes a DECIMAL value to the nearest float64, for
+// Config.DecimalAsFloat.
+func parseDecimal(b []byte) (float64, error) {
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// isReservedTLSConfigName reports whether name is a TLS mode of the tls DSN
+// parameter, which can't be used for a custom config.
+func isReservedTLSConfigName(name string) bool {
+	switch name {
+	case "skip-verify", "preferred", "verify-ca", "verify-full":
+		return true
+	}
+	return false
+}
+
+// verifyCertificateChain returns a tls.Config.VerifyPeerCertificate function
+// which verifies the certificate chain of the server against roots, or the
+// system roots if roots is nil, but not the host name, for tls=verify-ca.
+// It is used together with InsecureSkipVerify, which disables both checks.
+func verifyCertificateChain(roots *x509.CertPool) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// checkTLSCertificates makes sure that the private key of every client
+// certificate can be used to sign the handshake. crypto/tls only requires the
+// key to implement crypto.Signer, so keys which never leave a hardware device
+// are accepted as well.
+func checkTLSCertificates(config *tls.Config) error {
+	if config == nil {
+		return nil
+	}
+	for i, cert := range config.Certificates {
+		if cert.PrivateKey == nil {
+			continue
+		}
+		if _, ok := cert.PrivateKey.(crypto.Signer); !ok {
+			return fmt.Errorf("private key of TLS certificate %d does not implement crypto.Signer (%T)", i, cert.PrivateKey)
+		}
+	}
+	return nil
+}
+
 // Returns the bool value of the input.
 // The 2nd return value indicates if the input was a valid bool value
 func readBool(input string) (value bool, valid bool) {
@@ -548,17 +693,19 @@ func stringToInt(b []byte) int {
 func readLengthEncodedString(b []byte) ([]byte, bool, int, error) {
 	// Get length
 	num, isNull, n := readLengthEncodedInteger(b)
+	if n > len(b) {
+		return nil, false, n, io.EOF
+	}
 	if num < 1 {
 		return b[n:n], isNull, n, nil
 	}
 
-	n += int(num)
-
 	// Check data length
-	if len(b) >= n {
-		return b[n-int(num) : n : n], false, n, nil
+	if num > uint64(len(b)-n) {
+		return nil, false, n, io.EOF
 	}
-	return nil, false, n, io.EOF
+	n += int(num)
+	return b[n-int(num) : n : n], false, n, nil
 }
 
 // returns the number of bytes skipped and an error, in case the string is
@@ -566,20 +713,23 @@ func readLengthEncodedString(b []byte) ([]byte, bool, int, error) {
 func skipLengthEncodedString(b []byte) (int, error) {
 	// Get length
 	num, _, n := readLengthEncodedInteger(b)
+	if n > len(b) {
+		return n, io.EOF
+	}
 	if num < 1 {
 		return n, nil
 	}
 
-	n += int(num)
-
 	// Check data length
-	if len(b) >= n {
-		return n, nil
+	if num > uint64(len(b)-n) {
+		return n, io.EOF
 	}
-	return n, io.EOF
+	return n + int(num), nil
 }
 
-// returns the number read, whether the value is NULL and the number of bytes read
+// returns the number read, whether the value is NULL and the number of bytes read.
+// If b is too short to hold the whole integer, the returned number of bytes is
+// larger than len(b).
 func readLengthEncodedInteger(b []byte) (uint64, bool, int) {
 	// See issue #349
 	if len(b) == 0 {
@@ -593,14 +743,23 @@ func readLengthEncodedInteger(b []byte) (uint64, bool, int) {
 
 	// 252: value of following 2
 	case 0xfc:
+		if len(b) < 3 {
+			return 0, false, 3
+		}
 		return uint64(b[1]) | uint64(b[2])<<8, false, 3
 
 	// 253: value of following 3
 	case 0xfd:
+		if len(b) < 4 {
+			return 0, false, 4
+		}
 		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, false, 4
 
 	// 254: value of following 8
 	case 0xfe:
+		if len(b) < 9 {
+			return 0, false, 9
+		}
 		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16 |
 				uint64(b[4])<<24 | uint64(b[5])<<32 | uint64(b[6])<<40 |
 				uint64(b[7])<<48 | uint64(b[8])<<56,
@@ -627,6 +786,12 @@ func appendLengthEncodedInteger(b []byte, n uint64) []byte {
 		byte(n>>32), byte(n>>40), byte(n>>48), byte(n>>56))
 }
 
+// appendLengthEncodedString appends s as a length encoded string to b
+func appendLengthEncodedString(b []byte, s string) []byte {
+	b = appendLengthEncodedInteger(b, uint64(len(s)))
+	return append(b, s...)
+}
+
 // reserveBuffer checks cap(buf) and expand buffer to len(buf) + appendSize.
 // If cap(buf) is not enough, reallocate new buffer.
 func reserveBuffer(buf []byte, appendSize int) []byte {
@@ -776,6 +941,15 @@ func escapeStringQuotes(buf []byte, v string) []byte {
 	return buf[:pos]
 }
 
+// QuoteIdentifier quotes name for use as an identifier, like a database,
+// table or column name, in a query. It is enclosed in backticks, which are
+// valid regardless of ANSI_QUOTES, and backticks inside name are doubled.
+//
+//  query := "SELECT * FROM " + mysql.QuoteIdentifier(table)
+func QuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
 /******************************************************************************
 *                               Sync utils                                    *
 ******************************************************************************/
@@ -872,3 +1046,4 @@ func mapIsolationLevel(level driver.IsolationLevel) (string, error) {
 		return "", fmt.Errorf("mysql: unsupported isolation level: %v", level)
 	}
 }
+
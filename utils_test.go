@@ -10,9 +10,17 @@ package mysql
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/binary"
+	"io"
+	"math/big"
 	"testing"
 	"time"
 )
@@ -54,6 +62,24 @@ func TestLengthEncodedInteger(t *testing.T) {
 	}
 }
 
+func TestLengthEncodedTruncated(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		{0xfc, 0x01},
+		{0xfd, 0x01, 0x00},
+		{0xfe, 0x01, 0x00, 0x00, 0x00},
+		{0x03, 'a', 'b'},
+		{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'},
+	} {
+		if _, _, _, err := readLengthEncodedString(b); err != io.EOF {
+			t.Errorf("%x: expected io.EOF reading, got %v", b, err)
+		}
+		if _, err := skipLengthEncodedString(b); err != io.EOF {
+			t.Errorf("%x: expected io.EOF skipping, got %v", b, err)
+		}
+	}
+}
+
 func TestFormatBinaryDateTime(t *testing.T) {
 	rawDate := [11]byte{}
 	binary.LittleEndian.PutUint16(rawDate[:2], 1978)   // years
@@ -508,3 +534,95 @@ func TestParseDateTimeFail(t *testing.T) {
 		})
 	}
 }
+
+func TestQuoteIdentifier(t *testing.T) {
+	for _, tst := range []struct {
+		name, quoted string
+	}{
+		{"foo", "`foo`"},
+		{"foo bar", "`foo bar`"},
+		{"foo`bar", "`foo``bar`"},
+		{"`", "````"},
+		{"", "``"},
+	} {
+		if quoted := QuoteIdentifier(tst.name); quoted != tst.quoted {
+			t.Errorf("%q: expected %s, got %s", tst.name, tst.quoted, quoted)
+		}
+	}
+}
+
+func TestVerifyCertificateChain(t *testing.T) {
+	newCert := func(name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: name},
+			DNSNames:              []string{name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	ca, caKey := newCert("Test CA", true, nil, nil)
+	server, _ := newCert("db-7.internal", false, ca, caKey)
+	other, _ := newCert("Other CA", true, nil, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	// the host name does not matter
+	if err := verifyCertificateChain(roots)([][]byte{server.Raw}, nil); err != nil {
+		t.Errorf("valid chain: %v", err)
+	}
+	if err := verifyCertificateChain(roots)([][]byte{other.Raw}, nil); err == nil {
+		t.Error("expected an error for a certificate of another CA")
+	}
+	if err := verifyCertificateChain(roots)(nil, nil); err == nil {
+		t.Error("expected an error without certificates")
+	}
+
+	cfg, err := ParseDSN("tcp(10.0.0.7)/?tls=VERIFY-CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TLSConfig != "verify-ca" || !cfg.tls.InsecureSkipVerify || cfg.tls.VerifyPeerCertificate == nil {
+		t.Errorf("unexpected TLS config for verify-ca: %+v", cfg.tls)
+	}
+	cfg, err = ParseDSN("tcp(db.example.com)/?tls=verify-full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.tls.InsecureSkipVerify || cfg.tls.ServerName != "db.example.com" {
+		t.Errorf("unexpected TLS config for verify-full: %+v", cfg.tls)
+	}
+	if err := RegisterTLSConfig("verify-ca", &tls.Config{}); err == nil {
+		t.Error("verify-ca is not reserved")
+	}
+}
+
+func TestRegisterTLSConfigNil(t *testing.T) {
+	if err := RegisterTLSConfig("nil_test", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer DeregisterTLSConfig("nil_test")
+	if err := RegisterTLSConfigForHost("nil_test", "replica1", nil); err != nil {
+		t.Fatal(err)
+	}
+}
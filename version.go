@@ -0,0 +1,82 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ServerVersion is the version the server reported in the handshake.
+type ServerVersion struct {
+	Major, Minor, Patch int
+
+	// MariaDB reports whether the server is MariaDB, whose version numbers
+	// are not comparable to the ones of MySQL.
+	MariaDB bool
+
+	// Raw is the version string of the server, e.g. "8.0.27" or
+	// "5.5.5-10.6.5-MariaDB-log".
+	Raw string
+}
+
+// AtLeast reports whether the version is major.minor.patch or later.
+func (v ServerVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+func (v ServerVersion) String() string {
+	return v.Raw
+}
+
+// mariadbVersionPrefix is prepended to the version of MariaDB servers by
+// MariaDB 10.x, for replication from MySQL 5.5.
+const mariadbVersionPrefix = "5.5.5-"
+
+// parseServerVersion parses the version string of the handshake.
+func parseServerVersion(raw string) ServerVersion {
+	v := ServerVersion{Raw: raw}
+	s := raw
+	if strings.Contains(s, "MariaDB") {
+		v.MariaDB = true
+		s = strings.TrimPrefix(s, mariadbVersionPrefix)
+	}
+	for i, n := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if i > 0 {
+			if !strings.HasPrefix(s, ".") {
+				break
+			}
+			s = s[1:]
+		}
+		end := 0
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end++
+		}
+		*n, _ = strconv.Atoi(s[:end])
+		s = s[end:]
+	}
+	return v
+}
+
+func (mc *mysqlConn) ServerVersion() ServerVersion {
+	return mc.serverVersion
+}
+
+// mariadbExtended reports whether the server negotiates MariaDB's extended
+// capabilities, which MariaDB 10.2 and later signal by not setting
+// clientLongPassword (CLIENT_MYSQL).
+func (mc *mysqlConn) mariadbExtended() bool {
+	return mc.serverVersion.MariaDB && mc.flags&clientLongPassword == 0
+}
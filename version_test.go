@@ -0,0 +1,78 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2021 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want ServerVersion
+	}{
+		{"8.0.27", ServerVersion{Major: 8, Minor: 0, Patch: 27}},
+		{"5.7.36-log", ServerVersion{Major: 5, Minor: 7, Patch: 36}},
+		{"5.5.5-10.6.5-MariaDB-log", ServerVersion{Major: 10, Minor: 6, Patch: 5, MariaDB: true}},
+		{"11.0.2-MariaDB", ServerVersion{Major: 11, Minor: 0, Patch: 2, MariaDB: true}},
+		{"8.0", ServerVersion{Major: 8}},
+		{"", ServerVersion{}},
+	}
+	for _, tt := range tests {
+		tt.want.Raw = tt.raw
+		if got := parseServerVersion(tt.raw); got != tt.want {
+			t.Errorf("parseServerVersion(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+
+	v := parseServerVersion("8.0.27")
+	if !v.AtLeast(8, 0, 27) || !v.AtLeast(5, 7, 40) || v.AtLeast(8, 0, 28) || v.AtLeast(9, 0, 0) {
+		t.Error("unexpected comparison of 8.0.27")
+	}
+}
+
+func TestMariaDBCapabilities(t *testing.T) {
+	payload := []byte{10}
+	payload = append(payload, "5.5.5-10.6.5-MariaDB"...)
+	payload = append(payload, 0,
+		1, 0, 0, 0, // connection id
+		1, 2, 3, 4, 5, 6, 7, 8, 0, // auth data, filler
+		0xfe, 0xf7, // capabilities without clientLongPassword
+		45, 2, 0, // charset, status
+		0xff, 0x81, // upper capabilities
+		21, 0, 0, 0, 0, 0, 0, // auth data length, reserved
+		0x1f, 0, 0, 0, // MariaDB capabilities
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 0)
+	payload = append(payload, "mysql_native_password"...)
+	payload = append(payload, 0)
+
+	conn, mc := newRWMockConn(0)
+	conn.data = append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+	if _, _, err := mc.readHandshakePacket(); err != nil {
+		t.Fatal(err)
+	}
+	if v := mc.ServerVersion(); !v.MariaDB || !v.AtLeast(10, 6, 5) {
+		t.Errorf("unexpected version %+v", v)
+	}
+
+	if err := mc.writeHandshakeResponsePacket(make([]byte, 20), "mysql_native_password"); err != nil {
+		t.Fatal(err)
+	}
+	pkt := conn.written
+	if flags := clientFlag(binary.LittleEndian.Uint32(pkt[4:8])); flags&clientLongPassword != 0 {
+		t.Error("clientLongPassword is set")
+	}
+	if flags := mariadbFlag(binary.LittleEndian.Uint32(pkt[32:36])); flags != mariadbClientCacheMetadata {
+		t.Errorf("expected the MariaDB capabilities %#x, got %#x", mariadbClientCacheMetadata, flags)
+	}
+	if caps := mc.AuthInfo().MariaDBCapabilities; caps != uint32(mariadbClientCacheMetadata) {
+		t.Errorf("unexpected negotiated capabilities %#x", caps)
+	}
+}